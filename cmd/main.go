@@ -3,10 +3,15 @@ package main
 import (
 	"crypto/tls"
 	"log"
+	"time"
 
 	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/intercept"
 	proxyserver "github.com/Natali-Skv/technopark_IS_http_proxy/internal/proxyServer"
 	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/repeater"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/rewrite"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scanner"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scope"
 	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger/zaplogger"
 	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/postgresql"
 	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/cert"
@@ -28,7 +33,7 @@ func main() {
 	if err := viper.Unmarshal(&servConf); err != nil {
 		log.Fatal(err)
 	}
-	caCert, err := cert.LoadCA(servConf.Proxy.CaCrt, servConf.Proxy.CaKey, servConf.Proxy.CommonName)
+	ca, err := cert.NewAuthority(servConf.Proxy.CaCrt, servConf.Proxy.CaKey, servConf.Proxy.CommonName, time.Duration(servConf.Proxy.CaGrace)*time.Hour)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -54,16 +59,39 @@ func main() {
 
 	comonMw := middleware.NewCommonMiddleware(servLogger)
 
+	proxyRepo := proxyserver.NewProxyRepository(pgxManager)
+
+	scannerRepo := scanner.NewScannerRepository(pgxManager)
+	passiveScanner := scanner.NewPassiveScanner(scannerRepo, servLogger)
+	passiveScanner.Start(servConf.Scanner.Workers)
+
+	interceptQueue := intercept.NewQueue(&servConf.Intercept)
+	rewriteEngine, err := rewrite.NewEngine(&servConf.Rewrite)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error loading rewrite rules"))
+	}
+	targetScope, err := scope.NewScope(&servConf.Scope)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error loading scope"))
+	}
+	passthrough, err := proxyserver.NewPassthroughList(&servConf.Passthrough)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error loading passthrough hosts"))
+	}
+
+	leafCerts, err := cert.NewCache(ca, &servConf.LeafCerts)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error creating leaf cert cache"))
+	}
+
 	repeaterRepo := repeater.NewRepeaterRepository(pgxManager)
-	repeaterServer := repeater.NewRepeaterServer(repeaterRepo, caCert, &tls.Config{MinVersion: tls.VersionTLS12}, nil)
+	repeaterServer := repeater.NewRepeaterServer(repeaterRepo, proxyRepo, scannerRepo, interceptQueue, rewriteEngine, targetScope, passthrough, ca, &tls.Config{MinVersion: tls.VersionTLS12}, nil)
 
 	go func() {
 		repeaterServer.ListenAndServe(&servConf.Repeater, comonMw)
 	}()
 
-	proxyRepo := proxyserver.NewProxyRepository(pgxManager)
-
-	proxyServ := proxyserver.NewProxyServer(proxyRepo, caCert, &tls.Config{MinVersion: tls.VersionTLS12}, nil)
-	proxyServ.ListenAndServe(&servConf.Proxy, comonMw)
+	proxyServ := proxyserver.NewProxyServer(proxyRepo, passiveScanner, interceptQueue, rewriteEngine, targetScope, passthrough, leafCerts, ca, &tls.Config{MinVersion: tls.VersionTLS12}, nil)
+	proxyServ.ListenAndServe(&servConf.Proxy, &servConf.Socks, &servConf.Transparent, comonMw)
 
 }
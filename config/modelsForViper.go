@@ -1,13 +1,78 @@
 package config
 
+type UpstreamProxyRule struct {
+	// host glob, empty matches every host
+	Host string
+	// http://, https:// or socks5:// proxy url, or direct
+	URL string
+}
+
+// UpstreamProxyConfig routes the outgoing connections through another proxy
+type UpstreamProxyConfig struct {
+	// proxy for the hosts no rule matches, empty or direct to connect directly
+	URL string
+	// credentials for the proxies whose url has none
+	Username string
+	Password string
+	// the first matching rule wins
+	Rules []UpstreamProxyRule
+	// host globs that are always reached directly
+	NoProxy []string
+}
+
+type UpstreamTLSHost struct {
+	// host glob
+	Host string
+	// overrides the policy's mode when not empty
+	Mode string
+	// pem bundles trusted for the matching hosts on top of the policy's roots
+	Roots []string
+}
+
+// UpstreamClientCert is a client certificate presented to the matching upstream hosts
+type UpstreamClientCert struct {
+	// host glob
+	Host string
+	// identity recorded on the requests, the certificate's subject by default
+	Name string
+	// pem files with the certificate chain and the key
+	Cert string
+	Key  string
+	// or a PKCS#12 file with both
+	PKCS12   string
+	Password string
+}
+
+// UpstreamTLSConfig is the policy for verifying the certificates of upstream servers
+type UpstreamTLSConfig struct {
+	// verify, warn or ignore, verify when empty
+	Mode string
+	// pem bundles trusted next to the system roots
+	Roots []string
+	// the first matching host wins
+	Hosts []UpstreamTLSHost
+	// client certificates for the upstreams requiring mutual tls, the first matching host wins
+	ClientCerts []UpstreamClientCert
+}
+
 type ServerConfig struct {
 	Host         string
 	Port         string
 	ReadTimeout  int
 	WriteTimeout int
-	CaCrt        string
-	CaKey        string
-	CommonName   string
+	IdleTimeout  int
+	// max stored size of a request or response body in bytes, the rest is still streamed to its peer.
+	// Body rewrite rules skip longer bodies.
+	MaxBodyCapture int
+	CaCrt          string
+	CaKey          string
+	CommonName     string
+	UpstreamProxy  UpstreamProxyConfig
+	UpstreamTLS    UpstreamTLSConfig
+	// hours the replaced CA keeps cross-signing the new one after a rotation
+	CaGrace int
+	// most attempts a single fuzzing job of the repeater may make
+	MaxFuzzAttempts int
 }
 
 func (srv ServerConfig) Addr() string {
@@ -37,9 +102,84 @@ type LogConfig struct {
 	StacktraceKey string
 }
 
+type ScannerConfig struct {
+	Workers int
+}
+
+type InterceptConfig struct {
+	Enabled   bool
+	Responses bool
+	// seconds a held exchange waits for a decision
+	Timeout int
+	// forward or drop
+	TimeoutAction string
+}
+
+// RewriteRule is a match-and-replace rule loaded at startup, see rewrite.Rule
+type RewriteRule struct {
+	Name        string
+	Disabled    bool
+	Target      string
+	Action      string
+	Match       string
+	Replace     string
+	Regex       bool
+	Header      string
+	Value       string
+	Host        string
+	Path        string
+	Method      string
+	ContentType string
+}
+
+type RewriteConfig struct {
+	Rules []RewriteRule
+}
+
+type ScopeRule struct {
+	Scheme string
+	// glob, e.g. *.example.com
+	Host string
+	Port int
+	// regular expression
+	Path string
+}
+
+type ScopeConfig struct {
+	Include []ScopeRule
+	Exclude []ScopeRule
+	// skip or flag
+	OutOfScope string
+}
+
+type PassthroughConfig struct {
+	// host globs whose CONNECT tunnels aren't decrypted
+	Hosts []string
+}
+
+type LeafCertConfig struct {
+	// p256 (the default), p521, rsa2048 or ed25519.
+	// Leaves used to always get a P-521 key, p521 brings that back.
+	KeyType string
+	// how many leaves are kept, the least recently used ones are dropped first
+	CacheSize int
+	// how many key pairs are generated ahead of time
+	PoolSize int
+	// copy the upstream leaf's subject, SANs, validity and usages into the generated leaf
+	Mirror bool
+}
+
 type Config struct {
-	Proxy    ServerConfig
-	Repeater ServerConfig
-	DB       DBConfig
-	Logger   LogConfig
+	Proxy       ServerConfig
+	Repeater    ServerConfig
+	Socks       ServerConfig
+	Transparent ServerConfig
+	DB          DBConfig
+	Logger      LogConfig
+	Scanner     ScannerConfig
+	Intercept   InterceptConfig
+	Rewrite     RewriteConfig
+	Scope       ScopeConfig
+	Passthrough PassthroughConfig
+	LeafCerts   LeafCertConfig
 }
@@ -0,0 +1,50 @@
+package intercept
+
+import (
+	"time"
+)
+
+const (
+	KindRequest  = "request"
+	KindResponse = "response"
+
+	ActionForward = "forward"
+	ActionDrop    = "drop"
+)
+
+// Rule holds the exchanges whose host and path match, empty expressions match anything.
+type Rule struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+}
+
+type Settings struct {
+	// hold every request, not only the ones matching a rule
+	Enabled bool   `json:"enabled"`
+	Rules   []Rule `json:"rules"`
+	// hold the responses to the held requests as well
+	Responses bool `json:"responses"`
+	// seconds an item waits for a decision before TimeoutAction is applied
+	Timeout       int    `json:"timeout"`
+	TimeoutAction string `json:"timeout_action"`
+}
+
+// Item is a request or a response held by the proxy.
+type Item struct {
+	ID      int64
+	Kind    string
+	Host    string
+	Path    string
+	IsHTTPS bool
+	Raw     []byte
+	Created time.Time
+	Expires time.Time
+
+	decision chan Decision
+}
+
+type Decision struct {
+	Action string
+	// edited message to forward instead of the held one, empty keeps it unchanged
+	Raw []byte
+}
@@ -0,0 +1,171 @@
+package intercept
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/pkg/errors"
+)
+
+const defaultTimeout = 120 * time.Second
+
+var ErrNoSuchItem = errors.New("no such intercepted item")
+
+type compiledRule struct {
+	host *regexp.Regexp
+	path *regexp.Regexp
+}
+
+// Queue holds intercepted exchanges until a tester forwards or drops them.
+type Queue struct {
+	mu       sync.Mutex
+	settings Settings
+	rules    []compiledRule
+	items    map[int64]*Item
+	nextID   int64
+}
+
+func NewQueue(conf *config.InterceptConfig) *Queue {
+	q := &Queue{items: map[int64]*Item{}}
+	_ = q.SetSettings(Settings{
+		Enabled:       conf.Enabled,
+		Responses:     conf.Responses,
+		Timeout:       conf.Timeout,
+		TimeoutAction: conf.TimeoutAction,
+	})
+	return q
+}
+
+func (q *Queue) Settings() Settings {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.settings
+}
+
+func (q *Queue) SetSettings(settings Settings) error {
+	switch settings.TimeoutAction {
+	case "":
+		settings.TimeoutAction = ActionForward
+	case ActionForward, ActionDrop:
+	default:
+		return errors.New("unknown timeout action " + settings.TimeoutAction)
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = int(defaultTimeout / time.Second)
+	}
+	if settings.Rules == nil {
+		settings.Rules = []Rule{}
+	}
+
+	rules := make([]compiledRule, 0, len(settings.Rules))
+	for _, rule := range settings.Rules {
+		host, err := regexp.Compile(rule.Host)
+		if err != nil {
+			return errors.Wrap(err, "bad host expression")
+		}
+		path, err := regexp.Compile(rule.Path)
+		if err != nil {
+			return errors.Wrap(err, "bad path expression")
+		}
+		rules = append(rules, compiledRule{host: host, path: path})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.settings = settings
+	q.rules = rules
+	return nil
+}
+
+// ShouldHold reports whether a request to host and path has to be held.
+func (q *Queue) ShouldHold(host, path string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.settings.Enabled {
+		return true
+	}
+	for _, rule := range q.rules {
+		if rule.host.MatchString(host) && rule.path.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldHoldResponse reports whether the response to a request to host and path has to be held.
+func (q *Queue) ShouldHoldResponse(host, path string) bool {
+	return q.Settings().Responses && q.ShouldHold(host, path)
+}
+
+// Hold queues item and blocks until it is decided on, it times out or ctx is done.
+// A cancelled ctx means the client went away, so the item is dropped.
+func (q *Queue) Hold(ctx context.Context, item *Item) Decision {
+	q.mu.Lock()
+	q.nextID++
+	item.ID = q.nextID
+	item.Created = time.Now()
+	item.Expires = item.Created.Add(time.Duration(q.settings.Timeout) * time.Second)
+	item.decision = make(chan Decision, 1)
+	timeoutAction := q.settings.TimeoutAction
+	q.items[item.ID] = item
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.items, item.ID)
+		q.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Until(item.Expires))
+	defer timer.Stop()
+	select {
+	case decision := <-item.decision:
+		return decision
+	case <-timer.C:
+		return Decision{Action: timeoutAction}
+	case <-ctx.Done():
+		return Decision{Action: ActionDrop}
+	}
+}
+
+// Items returns the held items ordered by id.
+func (q *Queue) Items() []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := make([]Item, 0, len(q.items))
+	for _, item := range q.items {
+		res = append(res, *item)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (q *Queue) Item(id int64) (Item, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[id]
+	if !ok {
+		return Item{}, ErrNoSuchItem
+	}
+	return *item, nil
+}
+
+// Decide releases a held item.
+func (q *Queue) Decide(id int64, decision Decision) error {
+	q.mu.Lock()
+	item, ok := q.items[id]
+	if ok {
+		// a second decision for the same item is not possible
+		delete(q.items, id)
+	}
+	q.mu.Unlock()
+	if !ok {
+		return ErrNoSuchItem
+	}
+	item.decision <- decision
+	return nil
+}
@@ -0,0 +1,245 @@
+package intercept
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+)
+
+// waitItems waits until n items are held and returns them
+func waitItems(t *testing.T, q *Queue, n int) []Item {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		items := q.Items()
+		if len(items) == n {
+			return items
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d items held, want %d", len(items), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// hold holds item in the background, the decision is sent on the returned channel
+func hold(ctx context.Context, q *Queue, item *Item) <-chan Decision {
+	res := make(chan Decision, 1)
+	go func() {
+		res <- q.Hold(ctx, item)
+	}()
+	return res
+}
+
+func waitDecision(t *testing.T, decisions <-chan Decision, within time.Duration) Decision {
+	t.Helper()
+	select {
+	case decision := <-decisions:
+		return decision
+	case <-time.After(within):
+		t.Fatal("Hold didn't return")
+		return Decision{}
+	}
+}
+
+func TestHoldDecide(t *testing.T) {
+	raw := []byte("GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	edited := []byte("GET /b HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	tests := []struct {
+		name     string
+		decision Decision
+	}{
+		{"forward unchanged", Decision{Action: ActionForward}},
+		{"forward with an edit", Decision{Action: ActionForward, Raw: edited}},
+		{"drop", Decision{Action: ActionDrop}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(&config.InterceptConfig{Enabled: true})
+			decisions := hold(context.Background(), q, &Item{Kind: KindRequest, Host: "example.com", Path: "/a", Raw: raw})
+
+			items := waitItems(t, q, 1)
+			if string(items[0].Raw) != string(raw) || items[0].Host != "example.com" {
+				t.Fatalf("held item = %+v", items[0])
+			}
+			if !items[0].Expires.Equal(items[0].Created.Add(defaultTimeout)) {
+				t.Errorf("item expires %v after it was held", items[0].Expires.Sub(items[0].Created))
+			}
+			if err := q.Decide(items[0].ID, tt.decision); err != nil {
+				t.Fatalf("Decide: %v", err)
+			}
+
+			got := waitDecision(t, decisions, 5*time.Second)
+			if got.Action != tt.decision.Action || string(got.Raw) != string(tt.decision.Raw) {
+				t.Errorf("decision = %+v, want %+v", got, tt.decision)
+			}
+			if err := q.Decide(items[0].ID, Decision{Action: ActionForward}); err != ErrNoSuchItem {
+				t.Errorf("second Decide error = %v, want ErrNoSuchItem", err)
+			}
+			if _, err := q.Item(items[0].ID); err != ErrNoSuchItem {
+				t.Errorf("Item error = %v for a decided item", err)
+			}
+		})
+	}
+}
+
+func TestHoldTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		want   string
+	}{
+		{"forward by default", "", ActionForward},
+		{"forward", ActionForward, ActionForward},
+		{"drop", ActionDrop, ActionDrop},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			q := NewQueue(&config.InterceptConfig{Enabled: true, Timeout: 1, TimeoutAction: tt.action})
+			start := time.Now()
+			decisions := hold(context.Background(), q, &Item{Kind: KindRequest, Raw: []byte("GET / HTTP/1.1\r\n\r\n")})
+
+			got := waitDecision(t, decisions, 5*time.Second)
+			if got.Action != tt.want || got.Raw != nil {
+				t.Errorf("decision = %+v, want %s", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < time.Second {
+				t.Errorf("timed out after %v", elapsed)
+			}
+			if items := q.Items(); len(items) != 0 {
+				t.Errorf("%d items still held", len(items))
+			}
+		})
+	}
+}
+
+func TestHoldCancelled(t *testing.T) {
+	q := NewQueue(&config.InterceptConfig{Enabled: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	decisions := hold(ctx, q, &Item{Kind: KindRequest})
+	items := waitItems(t, q, 1)
+
+	cancel()
+	if got := waitDecision(t, decisions, 5*time.Second); got.Action != ActionDrop {
+		t.Errorf("decision = %+v when the client went away, want drop", got)
+	}
+	if err := q.Decide(items[0].ID, Decision{Action: ActionForward}); err != ErrNoSuchItem {
+		t.Errorf("Decide error = %v for a dropped item", err)
+	}
+}
+
+func TestHoldConcurrentWaiters(t *testing.T) {
+	q := NewQueue(&config.InterceptConfig{Enabled: true})
+	const waiters = 20
+
+	decisions := make([]<-chan Decision, waiters)
+	for i := range decisions {
+		decisions[i] = hold(context.Background(), q, &Item{Kind: KindRequest, Path: fmt.Sprintf("/%d", i)})
+	}
+	items := waitItems(t, q, waiters)
+
+	paths := map[string]int64{}
+	for i, item := range items {
+		if i > 0 && item.ID <= items[i-1].ID {
+			t.Fatalf("items aren't ordered by id: %d after %d", item.ID, items[i-1].ID)
+		}
+		paths[item.Path] = item.ID
+	}
+	if len(paths) != waiters {
+		t.Fatalf("%d distinct items held, want %d", len(paths), waiters)
+	}
+
+	// every waiter gets the decision made for its own item, whatever the order they are made in
+	var wg sync.WaitGroup
+	for _, item := range items {
+		wg.Add(1)
+		go func(item Item) {
+			defer wg.Done()
+			action := ActionForward
+			if item.ID%2 == 0 {
+				action = ActionDrop
+			}
+			if err := q.Decide(item.ID, Decision{Action: action, Raw: []byte(item.Path)}); err != nil {
+				t.Errorf("Decide(%d): %v", item.ID, err)
+			}
+		}(item)
+	}
+	wg.Wait()
+
+	for i, decisions := range decisions {
+		path := fmt.Sprintf("/%d", i)
+		got := waitDecision(t, decisions, 5*time.Second)
+		want := ActionForward
+		if paths[path]%2 == 0 {
+			want = ActionDrop
+		}
+		if got.Action != want || string(got.Raw) != path {
+			t.Errorf("waiter %s got %s %q, want %s %q", path, got.Action, got.Raw, want, path)
+		}
+	}
+	if items := q.Items(); len(items) != 0 {
+		t.Errorf("%d items still held", len(items))
+	}
+}
+
+func TestShouldHold(t *testing.T) {
+	q := NewQueue(&config.InterceptConfig{})
+	if err := q.SetSettings(Settings{Rules: []Rule{{Host: `^api\.example\.com$`, Path: "^/admin"}, {Host: "internal"}}}); err != nil {
+		t.Fatalf("SetSettings: %v", err)
+	}
+
+	tests := []struct {
+		host string
+		path string
+		want bool
+	}{
+		{"api.example.com", "/admin/users", true},
+		{"api.example.com", "/public", false},
+		{"www.example.com", "/admin", false},
+		{"internal.example.com", "/anything", true},
+	}
+	for _, tt := range tests {
+		if got := q.ShouldHold(tt.host, tt.path); got != tt.want {
+			t.Errorf("ShouldHold(%q, %q) = %v, want %v", tt.host, tt.path, got, tt.want)
+		}
+		if q.ShouldHoldResponse(tt.host, tt.path) {
+			t.Errorf("ShouldHoldResponse(%q, %q) with responses off", tt.host, tt.path)
+		}
+	}
+
+	if err := q.SetSettings(Settings{Enabled: true, Responses: true}); err != nil {
+		t.Fatalf("SetSettings: %v", err)
+	}
+	if !q.ShouldHold("anything", "/") || !q.ShouldHoldResponse("anything", "/") {
+		t.Error("enabled interception doesn't hold everything")
+	}
+}
+
+func TestSetSettingsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+	}{
+		{"unknown timeout action", Settings{TimeoutAction: "wait"}},
+		{"bad host expression", Settings{Rules: []Rule{{Host: "("}}}},
+		{"bad path expression", Settings{Rules: []Rule{{Path: "["}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(&config.InterceptConfig{Enabled: true})
+			if err := q.SetSettings(tt.settings); err == nil {
+				t.Fatal("SetSettings accepted broken settings")
+			}
+			if settings := q.Settings(); !settings.Enabled || settings.TimeoutAction != ActionForward {
+				t.Errorf("settings = %+v after a rejected update", settings)
+			}
+		})
+	}
+}
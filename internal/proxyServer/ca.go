@@ -0,0 +1,49 @@
+package proxyserver
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/cert"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/labstack/echo/v4"
+)
+
+// devices configured to use the proxy fetch the CA certificate from this host through the proxy itself
+const caHost = "proxy.ca"
+
+// formats served on the CA host, the DER file is what browsers and phones offer to install
+var caPaths = map[string]string{
+	"/":         cert.FormatDER,
+	"/cert.der": cert.FormatDER,
+	"/cert.crt": cert.FormatDER,
+	"/cert.pem": cert.FormatPEM,
+	"/cert.p12": cert.FormatPKCS12,
+}
+
+func isCAHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	return strings.EqualFold(host, caHost)
+}
+
+// serveCA answers requests to the CA host with the CA certificate instead of forwarding them
+func (ps *ProxyServer) serveCA(ctx echo.Context) error {
+	path := ctx.Request().URL.Path
+	if path == "" {
+		path = "/"
+	}
+	format, ok := caPaths[path]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.BAD_CA_FORMAT)
+	}
+	data, contentType, err := ps.CA.Export(format, ctx.QueryParam("password"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="proxy-ca.`+format+`"`)
+	return ctx.Blob(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,147 @@
+package proxyserver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const defaultMaxBodyCapture = 10 << 20
+
+// bodyCapture keeps the first limit bytes written to it and silently drops the rest,
+// so it can sit next to the client in an io.MultiWriter without ever failing the copy.
+type bodyCapture struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (ps *ProxyServer) newBodyCapture() *bodyCapture {
+	return &bodyCapture{limit: ps.bodyCaptureLimit()}
+}
+
+// bodyCaptureLimit is the size of the bodies that are stored and rewritten in full
+func (ps *ProxyServer) bodyCaptureLimit() int {
+	if ps.maxBodyCapture <= 0 {
+		return defaultMaxBodyCapture
+	}
+	return ps.maxBodyCapture
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	room := c.limit - c.buf.Len()
+	if len(p) > room {
+		c.truncated = true
+		if room > 0 {
+			c.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	c.buf.Write(p)
+	return len(p), nil
+}
+
+func (c *bodyCapture) Bytes() []byte {
+	return c.buf.Bytes()
+}
+
+func (c *bodyCapture) Truncated() bool {
+	return c.truncated
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// requestBodyTee captures a request body while it is forwarded. The capture is complete
+// once the body was read through or closed, which transports may do from another goroutine.
+type requestBodyTee struct {
+	body    io.ReadCloser
+	mu      sync.Mutex
+	capture *bodyCapture
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (t *requestBodyTee) Read(p []byte) (int, error) {
+	n, err := t.body.Read(p)
+	t.mu.Lock()
+	t.capture.Write(p[:n])
+	t.mu.Unlock()
+	if err == io.EOF {
+		t.finish()
+	}
+	return n, err
+}
+
+func (t *requestBodyTee) Close() error {
+	t.finish()
+	return t.body.Close()
+}
+
+func (t *requestBodyTee) finish() {
+	t.once.Do(func() { close(t.done) })
+}
+
+// captured waits until the body is done and returns its captured start and whether it was cut
+func (t *requestBodyTee) captured() ([]byte, bool) {
+	<-t.done
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.capture.Bytes(), t.capture.Truncated()
+}
+
+// captureRequest describes request for storing before it is forwarded. Its body is only known
+// once it has been forwarded, so a request with a body is stored without one at first:
+// its body is captured on the way and the returned tee must be passed to completeRequest.
+// The tee is nil for a request without a body.
+func (ps *ProxyServer) captureRequest(request *http.Request) (*Request, *requestBodyTee, error) {
+	var tee *requestBodyTee
+	if request.Body != nil && request.Body != http.NoBody && request.ContentLength != 0 {
+		tee = &requestBodyTee{body: request.Body, capture: ps.newBodyCapture(), done: make(chan struct{})}
+		request.Body = tee
+	}
+	dump, err := httputil.DumpRequest(request, false)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "dump request error")
+	}
+	return FormRequestData(request, dump, nil), tee, nil
+}
+
+// completeRequest stores the captured body of a request stored by captureRequest under reqID once the exchange is over.
+// The part of the body the upstream didn't take by then is dropped.
+func (ps *ProxyServer) completeRequest(reqID uint, repoReq *Request, request *http.Request, tee *requestBodyTee) error {
+	if tee == nil {
+		return nil
+	}
+	_ = tee.Close()
+	if reqID == unrecordedID {
+		return nil
+	}
+	body, truncated := tee.captured()
+	dump, err := dumpRequest(request, body)
+	if err != nil {
+		return err
+	}
+	full := FormRequestData(request, dump, body)
+	repoReq.Body, repoReq.BodyTruncated = full.Body, truncated
+	repoReq.DecodedBody, repoReq.Charset = full.DecodedBody, full.Charset
+	repoReq.Raw, repoReq.PostParams = full.Raw, full.PostParams
+	return ps.repo.UpdateRequestBody(reqID, repoReq)
+}
+
+// dumpRequest renders the request as it was received, with the captured (possibly truncated) body
+func dumpRequest(request *http.Request, body []byte) ([]byte, error) {
+	dumpReq := *request
+	dumpReq.Body = io.NopCloser(bytes.NewReader(body))
+	dump, err := httputil.DumpRequest(&dumpReq, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "dump request error")
+	}
+	return dump, nil
+}
@@ -0,0 +1,240 @@
+package proxyserver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyCapture(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int
+		writes        []string
+		want          string
+		wantTruncated bool
+	}{
+		{"under the limit", 8, []string{"abc", "de"}, "abcde", false},
+		{"exactly the limit", 5, []string{"abc", "de"}, "abcde", false},
+		{"cut in a write", 4, []string{"abc", "de"}, "abcd", true},
+		{"writes past the limit", 3, []string{"abc", "de", "f"}, "abc", true},
+		{"empty writes", 3, []string{"", "abc", ""}, "abc", false},
+		{"nothing stored", 0, []string{"a"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capture := &bodyCapture{limit: tt.limit}
+			for _, w := range tt.writes {
+				// the capture never fails the copy to the client
+				if n, err := capture.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if string(capture.Bytes()) != tt.want || capture.Truncated() != tt.wantTruncated {
+				t.Errorf("captured %q, truncated %v, want %q, %v", capture.Bytes(), capture.Truncated(), tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestResponseBodyCapture(t *testing.T) {
+	const limit = 64
+	body := strings.Repeat("0123456789", 100)
+	ps := &ProxyServer{maxBodyCapture: limit}
+
+	t.Run("copied to the client", func(t *testing.T) {
+		client := httptest.NewRecorder()
+		capture := ps.newBodyCapture()
+		if _, err := io.Copy(io.MultiWriter(client, capture), strings.NewReader(body)); err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if client.Body.String() != body {
+			t.Errorf("client got %d bytes, want the whole body of %d", client.Body.Len(), len(body))
+		}
+		if string(capture.Bytes()) != body[:limit] || !capture.Truncated() {
+			t.Errorf("captured %d bytes, truncated %v", len(capture.Bytes()), capture.Truncated())
+		}
+	})
+
+	t.Run("read by the client", func(t *testing.T) {
+		capture := ps.newBodyCapture()
+		upstreamBody := io.NopCloser(strings.NewReader(body))
+		teed := readCloser{Reader: io.TeeReader(upstreamBody, capture), Closer: upstreamBody}
+		got, err := io.ReadAll(teed)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(got) != body {
+			t.Errorf("client read %d bytes, want the whole body of %d", len(got), len(body))
+		}
+		if string(capture.Bytes()) != body[:limit] || !capture.Truncated() {
+			t.Errorf("captured %d bytes, truncated %v", len(capture.Bytes()), capture.Truncated())
+		}
+	})
+}
+
+func TestRequestBodyTee(t *testing.T) {
+	const limit = 8
+	newTee := func(body string) *requestBodyTee {
+		return &requestBodyTee{
+			body:    io.NopCloser(strings.NewReader(body)),
+			capture: &bodyCapture{limit: limit},
+			done:    make(chan struct{}),
+		}
+	}
+	type capturedBody struct {
+		body      []byte
+		truncated bool
+	}
+	// wait starts waiting for the capture of tee
+	wait := func(tee *requestBodyTee) <-chan capturedBody {
+		res := make(chan capturedBody, 1)
+		go func() {
+			body, truncated := tee.captured()
+			res <- capturedBody{body, truncated}
+		}()
+		return res
+	}
+
+	t.Run("read through", func(t *testing.T) {
+		tee := newTee("0123456789abcdef")
+		captured := wait(tee)
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(tee, buf); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-captured:
+			t.Fatal("capture done before the body was")
+		case <-time.After(20 * time.Millisecond):
+		}
+		rest, err := io.ReadAll(tee)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf)+string(rest) != "0123456789abcdef" {
+			t.Errorf("forwarded %q", string(buf)+string(rest))
+		}
+		// done at EOF, without a Close
+		got := <-captured
+		if string(got.body) != "01234567" || !got.truncated {
+			t.Errorf("captured %q, truncated %v", got.body, got.truncated)
+		}
+	})
+
+	t.Run("closed by the transport", func(t *testing.T) {
+		tee := newTee("0123456789abcdef")
+		captured := wait(tee)
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(tee, buf); err != nil {
+			t.Fatal(err)
+		}
+		go tee.Close()
+		select {
+		case got := <-captured:
+			if string(got.body) != "012" || got.truncated {
+				t.Errorf("captured %q, truncated %v, want the part read before the close", got.body, got.truncated)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("capture isn't done once the body is closed")
+		}
+		if err := tee.Close(); err != nil {
+			t.Errorf("second Close: %v", err)
+		}
+	})
+}
+
+func TestCaptureRequestBodyCap(t *testing.T) {
+	const limit = 16
+	longForm := "user=" + strings.Repeat("a", 100) + "&pass=secret"
+
+	tests := []struct {
+		name          string
+		body          string
+		wantStored    string
+		wantTruncated bool
+		wantParams    Map
+	}{
+		{
+			name:       "body under the cap",
+			body:       "user=u&pass=p",
+			wantStored: "user=u&pass=p",
+			wantParams: Map{"user": "u", "pass": "p"},
+		},
+		{
+			name:       "body at the cap",
+			body:       "user=u&pass=pass",
+			wantStored: "user=u&pass=pass",
+			wantParams: Map{"user": "u", "pass": "pass"},
+		},
+		{
+			name:          "body over the cap",
+			body:          longForm,
+			wantStored:    longForm[:limit],
+			wantTruncated: true,
+			wantParams:    Map{"user": longForm[len("user="):limit]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ = io.ReadAll(r.Body)
+			}))
+			defer srv.Close()
+
+			ps := &ProxyServer{maxBodyCapture: limit}
+			request, _ := http.NewRequest(http.MethodPost, srv.URL+"/login", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			repoReq, tee, err := ps.captureRequest(request)
+			if err != nil {
+				t.Fatalf("captureRequest: %v", err)
+			}
+			if tee == nil || len(repoReq.Body) != 0 {
+				t.Fatal("a request with a body is stored with it before it is forwarded")
+			}
+
+			resp, err := http.DefaultTransport.RoundTrip(request)
+			if err != nil {
+				t.Fatalf("RoundTrip: %v", err)
+			}
+			resp.Body.Close()
+			if string(received) != tt.body {
+				t.Errorf("upstream received %d bytes, want the whole body of %d", len(received), len(tt.body))
+			}
+
+			_ = tee.Close()
+			body, truncated := tee.captured()
+			if string(body) != tt.wantStored || truncated != tt.wantTruncated {
+				t.Errorf("captured %q, truncated %v, want %q, %v", body, truncated, tt.wantStored, tt.wantTruncated)
+			}
+			stored := FormRequestData(request, nil, body)
+			if !bytes.Equal(stored.Body, []byte(tt.wantStored)) {
+				t.Errorf("stored body = %q", stored.Body)
+			}
+			for name, want := range tt.wantParams {
+				if got := stored.PostParams[name]; got != want {
+					t.Errorf("post param %s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureRequestWithoutBody(t *testing.T) {
+	ps := &ProxyServer{}
+	request, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if _, tee, err := ps.captureRequest(request); err != nil || tee != nil {
+		t.Errorf("captureRequest() tee = %v, error = %v for a request without a body", tee, err)
+	}
+	if err := ps.completeRequest(1, &Request{}, request, nil); err != nil {
+		t.Errorf("completeRequest: %v", err)
+	}
+	if limit := ps.bodyCaptureLimit(); limit != defaultMaxBodyCapture {
+		t.Errorf("default capture limit = %d, want %d", limit, defaultMaxBodyCapture)
+	}
+}
@@ -0,0 +1,332 @@
+package proxyserver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
+	"github.com/pkg/errors"
+)
+
+// tls record and handshake values, see RFC 8446
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+	// crypto/tls only has a deprecated constant for it
+	versionSSL30 = 0x0300
+
+	extensionServerName          = 0x0000
+	extensionSupportedGroups     = 0x000a
+	extensionECPointFormats      = 0x000b
+	extensionSignatureAlgorithms = 0x000d
+	extensionALPN                = 0x0010
+	extensionSupportedVersions   = 0x002b
+)
+
+var tlsVersionNames = map[uint16]string{
+	versionSSL30:     "SSLv3",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// JA4 abbreviations of the tls versions
+var ja4Versions = map[uint16]string{
+	versionSSL30:     "s3",
+	tls.VersionTLS10: "10",
+	tls.VersionTLS11: "11",
+	tls.VersionTLS12: "12",
+	tls.VersionTLS13: "13",
+}
+
+// clientHello holds the fields of a ClientHello that make up its fingerprint, GREASE values left out
+type clientHello struct {
+	version           uint16
+	cipherSuites      []uint16
+	extensions        []uint16
+	groups            []uint16
+	pointFormats      []uint8
+	signatureSchemes  []uint16
+	supportedVersions []uint16
+	serverName        string
+	alpn              []string
+}
+
+// helloCaptureConn keeps the bytes the client sends until the ClientHello has been taken
+type helloCaptureConn struct {
+	net.Conn
+	recorded []byte
+	taken    bool
+}
+
+func (c *helloCaptureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.taken {
+		c.recorded = append(c.recorded, b[:n]...)
+	}
+	return n, err
+}
+
+// take returns the bytes read so far, which hold the whole ClientHello once crypto/tls asks for a certificate
+func (c *helloCaptureConn) take() []byte {
+	c.taken = true
+	recorded := c.recorded
+	c.recorded = nil
+	return recorded
+}
+
+// recordTLSSession stores the fingerprint of the client's hello along with the handshake made with the upstream.
+// It returns the id of the stored session, nil if it couldn't be stored.
+func (ps *ProxyServer) recordTLSSession(logger *servLog.ServLogger, requestId uint64, hello *clientHello, connToUpstream *tls.Conn) *int64 {
+	state := connToUpstream.ConnectionState()
+	session := &TLSSession{
+		UpstreamVersion: tlsVersionName(state.Version),
+		UpstreamCipher:  tls.CipherSuiteName(state.CipherSuite),
+		UpstreamALPN:    state.NegotiatedProtocol,
+		UpstreamChain:   tlsverify.DescribeChain(state.PeerCertificates),
+	}
+	if hello != nil {
+		session.SNI = hello.serverName
+		session.ClientVersion = tlsVersionName(hello.maxVersion())
+		session.JA3, session.JA3Hash = hello.JA3()
+		session.JA4 = hello.JA4()
+		session.CipherSuites = hello.CipherSuiteNames()
+		session.ALPN = hello.alpn
+	}
+	id, err := ps.repo.InsertTLSSession(session)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "inserting tls session to db error").Error())
+		return nil
+	}
+	return &id
+}
+
+// isGREASE reports whether v is one of the reserved values clients send to keep servers tolerant, see RFC 8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// helloReader reads the big-endian fields of a handshake message
+type helloReader struct {
+	data []byte
+	err  error
+}
+
+func (r *helloReader) bytes(n int) []byte {
+	if r.err != nil || len(r.data) < n {
+		r.err = errors.New("client hello is truncated")
+		return nil
+	}
+	res := r.data[:n]
+	r.data = r.data[n:]
+	return res
+}
+
+func (r *helloReader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *helloReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// vector reads a field prefixed with its length of lenBytes bytes
+func (r *helloReader) vector(lenBytes int) *helloReader {
+	var n int
+	switch lenBytes {
+	case 1:
+		n = int(r.uint8())
+	case 2:
+		n = int(r.uint16())
+	case 3:
+		if b := r.bytes(3); b != nil {
+			n = int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+	}
+	return &helloReader{data: r.bytes(n), err: r.err}
+}
+
+func (r *helloReader) uint16s() []uint16 {
+	var res []uint16
+	for len(r.data) > 0 && r.err == nil {
+		if v := r.uint16(); !isGREASE(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// parseClientHello parses the ClientHello at the start of the tls records the client sent
+func parseClientHello(records []byte) (*clientHello, error) {
+	// the ClientHello may be split over several records
+	var message []byte
+	for len(records) >= 5 && records[0] == recordTypeHandshake {
+		length := int(binary.BigEndian.Uint16(records[3:5]))
+		if len(records) < 5+length {
+			break
+		}
+		message = append(message, records[5:5+length]...)
+		records = records[5+length:]
+	}
+	r := &helloReader{data: message}
+	if r.uint8() != handshakeTypeClientHello {
+		return nil, errors.New("not a client hello")
+	}
+	r = r.vector(3)
+
+	hello := &clientHello{version: r.uint16()}
+	r.bytes(32)
+	r.vector(1)
+	hello.cipherSuites = r.vector(2).uint16s()
+	r.vector(1)
+	extensions := r.vector(2)
+	for len(extensions.data) > 0 && extensions.err == nil {
+		extType := extensions.uint16()
+		ext := extensions.vector(2)
+		if isGREASE(extType) {
+			continue
+		}
+		hello.extensions = append(hello.extensions, extType)
+		switch extType {
+		case extensionServerName:
+			names := ext.vector(2)
+			for len(names.data) > 0 && names.err == nil {
+				nameType := names.uint8()
+				name := names.vector(2)
+				if nameType == 0 {
+					hello.serverName = string(name.data)
+				}
+			}
+		case extensionSupportedGroups:
+			hello.groups = ext.vector(2).uint16s()
+		case extensionECPointFormats:
+			hello.pointFormats = ext.vector(1).data
+		case extensionSignatureAlgorithms:
+			hello.signatureSchemes = ext.vector(2).uint16s()
+		case extensionALPN:
+			protos := ext.vector(2)
+			for len(protos.data) > 0 && protos.err == nil {
+				hello.alpn = append(hello.alpn, string(protos.vector(1).data))
+			}
+		case extensionSupportedVersions:
+			hello.supportedVersions = ext.vector(1).uint16s()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if extensions.err != nil {
+		return nil, extensions.err
+	}
+	return hello, nil
+}
+
+// maxVersion is the highest version the client offers
+func (h *clientHello) maxVersion() uint16 {
+	version := h.version
+	for _, v := range h.supportedVersions {
+		if v > version {
+			version = v
+		}
+	}
+	return version
+}
+
+// JA3 returns the JA3 string of the hello and its md5 hash
+func (h *clientHello) JA3() (string, string) {
+	pointFormats := make([]uint16, 0, len(h.pointFormats))
+	for _, f := range h.pointFormats {
+		pointFormats = append(pointFormats, uint16(f))
+	}
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinUint16(h.cipherSuites, "-", "%d"),
+		joinUint16(h.extensions, "-", "%d"),
+		joinUint16(h.groups, "-", "%d"),
+		joinUint16(pointFormats, "-", "%d"),
+	}, ",")
+	sum := md5.Sum([]byte(ja3))
+	return ja3, hex.EncodeToString(sum[:])
+}
+
+// JA4 returns the JA4 fingerprint of the hello received over tcp
+func (h *clientHello) JA4() string {
+	version, ok := ja4Versions[h.maxVersion()]
+	if !ok {
+		version = "00"
+	}
+	sni := "i"
+	if h.serverName != "" {
+		sni = "d"
+	}
+	alpn := "00"
+	if len(h.alpn) > 0 && h.alpn[0] != "" {
+		first := h.alpn[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", version, sni, minInt(len(h.cipherSuites), 99), minInt(len(h.extensions), 99), alpn)
+
+	ciphers := append([]uint16{}, h.cipherSuites...)
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+	extensions := make([]uint16, 0, len(h.extensions))
+	for _, ext := range h.extensions {
+		if ext != extensionServerName && ext != extensionALPN {
+			extensions = append(extensions, ext)
+		}
+	}
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i] < extensions[j] })
+	c := joinUint16(extensions, ",", "%04x")
+	if len(h.signatureSchemes) > 0 {
+		c += "_" + joinUint16(h.signatureSchemes, ",", "%04x")
+	}
+	return a + "_" + truncatedHash(joinUint16(ciphers, ",", "%04x"), len(ciphers)) + "_" + truncatedHash(c, len(extensions))
+}
+
+// CipherSuiteNames returns the names of the offered cipher suites
+func (h *clientHello) CipherSuiteNames() []string {
+	res := make([]string, 0, len(h.cipherSuites))
+	for _, id := range h.cipherSuites {
+		res = append(res, tls.CipherSuiteName(id))
+	}
+	return res
+}
+
+// truncatedHash is the first 12 hex digits of the sha256 of s, zeros when there is nothing to hash
+func truncatedHash(s string, count int) string {
+	if count == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func joinUint16(values []uint16, sep, format string) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf(format, v))
+	}
+	return strings.Join(parts, sep)
+}
+
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
@@ -0,0 +1,232 @@
+package proxyserver
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// helloExtension is an extension of a ClientHello built by buildClientHello
+type helloExtension struct {
+	extType uint16
+	data    []byte
+}
+
+func u16s(values ...uint16) []byte {
+	res := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		res = binary.BigEndian.AppendUint16(res, v)
+	}
+	return res
+}
+
+// prefixed prepends the length of data on lenBytes bytes
+func prefixed(lenBytes int, data []byte) []byte {
+	res := make([]byte, lenBytes, lenBytes+len(data))
+	for i := 0; i < lenBytes; i++ {
+		res[lenBytes-1-i] = byte(len(data) >> (8 * i))
+	}
+	return append(res, data...)
+}
+
+func sniExtension(name string) helloExtension {
+	entry := append([]byte{0}, prefixed(2, []byte(name))...)
+	return helloExtension{extensionServerName, prefixed(2, entry)}
+}
+
+func alpnExtension(protos ...string) helloExtension {
+	var list []byte
+	for _, proto := range protos {
+		list = append(list, prefixed(1, []byte(proto))...)
+	}
+	return helloExtension{extensionALPN, prefixed(2, list)}
+}
+
+// buildClientHello returns a ClientHello handshake message
+func buildClientHello(version uint16, ciphers []uint16, extensions []helloExtension) []byte {
+	body := u16s(version)
+	body = append(body, make([]byte, 32)...)
+	body = append(body, prefixed(1, make([]byte, 32))...)
+	body = append(body, prefixed(2, u16s(ciphers...))...)
+	body = append(body, prefixed(1, []byte{0})...)
+	var exts []byte
+	for _, ext := range extensions {
+		exts = append(exts, u16s(ext.extType)...)
+		exts = append(exts, prefixed(2, ext.data)...)
+	}
+	body = append(body, prefixed(2, exts)...)
+	return append([]byte{handshakeTypeClientHello}, prefixed(3, body)...)
+}
+
+// records splits message into tls handshake records of at most size bytes
+func records(message []byte, size int) []byte {
+	var res []byte
+	for len(message) > 0 {
+		n := size
+		if n > len(message) {
+			n = len(message)
+		}
+		res = append(res, recordTypeHandshake, 0x03, 0x01)
+		res = append(res, prefixed(2, message[:n])...)
+		message = message[n:]
+	}
+	return res
+}
+
+// the example of the JA3 readme
+var ja3ReadmeHello = buildClientHello(0x0301,
+	[]uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+	[]helloExtension{
+		sniExtension("example.com"),
+		{extensionSupportedGroups, prefixed(2, u16s(23, 24, 25))},
+		{extensionECPointFormats, prefixed(1, []byte{0})},
+	})
+
+// a Chrome hello with GREASE values, whose JA4 parts are the example of the JA4 spec
+var chromeHello = buildClientHello(0x0303,
+	[]uint16{0x3a3a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014,
+		0x009c, 0x009d, 0x002f, 0x0035},
+	[]helloExtension{
+		{0x8a8a, nil},
+		sniExtension("www.example.org"),
+		{0x0017, nil},
+		{0xff01, []byte{0}},
+		{extensionSupportedGroups, prefixed(2, u16s(0x2a2a, 29, 23, 24))},
+		{extensionECPointFormats, prefixed(1, []byte{0})},
+		{0x0023, nil},
+		alpnExtension("h2", "http/1.1"),
+		{0x0005, []byte{1, 0, 0, 0, 0}},
+		{extensionSignatureAlgorithms, prefixed(2, u16s(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))},
+		{0x0012, nil},
+		{0x0033, prefixed(2, append(u16s(0x2a2a, 1), 0))},
+		{0x002d, prefixed(1, []byte{1})},
+		{extensionSupportedVersions, prefixed(1, u16s(0x5a5a, 0x0304, 0x0303))},
+		{0x001b, prefixed(1, u16s(2))},
+		{0x4469, prefixed(2, prefixed(1, []byte("h2")))},
+		{0x1a1a, []byte{0}},
+		{0x0015, make([]byte, 16)},
+	})
+
+func TestParseClientHelloFingerprints(t *testing.T) {
+	tests := []struct {
+		name        string
+		records     []byte
+		wantJA3     string
+		wantJA3Hash string
+		wantJA4     string
+		wantSNI     string
+		wantALPN    []string
+		wantVersion uint16
+	}{
+		{
+			name:        "ja3 readme example",
+			records:     records(ja3ReadmeHello, 1<<14),
+			wantJA3:     "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			wantJA3Hash: "ada70206e40642a3e4461f35503241d5",
+			wantJA4:     "t10d120300_d94e65cdb899_33a13ba74d1c",
+			wantSNI:     "example.com",
+			wantVersion: 0x0301,
+		},
+		{
+			name:    "chrome with grease",
+			records: records(chromeHello, 1<<14),
+			wantJA3: "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+				"0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0",
+			wantJA3Hash: "cd08e31494f9531f560d64c695473da9",
+			wantJA4:     "t13d1516h2_8daaf6152771_e5627efa2ab1",
+			wantSNI:     "www.example.org",
+			wantALPN:    []string{"h2", "http/1.1"},
+			wantVersion: 0x0304,
+		},
+		{
+			name:    "chrome split over records",
+			records: records(chromeHello, 100),
+			wantJA3: "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+				"0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0",
+			wantJA3Hash: "cd08e31494f9531f560d64c695473da9",
+			wantJA4:     "t13d1516h2_8daaf6152771_e5627efa2ab1",
+			wantSNI:     "www.example.org",
+			wantALPN:    []string{"h2", "http/1.1"},
+			wantVersion: 0x0304,
+		},
+		{
+			name:        "no extensions",
+			records:     records(buildClientHello(0x0303, []uint16{0x002f}, nil), 1<<14),
+			wantJA3:     "771,47,,,",
+			wantJA3Hash: "fde4273625b2ac63bd01d9c500dac91b",
+			wantJA4:     "t12i010000_ba72b8082249_000000000000",
+			wantVersion: 0x0303,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello, err := parseClientHello(tt.records)
+			if err != nil {
+				t.Fatalf("parseClientHello: %v", err)
+			}
+			ja3, ja3Hash := hello.JA3()
+			if ja3 != tt.wantJA3 {
+				t.Errorf("JA3 = %q, want %q", ja3, tt.wantJA3)
+			}
+			if ja3Hash != tt.wantJA3Hash {
+				t.Errorf("JA3 hash = %q, want %q", ja3Hash, tt.wantJA3Hash)
+			}
+			if ja4 := hello.JA4(); ja4 != tt.wantJA4 {
+				t.Errorf("JA4 = %q, want %q", ja4, tt.wantJA4)
+			}
+			if hello.serverName != tt.wantSNI {
+				t.Errorf("server name = %q, want %q", hello.serverName, tt.wantSNI)
+			}
+			if !reflect.DeepEqual(hello.alpn, tt.wantALPN) {
+				t.Errorf("alpn = %q, want %q", hello.alpn, tt.wantALPN)
+			}
+			if v := hello.maxVersion(); v != tt.wantVersion {
+				t.Errorf("max version = %#04x, want %#04x", v, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestParseClientHelloErrors(t *testing.T) {
+	full := records(chromeHello, 1<<14)
+	serverHello := append([]byte{0x02}, chromeHello[1:]...)
+
+	tests := []struct {
+		name    string
+		records []byte
+	}{
+		{"empty", nil},
+		{"not a handshake record", append([]byte{0x17}, full[1:]...)},
+		{"not a client hello", records(serverHello, 1<<14)},
+		{"record cut short", full[:len(full)-1]},
+		{"message cut short", records(chromeHello[:len(chromeHello)-10], 1<<14)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseClientHello(tt.records); err == nil {
+				t.Error("parseClientHello accepted a broken hello")
+			}
+		})
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	tests := []struct {
+		value uint16
+		want  bool
+	}{
+		{0x0a0a, true},
+		{0x1a1a, true},
+		{0xfafa, true},
+		{0x0a1a, false},
+		{0x1301, false},
+		{0x0000, false},
+		{0xaaaa, true},
+		{0x0a0b, false},
+	}
+	for _, tt := range tests {
+		if got := isGREASE(tt.value); got != tt.want {
+			t.Errorf("isGREASE(%#04x) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package proxyserver
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+const http1ALPNProto = "http/1.1"
+
+// ALPN protocols offered to intercepted clients, in order of preference
+var clientALPNProtos = []string{http2.NextProtoTLS, http1ALPNProto}
+
+// serveHTTP2 serves the decrypted h2 client connection, forwarding every stream to the upstream.
+// Streams go over connToUpstream when it negotiated h2 too, otherwise over fresh http/1.1 connections.
+// serverName is the name the upstream is verified against, the CONNECT host when the client sent none.
+func (ps *ProxyServer) serveHTTP2(logger *servLog.ServLogger, requestId uint64, connToClient *tls.Conn, connToUpstream *tls.Conn, upstreamHost, serverName string, meta tunnelMeta) {
+	var transport http.RoundTripper
+	if connToUpstream.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		clientConn, err := (&http2.Transport{}).NewClientConn(connToUpstream)
+		if err != nil {
+			logger.Error(requestId, errors.Wrap(err, "h2 upstream connection error").Error())
+			return
+		}
+		defer clientConn.Close()
+		transport = clientConn
+	} else {
+		// a single http/1.1 connection can't carry concurrent streams
+		h1Transport := &http.Transport{
+			DialTLSContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				clientConfig, check, _ := ps.upstreamTLSConfig(serverName, []string{http1ALPNProto})
+				conn, err := ps.upstream.DialTLS(ctx, upstreamHost, clientConfig)
+				if failure := check.Failure(err); failure != nil {
+					ps.recordTLSFailure(logger, requestId, upstreamHost, check, failure)
+				}
+				if err != nil {
+					return nil, err
+				}
+				return conn, nil
+			},
+		}
+		defer h1Transport.CloseIdleConnections()
+		transport = h1Transport
+	}
+
+	h2Server := &http2.Server{IdleTimeout: ps.tunnelIdleTimeout()}
+	h2Server.ServeConn(connToClient, &http2.ServeConnOpts{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := ps.proxyHTTP2Stream(logger, requestId, transport, w, r, meta); err != nil {
+				logger.Error(requestId, err.Error())
+			}
+		}),
+	})
+}
+
+// proxyHTTP2Stream forwards one h2 stream and records it as its own request/response pair.
+func (ps *ProxyServer) proxyHTTP2Stream(logger *servLog.ServLogger, requestId uint64, transport http.RoundTripper, w http.ResponseWriter, r *http.Request, meta tunnelMeta) error {
+	firedRules, err := ps.rewriteRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return err
+	}
+	r, err = ps.interceptRequest(r.Context(), r, true)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return errors.Wrap(err, "intercept request error")
+	}
+	if r == nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return nil
+	}
+
+	// the raw dump is kept in http/1.1 form, so that the repeater can replay it on any upstream.
+	// The body is streamed upstream as it comes, grpc streams would stall if it were read first.
+	dumpReq := *r
+	dumpReq.Proto, dumpReq.ProtoMajor, dumpReq.ProtoMinor = "HTTP/1.1", 1, 1
+	repoReq, bodyTee, err := ps.captureRequest(&dumpReq)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return err
+	}
+	r.Body = dumpReq.Body
+	repoReq.Protocol = r.Proto
+	repoReq.IsHTTPS = true
+	repoReq.RewriteRules = firedRules
+	repoReq.TLSFailureID = meta.tlsFailureID
+	repoReq.ClientCert = meta.clientCert
+	repoReq.TLSSessionID = meta.tlsSessionID
+	repoReqID, err := ps.insertRequest(repoReq)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return errors.Wrap(err, "h2 inserting request to db error")
+	}
+	// an exchange that failed midway keeps what was captured of the body
+	defer func() {
+		if err := ps.completeRequest(repoReqID, repoReq, &dumpReq, bodyTee); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "h2 storing request body error").Error())
+		}
+	}()
+
+	upstreamReq := r.Clone(r.Context())
+	upstreamReq.RequestURI = ""
+	upstreamReq.URL.Scheme = "https"
+	upstreamReq.URL.Host = r.Host
+	upstreamResp, err := transport.RoundTrip(upstreamReq)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return errors.Wrap(err, "round trip")
+	}
+	defer upstreamResp.Body.Close()
+
+	if err = ps.rewriteResponse(repoReqID, r, upstreamResp); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return err
+	}
+	upstreamResp, err = ps.interceptResponse(r.Context(), r, upstreamResp, true)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return errors.Wrap(err, "intercept response error")
+	}
+	if upstreamResp == nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return nil
+	}
+
+	for key, values := range upstreamResp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(upstreamResp.StatusCode)
+
+	capture := ps.newBodyCapture()
+	if err = copyAndFlush(io.MultiWriter(w, capture), w, upstreamResp.Body); err != nil {
+		return errors.Wrap(err, "copy upstream's response to client")
+	}
+	// trailers (e.g. grpc-status) are only known once the body is read
+	for key, values := range upstreamResp.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+key, value)
+		}
+	}
+
+	upstreamRepoResp := FormResponseData(upstreamResp, capture.Bytes())
+	upstreamRepoResp.BodyTruncated = capture.Truncated()
+	upstreamRepoResp.IsHTTPS = true
+	// the whole exchange goes to the scanner, so the request body is stored first
+	err = ps.completeRequest(repoReqID, repoReq, &dumpReq, bodyTee)
+	bodyTee = nil
+	if err != nil {
+		return errors.Wrap(err, "h2 storing request body error")
+	}
+	if err = ps.insertResponse(repoReqID, repoReq, upstreamRepoResp); err != nil {
+		return errors.Wrap(err, "h2 inserting response to db error")
+	}
+	return nil
+}
+
+// copyAndFlush copies src to dst flushing after every chunk, so that streamed responses are not held back.
+func copyAndFlush(dst io.Writer, flusher http.ResponseWriter, src io.Reader) error {
+	f, _ := flusher.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if f != nil {
+				f.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package proxyserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scope"
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
+	"golang.org/x/net/http2"
+)
+
+// testLogger fails the test on every logged error
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Debugw(string, ...interface{}) {}
+func (l testLogger) Infow(string, ...interface{})  {}
+func (l testLogger) Warnw(string, ...interface{})  {}
+func (l testLogger) Sync() error                   { return nil }
+
+func (l testLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.t.Errorf("logged %s %v", msg, keysAndValues)
+}
+
+func (l testLogger) Fatalw(msg string, keysAndValues ...interface{}) {
+	l.t.Errorf("logged %s %v", msg, keysAndValues)
+}
+
+func (l testLogger) Panicw(msg string, keysAndValues ...interface{}) {
+	l.t.Errorf("logged %s %v", msg, keysAndValues)
+}
+
+// echoHandler answers with what it received, the body's length goes in a trailer
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Trailer", "X-Body-Length")
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "%s %s %s %s", r.Proto, r.Method, r.URL.RequestURI(), body)
+	w.Header().Set("X-Body-Length", fmt.Sprint(len(body)))
+}
+
+func TestServeHTTP2(t *testing.T) {
+	tests := []struct {
+		name string
+		// the upstream negotiates h2 and the streams share the tunnel's connection, http/1.1 streams dial their own
+		upstreamH2    bool
+		upstreamProto string
+	}{
+		{"h2 upstream", true, "HTTP/2.0"},
+		{"http/1.1 upstream", false, "HTTP/1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+			srv.EnableHTTP2 = tt.upstreamH2
+			srv.StartTLS()
+			defer srv.Close()
+			upstreamHost := srv.Listener.Addr().String()
+
+			upstreamProtos := []string{http1ALPNProto}
+			if tt.upstreamH2 {
+				upstreamProtos = clientALPNProtos
+			}
+			connToUpstream, err := tls.Dial("tcp", upstreamHost, &tls.Config{InsecureSkipVerify: true, NextProtos: upstreamProtos})
+			if err != nil {
+				t.Fatalf("dial upstream: %v", err)
+			}
+			defer connToUpstream.Close()
+
+			dialer, err := upstream.NewDialer(&config.UpstreamProxyConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy, err := tlsverify.NewPolicy(&config.UpstreamTLSConfig{Mode: tlsverify.ModeIgnore})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// nothing is in scope and out-of-scope exchanges are skipped, so that nothing goes to the database
+			targetScope, err := scope.NewScope(&config.ScopeConfig{
+				Include:    []config.ScopeRule{{Host: "in-scope.test"}},
+				OutOfScope: scope.OutOfScopeSkip,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ps := &ProxyServer{scope: targetScope, upstream: dialer, tlsPolicy: policy}
+
+			clientSide, proxySide := net.Pipe()
+			connToClient := tls.Server(proxySide, &tls.Config{Certificates: srv.TLS.Certificates, NextProtos: clientALPNProtos})
+			served := make(chan struct{})
+			go func() {
+				defer close(served)
+				if err := connToClient.Handshake(); err != nil {
+					t.Errorf("client handshake: %v", err)
+					return
+				}
+				logger := servLog.NewServLogger(testLogger{t})
+				ps.serveHTTP2(logger, 1, connToClient, connToUpstream, upstreamHost, "127.0.0.1", tunnelMeta{isHTTPS: true})
+			}()
+
+			client := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{http2.NextProtoTLS, http1ALPNProto}})
+			if err = client.Handshake(); err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+			if proto := client.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+				t.Fatalf("negotiated %q with the client, want h2", proto)
+			}
+			clientConn, err := (&http2.Transport{}).NewClientConn(client)
+			if err != nil {
+				t.Fatalf("h2 client connection: %v", err)
+			}
+
+			// concurrent streams over the one client connection
+			const streams = 4
+			var wg sync.WaitGroup
+			for i := 0; i < streams; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					body := strings.Repeat("x", i*1000)
+					req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("https://%s/echo?stream=%d", upstreamHost, i), strings.NewReader(body))
+					resp, err := clientConn.RoundTrip(req)
+					if err != nil {
+						t.Errorf("stream %d: %v", i, err)
+						return
+					}
+					defer resp.Body.Close()
+					got, err := io.ReadAll(resp.Body)
+					if err != nil {
+						t.Errorf("stream %d: read body: %v", i, err)
+						return
+					}
+					want := fmt.Sprintf("%s POST /echo?stream=%d %s", tt.upstreamProto, i, body)
+					if resp.StatusCode != http.StatusOK || string(got) != want {
+						t.Errorf("stream %d: %d %.60q, want 200 %.60q", i, resp.StatusCode, got, want)
+					}
+					if length := resp.Trailer.Get("X-Body-Length"); length != fmt.Sprint(len(body)) {
+						t.Errorf("stream %d: trailer X-Body-Length = %q, want %d", i, length, len(body))
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			clientConn.Close()
+			client.Close()
+			<-served
+		})
+	}
+}
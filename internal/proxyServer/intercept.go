@@ -0,0 +1,117 @@
+package proxyserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/intercept"
+	"github.com/pkg/errors"
+)
+
+// sent to a tunnelled client in place of a dropped exchange
+var droppedHeader = []byte("HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+
+// interceptRequest holds request when intercept is on for it and returns the request to forward,
+// which is nil when the tester dropped it.
+func (ps *ProxyServer) interceptRequest(ctx context.Context, request *http.Request, isHTTPS bool) (*http.Request, error) {
+	if ps.intercept == nil || !ps.inScope(isHTTPS, request.Host, request.URL.Path) ||
+		!ps.intercept.ShouldHold(interceptHost(request), request.URL.Path) {
+		return request, nil
+	}
+
+	// held requests are shown in http/1.1 form, whatever protocol the client speaks
+	dumpReq := *request
+	dumpReq.Proto, dumpReq.ProtoMajor, dumpReq.ProtoMinor = "HTTP/1.1", 1, 1
+	raw, err := httputil.DumpRequest(&dumpReq, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "dump request error")
+	}
+	request.Body = dumpReq.Body
+
+	decision := ps.intercept.Hold(ctx, &intercept.Item{
+		Kind:    intercept.KindRequest,
+		Host:    interceptHost(request),
+		Path:    request.URL.Path,
+		IsHTTPS: isHTTPS,
+		Raw:     raw,
+	})
+	if decision.Action == intercept.ActionDrop {
+		return nil, nil
+	}
+	if len(decision.Raw) == 0 {
+		return request, nil
+	}
+
+	edited, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(decision.Raw)))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse edited request error")
+	}
+	if !isHTTPS && edited.URL.Host == "" {
+		edited.URL.Scheme = "http"
+		edited.URL.Host = edited.Host
+	}
+	return edited.WithContext(request.Context()), nil
+}
+
+// interceptResponse holds response when intercept is on for its request and returns the response
+// to send to the client, which is nil when the tester dropped it. A held response is read in full.
+func (ps *ProxyServer) interceptResponse(ctx context.Context, request *http.Request, response *http.Response, isHTTPS bool) (*http.Response, error) {
+	if ps.intercept == nil || response.StatusCode == http.StatusSwitchingProtocols || !ps.inScope(isHTTPS, request.Host, request.URL.Path) ||
+		!ps.intercept.ShouldHoldResponse(interceptHost(request), request.URL.Path) {
+		return response, nil
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read response body error")
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	raw, err := httputil.DumpResponse(response, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "dump response error")
+	}
+
+	decision := ps.intercept.Hold(ctx, &intercept.Item{
+		Kind:    intercept.KindResponse,
+		Host:    interceptHost(request),
+		Path:    request.URL.Path,
+		IsHTTPS: isHTTPS,
+		Raw:     raw,
+	})
+	if decision.Action == intercept.ActionDrop {
+		return nil, nil
+	}
+	if len(decision.Raw) == 0 {
+		return response, nil
+	}
+
+	edited, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(decision.Raw)), request)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse edited response error")
+	}
+	if body, err = io.ReadAll(edited.Body); err != nil {
+		return nil, errors.Wrap(err, "read edited response body error")
+	}
+	// the edited body is sent with its own length, whatever framing the upstream used
+	edited.Body = io.NopCloser(bytes.NewReader(body))
+	edited.ContentLength = int64(len(body))
+	edited.TransferEncoding = nil
+	edited.Header.Del("Transfer-Encoding")
+	edited.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return edited, nil
+}
+
+// interceptHost returns the host of request without the port, as intercept rules see it
+func interceptHost(request *http.Request) string {
+	host := request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
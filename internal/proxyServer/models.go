@@ -1,35 +1,122 @@
 package proxyserver
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"net/http"
+
+	bodydecoder "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/bodyDecoder"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
 )
 
 type Map map[string]interface{}
 
 type Request struct {
-	Method     string `json:"method"`
-	Path       string `json:"path"`
-	GetParams  Map    `json:"get_params"`
-	Headers    Map    `json:"headers"`
-	Cookies    Map    `json:"cookies"`
-	PostParams Map    `json:"post_params"`
-	Raw        string `json:"raw"`
-	IsHTTPS    bool   `json:"is_https"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	GetParams   Map    `json:"get_params"`
+	Headers     Map    `json:"headers"`
+	Cookies     Map    `json:"cookies"`
+	PostParams  Map    `json:"post_params"`
+	Body        []byte `json:"body"`
+	DecodedBody []byte `json:"decoded_body"`
+	Charset     string `json:"charset"`
+	Raw         []byte `json:"raw"`
+	IsHTTPS     bool   `json:"is_https"`
+	Protocol    string `json:"protocol"`
+	// request this one was derived from in the repeater, nil for captured traffic
+	ParentID *int64 `json:"parent_id"`
+	// names of the match-and-replace rules that rewrote the exchange
+	RewriteRules []string `json:"rewrite_rules"`
+	OutOfScope   bool     `json:"out_of_scope"`
+	// failure of the upstream tls connection the request went over, see TLSFailure
+	TLSFailureID *int64 `json:"tls_failure_id"`
+	// identity of the client certificate presented to the upstream
+	ClientCert string `json:"client_cert"`
+	// handshakes of the tls connection the request went over, see TLSSession
+	TLSSessionID *int64 `json:"tls_session_id"`
+	// the body is longer than the stored one
+	BodyTruncated bool `json:"body_truncated"`
+}
+
+// kinds of tunnels relayed without decryption
+const (
+	// a tls tunnel to a passthrough host
+	TunnelPassthrough = "passthrough"
+	// a tls tunnel to a host out of the scope
+	TunnelOutOfScope = "out_of_scope"
+	// a stream that is neither tls nor http
+	TunnelRaw = "raw"
+)
+
+// Tunnel is a tunnel relayed without decryption.
+type Tunnel struct {
+	Kind string `json:"kind"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// server name and protocols offered in the client's ClientHello
+	SNI        string   `json:"sni"`
+	ALPN       []string `json:"alpn"`
+	BytesUp    int64    `json:"bytes_up"`
+	BytesDown  int64    `json:"bytes_down"`
+	DurationMs int64    `json:"duration_ms"`
+}
+
+// TLSFailure is an upstream tls connection whose handshake failed or whose certificate didn't verify.
+type TLSFailure struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	ServerName string `json:"server_name"`
+	// verification mode the connection was made in
+	Mode  string               `json:"mode"`
+	Error string               `json:"error"`
+	Chain []tlsverify.CertInfo `json:"chain"`
+}
+
+// TLSSession is the handshake of an intercepted tls connection with the client and the one with the upstream.
+type TLSSession struct {
+	SNI string `json:"sni"`
+	// highest version the client offers
+	ClientVersion string `json:"client_version"`
+	JA3           string `json:"ja3"`
+	JA3Hash       string `json:"ja3_hash"`
+	JA4           string `json:"ja4"`
+	// cipher suites and ALPN protocols offered by the client, in its order
+	CipherSuites []string `json:"cipher_suites"`
+	ALPN         []string `json:"alpn"`
+	// negotiated with the upstream
+	UpstreamVersion string               `json:"upstream_version"`
+	UpstreamCipher  string               `json:"upstream_cipher"`
+	UpstreamALPN    string               `json:"upstream_alpn"`
+	UpstreamChain   []tlsverify.CertInfo `json:"upstream_chain"`
 }
+
 type Response struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Headers Map    `json:"headers"`
-	Body    string `json:"body"`
-	Raw     string `json:"raw"`
-	IsHTTPS bool   `json:"is_https"`
+	Code          int    `json:"code"`
+	Message       string `json:"message"`
+	Headers       Map    `json:"headers"`
+	Body          []byte `json:"body"`
+	BodyTruncated bool   `json:"body_truncated"`
+	DecodedBody   []byte `json:"decoded_body"`
+	Charset       string `json:"charset"`
+	Raw           []byte `json:"raw"`
+	IsHTTPS       bool   `json:"is_https"`
+	Protocol      string `json:"protocol"`
+}
+type WebsocketMessage struct {
+	FromClient bool   `json:"from_client"`
+	Opcode     int    `json:"opcode"`
+	Payload    []byte `json:"payload"`
 }
 
-func FormRequestData(r *http.Request, dump []byte) *Request {
+// FormRequestData describes r for storing, body is its captured body and dump its raw form
+func FormRequestData(r *http.Request, dump, body []byte) *Request {
 	req := &Request{
-		Method: r.Method,
-		Path:   r.URL.Path,
-		Raw:    string(dump),
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Raw:      dump,
+		Protocol: r.Proto,
 	}
 	getParams := Map{}
 	for key, value := range r.URL.Query() {
@@ -56,21 +143,29 @@ func FormRequestData(r *http.Request, dump []byte) *Request {
 	}
 	req.Cookies = cookies
 
+	req.Body = body
+	req.DecodedBody, req.Charset = decodeBody(body, r.Header)
+
+	// the form is parsed from the captured body, r.Body is forwarded upstream
 	postParams := Map{}
-	_ = r.ParseForm()
-	for key, value := range r.PostForm {
+	formReq := *r
+	formReq.Body = io.NopCloser(bytes.NewReader(body))
+	formReq.Form, formReq.PostForm = nil, nil
+	_ = formReq.ParseForm()
+	for key, value := range formReq.PostForm {
 		postParams[key] = getValue(value)
 	}
 	req.PostParams = postParams
 	return req
 }
-func FormResponseData(response *http.Response, body string) *Response {
+func FormResponseData(response *http.Response, body []byte) *Response {
 	if response == nil {
 		return nil
 	}
 	res := &Response{
-		Code:    response.StatusCode,
-		Message: response.Status,
+		Code:     response.StatusCode,
+		Message:  response.Status,
+		Protocol: response.Proto,
 	}
 
 	headers := Map{}
@@ -83,11 +178,33 @@ func FormResponseData(response *http.Response, body string) *Response {
 	}
 	res.Headers = headers
 	res.Body = body
+	res.DecodedBody, res.Charset = decodeBody(body, response.Header)
+	res.Raw = dumpResponse(response, body)
 
 	return res
 
 }
 
+// dumpResponse renders the response as it was received, with the captured (possibly truncated) body
+// already de-chunked
+func dumpResponse(response *http.Response, body []byte) []byte {
+	raw := &bytes.Buffer{}
+	fmt.Fprintf(raw, "HTTP/%d.%d %s\r\n", response.ProtoMajor, response.ProtoMinor, response.Status)
+	_ = response.Header.Write(raw)
+	raw.WriteString("\r\n")
+	raw.Write(body)
+	return raw.Bytes()
+}
+
+// decodeBody falls back to the body as is when it can't be decoded
+func decodeBody(body []byte, header http.Header) ([]byte, string) {
+	decoded, charset, err := bodydecoder.Decode(body, header)
+	if err != nil {
+		return body, ""
+	}
+	return decoded, charset
+}
+
 func getValue(value []string) interface{} {
 	if len(value) == 1 {
 		return value[0]
@@ -0,0 +1,65 @@
+package proxyserver
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST from linux/netfilter_ipv4.h and linux/netfilter_ipv6/ip6_tables.h
+const soOriginalDst = 80
+
+// originalDst returns the address a connection redirected by iptables was originally made to.
+func originalDst(conn net.Conn) (string, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("not a tcp connection")
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", errors.Wrap(err, "syscall conn error")
+	}
+	localAddr, _ := tcpConn.LocalAddr().(*net.TCPAddr)
+	isIPv6 := localAddr != nil && localAddr.IP.To4() == nil
+
+	var ip net.IP
+	var port int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if isIPv6 {
+			// the struct sockaddr_in6 is the start of ip6_mtuinfo
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.IPPROTO_IPV6, soOriginalDst)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			ip = append(net.IP{}, info.Addr.Addr[:]...)
+			port = networkPort(&info.Addr.Port)
+			return
+		}
+		// the struct sockaddr_in fits into the 16 bytes of ipv6_mreq
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		ip = net.IPv4(mreq.Multiaddr[4], mreq.Multiaddr[5], mreq.Multiaddr[6], mreq.Multiaddr[7])
+		port = int(mreq.Multiaddr[2])<<8 | int(mreq.Multiaddr[3])
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "syscall conn control error")
+	}
+	if sockErr != nil {
+		return "", errors.Wrap(sockErr, "getsockopt SO_ORIGINAL_DST error")
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+}
+
+// networkPort reads a port stored in network byte order
+func networkPort(port *uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(port))
+	return int(b[0])<<8 | int(b[1])
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package proxyserver
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// originalDst needs netfilter's SO_ORIGINAL_DST, so transparent mode only works on linux.
+func originalDst(conn net.Conn) (string, error) {
+	return "", errors.New("transparent mode is only supported on linux")
+}
@@ -0,0 +1,119 @@
+package proxyserver
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/pkg/errors"
+)
+
+// how long to wait for the ClientHello of a passthrough tunnel
+const clientHelloTimeout = 10 * time.Second
+
+var errHelloCaptured = errors.New("client hello captured")
+
+// PassthroughList holds the host globs whose CONNECT tunnels are relayed without decryption.
+type PassthroughList struct {
+	mu    sync.RWMutex
+	hosts []string
+}
+
+func NewPassthroughList(conf *config.PassthroughConfig) (*PassthroughList, error) {
+	list := &PassthroughList{}
+	if err := list.SetHosts(conf.Hosts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *PassthroughList) Hosts() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]string{}, l.hosts...)
+}
+
+func (l *PassthroughList) SetHosts(hosts []string) error {
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if _, err := path.Match(host, ""); err != nil {
+			return errors.Wrap(err, "bad host glob "+host)
+		}
+		normalized = append(normalized, host)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hosts = normalized
+	return nil
+}
+
+func (l *PassthroughList) Match(host string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	host = strings.ToLower(host)
+	for _, glob := range l.hosts {
+		if matched, _ := path.Match(glob, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingConn lets crypto/tls read a ClientHello from the client while keeping the bytes for the upstream.
+// Nothing is ever written back to the client.
+type recordingConn struct {
+	net.Conn
+	recorded []byte
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.recorded = append(c.recorded, b[:n]...)
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return 0, errHelloCaptured
+}
+
+// readClientHello reads the client's ClientHello and returns it parsed along with the raw bytes read,
+// which have to be sent to the upstream first. The hello is nil when the client doesn't speak TLS.
+func readClientHello(connToClient net.Conn) (*tls.ClientHelloInfo, []byte, error) {
+	if err := connToClient.SetReadDeadline(time.Now().Add(clientHelloTimeout)); err != nil {
+		return nil, nil, errors.Wrap(err, "set hello deadline error")
+	}
+	defer connToClient.SetReadDeadline(time.Time{})
+
+	recorder := &recordingConn{Conn: connToClient}
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(recorder, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errHelloCaptured
+		},
+	}).Handshake()
+	if hello == nil && isTunnelClosed(err) {
+		return nil, recorder.recorded, errors.Wrap(err, "read client hello error")
+	}
+	return hello, recorder.recorded, nil
+}
+
+// relayCounted copies the tunnel both ways until either side closes it
+// and returns the bytes sent upstream and downstream.
+func relayCounted(connToClient, connToUpstream net.Conn) (int64, int64) {
+	upstreamBytes := make(chan int64)
+	go func() {
+		n, _ := io.Copy(connToUpstream, connToClient)
+		connToUpstream.Close()
+		upstreamBytes <- n
+	}()
+	down, _ := io.Copy(connToClient, connToUpstream)
+	connToClient.Close()
+	return <-upstreamBytes, down
+}
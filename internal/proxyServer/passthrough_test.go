@@ -0,0 +1,76 @@
+package proxyserver
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestPassthroughListMatch(t *testing.T) {
+	list := &PassthroughList{}
+	if err := list.SetHosts([]string{"*.Bank.example", "pinned.test", "api-?.corp.test", "[a-c].cdn.test"}); err != nil {
+		t.Fatalf("SetHosts: %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"www.bank.example", true},
+		{"WWW.BANK.EXAMPLE", true},
+		{"a.b.bank.example", true},
+		{"bank.example", false},
+		{"bank.example.evil.test", false},
+		{"pinned.test", true},
+		{"sub.pinned.test", false},
+		{"api-1.corp.test", true},
+		{"api-12.corp.test", false},
+		{"b.cdn.test", true},
+		{"d.cdn.test", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := list.Match(tt.host); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestPassthroughListSetHosts(t *testing.T) {
+	list := &PassthroughList{}
+	if err := list.SetHosts([]string{"kept.test"}); err != nil {
+		t.Fatalf("SetHosts: %v", err)
+	}
+	if err := list.SetHosts([]string{"ok.test", "[a-"}); err == nil {
+		t.Fatal("SetHosts accepted a bad glob")
+	}
+	if hosts := list.Hosts(); len(hosts) != 1 || hosts[0] != "kept.test" {
+		t.Errorf("hosts after a bad update = %q, want the previous list", hosts)
+	}
+	if list.Match("ok.test") {
+		t.Error("a rejected update was partly applied")
+	}
+}
+
+func TestReadClientHello(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+	go tls.Client(clientSide, &tls.Config{ServerName: "pinned.test", NextProtos: []string{"h2"}}).Handshake()
+
+	hello, recorded, err := readClientHello(proxySide)
+	if err != nil {
+		t.Fatalf("readClientHello: %v", err)
+	}
+	if hello == nil || hello.ServerName != "pinned.test" {
+		t.Fatalf("hello = %+v, want the one for pinned.test", hello)
+	}
+	// the recorded bytes are replayed to the upstream, so they must hold the whole hello
+	parsed, err := parseClientHello(recorded)
+	if err != nil {
+		t.Fatalf("parseClientHello of the recorded bytes: %v", err)
+	}
+	if parsed.serverName != "pinned.test" || len(parsed.alpn) != 1 || parsed.alpn[0] != "h2" {
+		t.Errorf("recorded hello has server name %q and alpn %q", parsed.serverName, parsed.alpn)
+	}
+}
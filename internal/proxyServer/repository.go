@@ -10,8 +10,15 @@ type ProxyRepository struct {
 }
 
 const (
-	insertRequestQuery  = `INSERT INTO requests(method, path, get_params, headers, cookies, post_params, raw, is_https) VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;`
-	insertResponseQuery = `INSERT INTO responses(request_id, code, message, headers, body) VALUES($1, $2, $3, $4, $5);`
+	insertRequestQuery  = `INSERT INTO requests(method, path, get_params, headers, cookies, post_params, body, body_truncated, decoded_body, charset, raw, is_https, protocol, parent_id, rewrite_rules, out_of_scope, tls_failure_id, client_cert, tls_session_id) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19) RETURNING id;`
+	insertResponseQuery = `INSERT INTO responses(request_id, code, message, headers, body, body_truncated, decoded_body, charset, raw, is_https, protocol) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`
+
+	updateRequestBodyQuery      = `UPDATE requests SET body = $2, body_truncated = $3, decoded_body = $4, charset = $5, raw = $6, post_params = $7 WHERE id = $1;`
+	appendRewriteRulesQuery     = `UPDATE requests SET rewrite_rules = coalesce(rewrite_rules, '{}') || $2::text[] WHERE id = $1;`
+	insertTunnelQuery           = `INSERT INTO tunnels(kind, host, port, sni, alpn, bytes_up, bytes_down, duration_ms) VALUES($1, $2, $3, $4, $5, $6, $7, $8);`
+	insertWebsocketMessageQuery = `INSERT INTO websocket_messages(request_id, from_client, opcode, payload) VALUES($1, $2, $3, $4);`
+	insertTLSFailureQuery       = `INSERT INTO tls_failures(host, port, server_name, mode, error, chain) VALUES($1, $2, $3, $4, $5, $6) RETURNING id;`
+	insertTLSSessionQuery       = `INSERT INTO tls_sessions(sni, client_version, ja3, ja3_hash, ja4, cipher_suites, alpn, upstream_version, upstream_cipher, upstream_alpn, upstream_chain) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;`
 )
 
 func NewProxyRepository(conn *pgx.ConnPool) *ProxyRepository {
@@ -21,7 +28,7 @@ func NewProxyRepository(conn *pgx.ConnPool) *ProxyRepository {
 }
 func (p *ProxyRepository) InsertRequest(req *Request) (uint, error) {
 	var id uint
-	err := p.conn.QueryRow(insertRequestQuery, req.Method, req.Path, req.GetParams, req.Headers, req.Cookies, req.PostParams, req.Raw, req.IsHTTPS).Scan(&id)
+	err := p.conn.QueryRow(insertRequestQuery, req.Method, req.Path, req.GetParams, req.Headers, req.Cookies, req.PostParams, req.Body, req.BodyTruncated, req.DecodedBody, req.Charset, req.Raw, req.IsHTTPS, req.Protocol, req.ParentID, req.RewriteRules, req.OutOfScope, req.TLSFailureID, req.ClientCert, req.TLSSessionID).Scan(&id)
 	if err != nil {
 		return id, errors.Wrap(err, "inserting request error")
 	}
@@ -30,7 +37,7 @@ func (p *ProxyRepository) InsertRequest(req *Request) (uint, error) {
 }
 
 func (p *ProxyRepository) InsertResponse(reqID uint, resp *Response) error {
-	res, err := p.conn.Exec(insertResponseQuery, reqID, resp.Code, resp.Message, resp.Headers, resp.Body)
+	res, err := p.conn.Exec(insertResponseQuery, reqID, resp.Code, resp.Message, resp.Headers, resp.Body, resp.BodyTruncated, resp.DecodedBody, resp.Charset, resp.Raw, resp.IsHTTPS, resp.Protocol)
 	if err != nil {
 		return err
 	}
@@ -39,3 +46,59 @@ func (p *ProxyRepository) InsertResponse(reqID uint, resp *Response) error {
 	}
 	return nil
 }
+
+// UpdateRequestBody stores the body of a request that was stored before its body was forwarded
+func (p *ProxyRepository) UpdateRequestBody(reqID uint, req *Request) error {
+	_, err := p.conn.Exec(updateRequestBodyQuery, reqID, req.Body, req.BodyTruncated, req.DecodedBody, req.Charset, req.Raw, req.PostParams)
+	if err != nil {
+		return errors.Wrap(err, "updating request body error")
+	}
+	return nil
+}
+
+// AppendRewriteRules records rules that fired on the response of an already stored request
+func (p *ProxyRepository) AppendRewriteRules(reqID uint, names []string) error {
+	_, err := p.conn.Exec(appendRewriteRulesQuery, reqID, names)
+	if err != nil {
+		return errors.Wrap(err, "appending rewrite rules error")
+	}
+	return nil
+}
+
+func (p *ProxyRepository) InsertTunnel(tunnel *Tunnel) error {
+	_, err := p.conn.Exec(insertTunnelQuery, tunnel.Kind, tunnel.Host, tunnel.Port, tunnel.SNI, tunnel.ALPN, tunnel.BytesUp, tunnel.BytesDown, tunnel.DurationMs)
+	if err != nil {
+		return errors.Wrap(err, "inserting tunnel error")
+	}
+	return nil
+}
+
+func (p *ProxyRepository) InsertWebsocketMessage(reqID uint, msg *WebsocketMessage) error {
+	res, err := p.conn.Exec(insertWebsocketMessageQuery, reqID, msg.FromClient, msg.Opcode, msg.Payload)
+	if err != nil {
+		return errors.Wrap(err, "inserting websocket message error")
+	}
+	if res.RowsAffected() != 1 {
+		return errors.New("inserting websocket message error")
+	}
+	return nil
+}
+
+func (p *ProxyRepository) InsertTLSFailure(failure *TLSFailure) (int64, error) {
+	var id int64
+	err := p.conn.QueryRow(insertTLSFailureQuery, failure.Host, failure.Port, failure.ServerName, failure.Mode, failure.Error, failure.Chain).Scan(&id)
+	if err != nil {
+		return id, errors.Wrap(err, "inserting tls failure error")
+	}
+	return id, nil
+}
+
+func (p *ProxyRepository) InsertTLSSession(session *TLSSession) (int64, error) {
+	var id int64
+	err := p.conn.QueryRow(insertTLSSessionQuery, session.SNI, session.ClientVersion, session.JA3, session.JA3Hash, session.JA4, session.CipherSuites, session.ALPN,
+		session.UpstreamVersion, session.UpstreamCipher, session.UpstreamALPN, session.UpstreamChain).Scan(&id)
+	if err != nil {
+		return id, errors.Wrap(err, "inserting tls session error")
+	}
+	return id, nil
+}
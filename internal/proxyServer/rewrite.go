@@ -0,0 +1,35 @@
+package proxyserver
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// rewriteRequest applies the match-and-replace rules to request and returns the names of the rules that fired.
+func (ps *ProxyServer) rewriteRequest(request *http.Request) ([]string, error) {
+	if ps.rewrite == nil {
+		return nil, nil
+	}
+	fired, err := ps.rewrite.RewriteRequest(request, ps.bodyCaptureLimit())
+	if err != nil {
+		return nil, errors.Wrap(err, "rewrite request error")
+	}
+	return fired, nil
+}
+
+// rewriteResponse applies the match-and-replace rules to response and adds the rules that fired
+// to the ones recorded for the request stored under reqID.
+func (ps *ProxyServer) rewriteResponse(reqID uint, request *http.Request, response *http.Response) error {
+	if ps.rewrite == nil {
+		return nil
+	}
+	fired, err := ps.rewrite.RewriteResponse(request, response, ps.bodyCaptureLimit())
+	if err != nil {
+		return errors.Wrap(err, "rewrite response error")
+	}
+	if len(fired) == 0 || reqID == unrecordedID {
+		return nil
+	}
+	return ps.repo.AppendRewriteRules(reqID, fired)
+}
@@ -0,0 +1,58 @@
+package proxyserver
+
+import (
+	"net/http"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scanner"
+)
+
+// insertResponse stores the response and hands the whole exchange to the passive scanner.
+// Nothing is stored for unrecorded exchanges and out-of-scope ones aren't scanned.
+func (ps *ProxyServer) insertResponse(reqID uint, req *Request, resp *Response) error {
+	if reqID == unrecordedID {
+		return nil
+	}
+	if err := ps.repo.InsertResponse(reqID, resp); err != nil {
+		return err
+	}
+	if ps.scanner != nil && !req.OutOfScope {
+		ps.scanner.Submit(scanExchange(reqID, req, resp))
+	}
+	return nil
+}
+
+func scanExchange(reqID uint, req *Request, resp *Response) *scanner.Exchange {
+	host, _ := req.Headers["Host"].(string)
+	params := map[string][]string{}
+	for _, m := range []Map{req.GetParams, req.PostParams} {
+		for key, value := range m {
+			params[key] = append(params[key], mapValues(value)...)
+		}
+	}
+	headers := http.Header{}
+	for key, value := range resp.Headers {
+		headers[key] = mapValues(value)
+	}
+
+	return &scanner.Exchange{
+		RequestID:       int64(reqID),
+		IsHTTPS:         req.IsHTTPS,
+		Host:            host,
+		Path:            req.Path,
+		Params:          params,
+		ResponseCode:    resp.Code,
+		ResponseHeaders: headers,
+		ResponseBody:    resp.DecodedBody,
+	}
+}
+
+// mapValues reverts getValue
+func mapValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	}
+	return nil
+}
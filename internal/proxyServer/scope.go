@@ -0,0 +1,132 @@
+package proxyserver
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// request id of out-of-scope exchanges that are not stored, nothing else is stored for them either
+const unrecordedID uint = 0
+
+// inScope reports whether a request to hostport and urlPath is in the target scope.
+func (ps *ProxyServer) inScope(isHTTPS bool, hostport, urlPath string) bool {
+	if ps.scope == nil {
+		return true
+	}
+	scheme := "http"
+	if isHTTPS {
+		scheme = "https"
+	}
+	host, port := splitHostPort(hostport, isHTTPS)
+	return ps.scope.InScope(scheme, host, port, urlPath)
+}
+
+// insertRequest stores req, flagging it when it is out of scope, and returns its id.
+// Out-of-scope requests aren't stored at all unless the scope says to flag them, unrecordedID is returned then.
+func (ps *ProxyServer) insertRequest(req *Request) (uint, error) {
+	host, _ := req.Headers["Host"].(string)
+	if !ps.inScope(req.IsHTTPS, host, req.Path) {
+		if !ps.scope.RecordOutOfScope() {
+			return unrecordedID, nil
+		}
+		req.OutOfScope = true
+	}
+	return ps.repo.InsertRequest(req)
+}
+
+// insertHandshakeResponse stores the response to a websocket handshake, which isn't scanned
+func (ps *ProxyServer) insertHandshakeResponse(reqID uint, resp *Response) error {
+	if reqID == unrecordedID {
+		return nil
+	}
+	return ps.repo.InsertResponse(reqID, resp)
+}
+
+// proxyBlindTunnel relays a CONNECT tunnel of the given kind without decrypting it and records its metadata.
+func (ps *ProxyServer) proxyBlindTunnel(ctx echo.Context, kind string) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	connToUpstream, err := ps.upstream.Dial(ctx.Request().Context(), "tcp", ctx.Request().Host)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	defer connToUpstream.Close()
+
+	connToClient, _, err := ctx.Response().Hijack()
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "hijacking error:").Error())
+		return nil
+	}
+	defer connToClient.Close()
+	if err = connToClient.SetDeadline(time.Time{}); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "reset deadline error").Error())
+		return nil
+	}
+	if _, err = connToClient.Write(okHeader); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "writing ok-header error").Error())
+		return nil
+	}
+	ps.relayTunnel(logger, requestId, connToClient, connToUpstream, ctx.Request().Host, kind)
+	return nil
+}
+
+// relayTunnel relays a tunnel that isn't decrypted and records its metadata.
+// The ClientHello of tls tunnels is read for the server name and protocols the client offered.
+func (ps *ProxyServer) relayTunnel(logger *servLog.ServLogger, requestId uint64, connToClient, connToUpstream net.Conn, hostport string, kind string) {
+	start := time.Now()
+	isTLS := kind != TunnelRaw
+	host, port := splitHostPort(hostport, isTLS)
+	tunnel := &Tunnel{Kind: kind, Host: host, Port: port, ALPN: []string{}}
+	var helloBytes []byte
+	if isTLS {
+		var hello *tls.ClientHelloInfo
+		var err error
+		hello, helloBytes, err = readClientHello(connToClient)
+		if err != nil {
+			logger.Error(requestId, err.Error())
+			return
+		}
+		if hello != nil {
+			tunnel.SNI = hello.ServerName
+			tunnel.ALPN = append(tunnel.ALPN, hello.SupportedProtos...)
+		}
+		if _, err = connToUpstream.Write(helloBytes); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "write client hello error").Error())
+			return
+		}
+	}
+
+	tunnel.BytesUp, tunnel.BytesDown = relayCounted(connToClient, connToUpstream)
+	tunnel.BytesUp += int64(len(helloBytes))
+	tunnel.DurationMs = time.Since(start).Milliseconds()
+	if err := ps.repo.InsertTunnel(tunnel); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "inserting tunnel to db error").Error())
+	}
+}
+
+// splitHostPort splits hostport, filling in the default port of the scheme
+func splitHostPort(hostport string, isHTTPS bool) (string, int) {
+	port := 80
+	if isHTTPS {
+		port = 443
+	}
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport, port
+	}
+	if p, err := strconv.Atoi(portStr); err == nil {
+		port = p
+	}
+	return host, port
+}
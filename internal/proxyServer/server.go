@@ -2,7 +2,9 @@ package proxyserver
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
 	"net/http"
@@ -10,38 +12,105 @@ import (
 	"time"
 
 	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/intercept"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/rewrite"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scanner"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scope"
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
 	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/cert"
+	clientcert "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/clientCert"
 	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
 	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/websocket"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
 )
 
 var okHeader = []byte("HTTP/1.1 200 OK\r\n\r\n")
 
+const defaultTunnelIdleTimeout = 60 * time.Second
+
 type ProxyServer struct {
-	repo ProxyRepository
-	CA   *tls.Certificate
+	repo      ProxyRepository
+	scanner   *scanner.PassiveScanner
+	intercept *intercept.Queue
+	rewrite   *rewrite.Engine
+	scope     *scope.Scope
+	// hosts whose tunnels are never decrypted
+	passthrough *PassthroughList
+	// leaves signed by the CA for the intercepted server names
+	leafCerts *cert.Cache
+	CA        *cert.Authority
 	// proxy server's tls-config for connecting to client as server
 	ProxyAsServerTLSConfig *tls.Config
 
 	// proxy server's tls-config for connecting to upstream-server as client
 	ProxyAsClientTLSConfig *tls.Config
+
+	// how long a decrypted tunnel may stay idle between requests
+	idleTimeout time.Duration
+	// how many bytes of each request and response body are stored
+	maxBodyCapture int
+	// the http server's write timeout, restarted once a held exchange is released
+	writeTimeout time.Duration
+	// connects to upstream servers, through the upstream proxy when one is configured
+	upstream  *upstream.Dialer
+	transport http.RoundTripper
+	// how the certificates of upstream servers are verified
+	tlsPolicy *tlsverify.Policy
+	// client certificates presented to the upstreams requiring mutual tls
+	clientCerts *clientcert.Store
 }
 
-func NewProxyServer(repo *ProxyRepository, caCert *tls.Certificate, servConf, clientConf *tls.Config) *ProxyServer {
+// tunnelMeta is what the requests sent over one tunnel have in common
+type tunnelMeta struct {
+	isHTTPS bool
+	// failure of the upstream tls connection the proxy went on with, nil if there was none
+	tlsFailureID *int64
+	// identity of the client certificate presented to the upstream, empty if none was
+	clientCert string
+	// handshakes of the intercepted tls connection, nil for plain http
+	tlsSessionID *int64
+}
+
+func NewProxyServer(repo *ProxyRepository, passiveScanner *scanner.PassiveScanner, interceptQueue *intercept.Queue, rewriteEngine *rewrite.Engine, targetScope *scope.Scope, passthrough *PassthroughList, leafCerts *cert.Cache, ca *cert.Authority, servConf, clientConf *tls.Config) *ProxyServer {
 	return &ProxyServer{
 		repo:                   *repo,
-		CA:                     caCert,
+		scanner:                passiveScanner,
+		intercept:              interceptQueue,
+		rewrite:                rewriteEngine,
+		scope:                  targetScope,
+		passthrough:            passthrough,
+		leafCerts:              leafCerts,
+		CA:                     ca,
 		ProxyAsServerTLSConfig: servConf,
 		ProxyAsClientTLSConfig: clientConf,
 	}
 }
 
-func (ps *ProxyServer) ListenAndServe(proxyConf *config.ServerConfig, mw *middleware.CommonMiddleware) {
+// ListenAndServe serves the http proxy and, next to it, the SOCKS5 and transparent listeners that have a port.
+func (ps *ProxyServer) ListenAndServe(proxyConf, socksConf, transparentConf *config.ServerConfig, mw *middleware.CommonMiddleware) {
 	e := echo.New()
 	e.Use(echomw.Recover(), mw.RequestIdMiddleware, mw.AccessLogMiddleware, mw.PanicMiddleware, ps.proxyDefineProtocol)
+	ps.idleTimeout = time.Duration(proxyConf.IdleTimeout) * time.Second
+	ps.maxBodyCapture = proxyConf.MaxBodyCapture
+	ps.writeTimeout = time.Duration(proxyConf.WriteTimeout) * time.Second
+	dialer, err := upstream.NewDialer(&proxyConf.UpstreamProxy)
+	if err != nil {
+		e.Logger.Fatal(errors.Wrap(err, "upstream proxy config error"))
+	}
+	ps.upstream = dialer
+	ps.transport = dialer.Transport()
+	if ps.tlsPolicy, err = tlsverify.NewPolicy(&proxyConf.UpstreamTLS); err != nil {
+		e.Logger.Fatal(errors.Wrap(err, "upstream tls config error"))
+	}
+	if ps.clientCerts, err = clientcert.NewStore(proxyConf.UpstreamTLS.ClientCerts); err != nil {
+		e.Logger.Fatal(errors.Wrap(err, "upstream client certificates config error"))
+	}
 
 	httpServ := http.Server{
 		Addr:         proxyConf.Addr(),
@@ -50,6 +119,16 @@ func (ps *ProxyServer) ListenAndServe(proxyConf *config.ServerConfig, mw *middle
 		Handler:      e,
 	}
 
+	if socksConf != nil && socksConf.Port != "" {
+		go func() {
+			e.Logger.Fatal(ps.listenAndServeSOCKS(socksConf, mw.Logger))
+		}()
+	}
+	if transparentConf != nil && transparentConf.Port != "" {
+		go func() {
+			e.Logger.Fatal(ps.listenAndServeTransparent(transparentConf, mw.Logger))
+		}()
+	}
 	e.Logger.Fatal(e.StartServer(&httpServ))
 }
 
@@ -58,6 +137,9 @@ func (ps *ProxyServer) proxyDefineProtocol(_ echo.HandlerFunc) echo.HandlerFunc
 		if ctx.Request().Method == http.MethodConnect {
 			return ps.proxyHTTPSHandler(ctx)
 		}
+		if isCAHost(ctx.Request().Host) {
+			return ps.serveCA(ctx)
+		}
 		return ps.proxyHTTPHandler(ctx)
 	}
 }
@@ -66,28 +148,70 @@ func (ps *ProxyServer) proxyHTTPHandler(ctx echo.Context) error {
 	logger := middleware.GetLoggerFromCtx(ctx)
 	requestId := middleware.GetRequestIdFromCtx(ctx)
 	ctx.Request().Header.Del("Proxy-Connection")
+	firedRules, err := ps.rewriteRequest(ctx.Request())
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, httperrors.INTERNAL_SERVER_ERR)
+	}
+	request, err := ps.interceptRequest(ctx.Request().Context(), ctx.Request(), false)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "intercept request error").Error())
+		return echo.NewHTTPError(http.StatusBadGateway, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if request == nil {
+		return echo.NewHTTPError(http.StatusBadGateway, httperrors.DROPPED_BY_INTERCEPT)
+	}
+	ctx.SetRequest(request)
+	ps.restartWriteTimeout(ctx)
 
-	reqDump, err := httputil.DumpRequest(ctx.Request(), true)
+	isWebsocket := websocket.IsUpgradeRequest(ctx.Request())
+	if isWebsocket {
+		websocket.DisableExtensions(ctx.Request())
+	}
+
+	repoReq, bodyTee, err := ps.captureRequest(ctx.Request())
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "request dump error").Error())
 		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
 	}
-
-	repoReq := FormRequestData(ctx.Request(), reqDump)
 	repoReq.IsHTTPS = false
-	repoReqID, err := ps.repo.InsertRequest(repoReq)
+	repoReq.RewriteRules = firedRules
+	repoReqID, err := ps.insertRequest(repoReq)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "http inserting request to db error").Error())
 		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
 	}
+	defer func() {
+		if err := ps.completeRequest(repoReqID, repoReq, ctx.Request(), bodyTee); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "http storing request body error").Error())
+		}
+	}()
+
+	if isWebsocket {
+		return ps.proxyWebsocketHandler(ctx, repoReqID)
+	}
 
-	upstreamResp, err := http.DefaultTransport.RoundTrip(ctx.Request())
+	upstreamResp, err := ps.forwardTransport().RoundTrip(ctx.Request())
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "round trip").Error())
 		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
 	}
 	defer upstreamResp.Body.Close()
 
+	if err = ps.rewriteResponse(repoReqID, ctx.Request(), upstreamResp); err != nil {
+		logger.Error(requestId, err.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, httperrors.INTERNAL_SERVER_ERR)
+	}
+	upstreamResp, err = ps.interceptResponse(ctx.Request().Context(), ctx.Request(), upstreamResp, false)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "intercept response error").Error())
+		return echo.NewHTTPError(http.StatusBadGateway, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if upstreamResp == nil {
+		return echo.NewHTTPError(http.StatusBadGateway, httperrors.DROPPED_BY_INTERCEPT)
+	}
+	ps.restartWriteTimeout(ctx)
+
 	for key, values := range upstreamResp.Header {
 		for _, value := range values {
 			ctx.Response().Header().Add(key, value)
@@ -95,22 +219,26 @@ func (ps *ProxyServer) proxyHTTPHandler(ctx echo.Context) error {
 	}
 
 	ctx.Response().Status = upstreamResp.StatusCode
-	if _, err = io.Copy(ctx.Response(), upstreamResp.Body); err != nil {
+	capture := ps.newBodyCapture()
+	if _, err = io.Copy(io.MultiWriter(ctx.Response(), capture), upstreamResp.Body); err != nil {
 		logger.Error(requestId, errors.Wrap(err, "copy upstream's response to client").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
-	var upsreamBody string
-	if b, err := io.ReadAll(upstreamResp.Body); err == nil {
-		upsreamBody = string(b)
-	}
 
-	upstreamRepoResp := FormResponseData(upstreamResp, upsreamBody)
-	if upstreamResp == nil {
-		logger.Error(requestId, errors.Wrap(err, "form response error").Error())
+	upstreamRepoResp := FormResponseData(upstreamResp, capture.Bytes())
+	if upstreamRepoResp == nil {
+		logger.Error(requestId, "form response error")
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
+	upstreamRepoResp.BodyTruncated = capture.Truncated()
 
-	err = ps.repo.InsertResponse(repoReqID, upstreamRepoResp)
+	// the whole exchange goes to the scanner, so the request body is stored first
+	err = ps.completeRequest(repoReqID, repoReq, ctx.Request(), bodyTee)
+	bodyTee = nil
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "http storing request body error").Error())
+	}
+	err = ps.insertResponse(repoReqID, repoReq, upstreamRepoResp)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "http inserting response to db error").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
@@ -128,31 +256,11 @@ func (ps *ProxyServer) proxyHTTPSHandler(ctx echo.Context) error {
 		logger.Warn(requestId, "cannot determine cert name for"+ctx.Request().Host)
 		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.NO_UPSTREAM_ERR)
 	}
-
-	provisionalCert, err := cert.GenCert(ps.CA, name)
-	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "generating leaf provisional cert").Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	if ps.passthrough != nil && ps.passthrough.Match(name) {
+		return ps.proxyBlindTunnel(ctx, TunnelPassthrough)
 	}
-
-	serverConfig := tls.Config{}
-	if ps.ProxyAsServerTLSConfig != nil {
-		serverConfig = *ps.ProxyAsServerTLSConfig
-	}
-	serverConfig.Certificates = []tls.Certificate{*provisionalCert}
-	var connToUpstream *tls.Conn
-	serverConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		clientConfig := tls.Config{}
-		if ps.ProxyAsClientTLSConfig != nil {
-			clientConfig = *ps.ProxyAsClientTLSConfig
-		}
-		clientConfig.ServerName = hello.ServerName
-		connToUpstream, err = tls.Dial("tcp", ctx.Request().Host, &clientConfig)
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "dial error").Error())
-			return nil, err
-		}
-		return cert.GenCert(ps.CA, hello.ServerName)
+	if ps.scope != nil && !ps.scope.ShouldDecrypt(splitHostPort(ctx.Request().Host, true)) {
+		return ps.proxyBlindTunnel(ctx, TunnelOutOfScope)
 	}
 
 	hijackedConnToClient, _, err := ctx.Response().Hijack()
@@ -161,93 +269,290 @@ func (ps *ProxyServer) proxyHTTPSHandler(ctx echo.Context) error {
 		return nil
 	}
 	defer hijackedConnToClient.Close()
+	// the http server's read/write deadlines are still armed on the hijacked connection
+	if err = hijackedConnToClient.SetDeadline(time.Time{}); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "reset deadline error").Error())
+		return nil
+	}
 
 	if _, err = hijackedConnToClient.Write(okHeader); err != nil {
 		logger.Error(requestId, errors.Wrap(err, "writing ok-header error").Error())
 		return nil
 	}
 
-	connToClient := tls.Server(hijackedConnToClient, &serverConfig)
-	if connToClient == nil {
-		logger.Error(requestId, errors.Wrap(err, "tls-server error:").Error())
-		return nil
+	upstreamHost := ctx.Request().Host
+	ps.serveTLS(logger, requestId, hijackedConnToClient, upstreamHost, func(dialCtx context.Context, clientConfig *tls.Config) (*tls.Conn, error) {
+		return ps.upstream.DialTLS(dialCtx, upstreamHost, clientConfig)
+	})
+	return nil
+}
+
+// serveTLS decrypts a client's tls connection with a leaf signed by the proxy's CA and serves its requests.
+// dialTLS opens the connection to upstreamHost once the client's ClientHello is known.
+func (ps *ProxyServer) serveTLS(logger *servLog.ServLogger, requestId uint64, rawConnToClient net.Conn, upstreamHost string,
+	dialTLS func(ctx context.Context, clientConfig *tls.Config) (*tls.Conn, error)) {
+	name, _ := splitHostPort(upstreamHost, true)
+	serverConfig := &tls.Config{}
+	if ps.ProxyAsServerTLSConfig != nil {
+		serverConfig = ps.ProxyAsServerTLSConfig.Clone()
+	}
+	serverConfig.NextProtos = clientALPNProtos
+	var connToUpstream *tls.Conn
+	meta := tunnelMeta{isHTTPS: true}
+	capture := &helloCaptureConn{Conn: rawConnToClient}
+	var fingerprint *clientHello
+	// clients connecting to an ip address send no server name
+	serverName := name
+	serverConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		var err error
+		if fingerprint, err = parseClientHello(capture.take()); err != nil {
+			logger.Warn(requestId, errors.Wrap(err, "fingerprint client hello error").Error())
+		}
+		// the client will negotiate h2 if it offers it, so only then try h2 with the upstream as well
+		upstreamProtos := []string{http1ALPNProto}
+		if containsString(hello.SupportedProtos, http2.NextProtoTLS) {
+			upstreamProtos = clientALPNProtos
+		}
+		if hello.ServerName != "" {
+			serverName = hello.ServerName
+		}
+		clientConfig, check, clientCert := ps.upstreamTLSConfig(serverName, upstreamProtos)
+		connToUpstream, err = dialTLS(hello.Context(), clientConfig)
+		if failure := check.Failure(err); failure != nil {
+			meta.tlsFailureID = ps.recordTLSFailure(logger, requestId, upstreamHost, check, failure)
+		}
+		if err != nil {
+			logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+			return nil, err
+		}
+		meta.clientCert = clientCert.Identity()
+		var upstreamLeaf *x509.Certificate
+		if peers := connToUpstream.ConnectionState().PeerCertificates; len(peers) > 0 {
+			upstreamLeaf = peers[0]
+		}
+		return ps.leafCerts.Leaf(serverName, upstreamLeaf)
 	}
+
+	connToClient := tls.Server(capture, serverConfig)
 	defer connToClient.Close()
 
-	err = connToClient.Handshake()
-	if err != nil {
+	if err := connToClient.Handshake(); err != nil {
 		logger.Error(requestId, errors.Wrap(err, "tls-server error:").Error())
-		return nil
+		return
 	}
 
 	if connToUpstream == nil {
 		logger.Warn(requestId, "connection to upstrean error")
-		return nil
+		return
 	}
 	defer connToUpstream.Close()
+	meta.tlsSessionID = ps.recordTLSSession(logger, requestId, fingerprint, connToUpstream)
 
-	reader := bufio.NewReader(connToClient)
-	request, err := http.ReadRequest(reader)
-	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "getting request error").Error())
-		return nil
+	if connToClient.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		ps.serveHTTP2(logger, requestId, connToClient, connToUpstream, upstreamHost, serverName, meta)
+		return
 	}
+	ps.serveTunnel(logger, requestId, connToClient, connToUpstream, meta)
+}
 
-	requestByte, err := httputil.DumpRequest(request, true)
-	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "dump request error").Error())
-		return nil
+// serveTunnel serves the requests sent over a tunnel one after another until either side closes it.
+func (ps *ProxyServer) serveTunnel(logger *servLog.ServLogger, requestId uint64, connToClient net.Conn, connToUpstream net.Conn, meta tunnelMeta) {
+	clientReader := bufio.NewReader(connToClient)
+	upstreamReader := bufio.NewReader(connToUpstream)
+	for {
+		keepAlive, err := ps.proxyTunnelExchange(logger, requestId, connToClient, clientReader, connToUpstream, upstreamReader, meta)
+		if err != nil {
+			logger.Error(requestId, err.Error())
+			return
+		}
+		if !keepAlive {
+			return
+		}
 	}
+}
 
-	repoReq := FormRequestData(request, requestByte)
-	repoReq.IsHTTPS = true
-	repoReqID, err := ps.repo.InsertRequest(repoReq)
-
+// proxyTunnelExchange serves one request/response pair on a decrypted tunnel.
+// It reports whether the tunnel may be reused for the next request.
+func (ps *ProxyServer) proxyTunnelExchange(logger *servLog.ServLogger, requestId uint64, connToClient net.Conn, clientReader *bufio.Reader, connToUpstream net.Conn, upstreamReader *bufio.Reader, meta tunnelMeta) (bool, error) {
+	if err := connToClient.SetReadDeadline(time.Now().Add(ps.tunnelIdleTimeout())); err != nil {
+		return false, errors.Wrap(err, "set idle deadline error")
+	}
+	request, err := http.ReadRequest(clientReader)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "https inserting request to db error").Error())
-		return nil
+		if isTunnelClosed(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "getting request error")
+	}
+	if err = connToClient.SetReadDeadline(time.Time{}); err != nil {
+		return false, errors.Wrap(err, "reset idle deadline error")
+	}
+	firedRules, err := ps.rewriteRequest(request)
+	if err != nil {
+		return false, err
+	}
+	if request, err = ps.interceptRequest(context.Background(), request, meta.isHTTPS); err != nil {
+		return false, errors.Wrap(err, "intercept request error")
+	}
+	if request == nil {
+		_, err = connToClient.Write(droppedHeader)
+		return false, errors.Wrap(err, "write dropped response error")
+	}
+	isWebsocket := websocket.IsUpgradeRequest(request)
+	if isWebsocket {
+		websocket.DisableExtensions(request)
 	}
 
-	_, err = connToUpstream.Write(requestByte)
+	repoReq, bodyTee, err := ps.captureRequest(request)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "write request error").Error())
-		return nil
+		return false, err
+	}
+	repoReq.IsHTTPS = meta.isHTTPS
+	repoReq.RewriteRules = firedRules
+	repoReq.TLSFailureID = meta.tlsFailureID
+	repoReq.ClientCert = meta.clientCert
+	repoReq.TLSSessionID = meta.tlsSessionID
+	repoReqID, err := ps.insertRequest(repoReq)
+	if err != nil {
+		return false, errors.Wrap(err, "tunnel inserting request to db error")
 	}
 
-	serverReader := bufio.NewReader(connToUpstream)
-	response, err := http.ReadResponse(serverReader, request)
+	err = writeRequest(connToUpstream, request)
+	if completeErr := ps.completeRequest(repoReqID, repoReq, request, bodyTee); completeErr != nil {
+		logger.Error(requestId, errors.Wrap(completeErr, "tunnel storing request body error").Error())
+	}
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "read response error").Error())
-		return nil
+		return false, errors.Wrap(err, "write request error")
 	}
 
-	rawResponse, err := httputil.DumpResponse(response, true)
+	response, err := http.ReadResponse(upstreamReader, request)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "dump response error").Error())
-		return nil
+		return false, errors.Wrap(err, "read response error")
+	}
+	defer response.Body.Close()
+
+	if isWebsocket && response.StatusCode == http.StatusSwitchingProtocols {
+		if err = websocket.WriteHandshakeResponse(connToClient, response); err != nil {
+			return false, errors.Wrap(err, "write handshake response error")
+		}
+		handshakeRepoResp := FormResponseData(response, nil)
+		handshakeRepoResp.IsHTTPS = meta.isHTTPS
+		if err = ps.insertHandshakeResponse(repoReqID, handshakeRepoResp); err != nil {
+			return false, errors.Wrap(err, "tunnel inserting response to db error")
+		}
+		ps.relayWebsocket(logger, requestId, repoReqID, connToClient, clientReader, connToUpstream, upstreamReader)
+		return false, nil
 	}
 
-	_, err = connToClient.Write(rawResponse)
+	if err = ps.rewriteResponse(repoReqID, request, response); err != nil {
+		return false, err
+	}
+	heldResponse, err := ps.interceptResponse(context.Background(), request, response, meta.isHTTPS)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "write response error").Error())
-		return nil
+		return false, errors.Wrap(err, "intercept response error")
+	}
+	if heldResponse == nil {
+		_, err = connToClient.Write(droppedHeader)
+		return false, errors.Wrap(err, "write dropped response error")
 	}
+	response = heldResponse
 
-	var upsreamRespBody string
-	if b, err := io.ReadAll(response.Body); err == nil {
-		upsreamRespBody = string(b)
+	// response.Write keeps the upstream framing and streams the body, capturing it on the way
+	capture := ps.newBodyCapture()
+	response.Body = readCloser{Reader: io.TeeReader(response.Body, capture), Closer: response.Body}
+	if err = response.Write(connToClient); err != nil {
+		return false, errors.Wrap(err, "write response error")
 	}
-	upstreamRepoResp := FormResponseData(response, upsreamRespBody)
+
+	upstreamRepoResp := FormResponseData(response, capture.Bytes())
 	if upstreamRepoResp == nil {
-		logger.Error(requestId, errors.Wrap(err, "form response error").Error())
-		return nil
+		return false, errors.New("form response error")
 	}
+	upstreamRepoResp.BodyTruncated = capture.Truncated()
+	upstreamRepoResp.IsHTTPS = meta.isHTTPS
+
+	if err = ps.insertResponse(repoReqID, repoReq, upstreamRepoResp); err != nil {
+		return false, errors.Wrap(err, "tunnel inserting response to db error")
+	}
+
+	return !request.Close && !response.Close, nil
+}
 
-	err = ps.repo.InsertResponse(repoReqID, upstreamRepoResp)
+// writeRequest sends the request as it was received, streaming its body in the framing it came in
+func writeRequest(w io.Writer, request *http.Request) error {
+	head, err := httputil.DumpRequest(request, false)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "https inserting response to db error").Error())
-		return nil
+		return errors.Wrap(err, "dump request error")
+	}
+	buf := bufio.NewWriter(w)
+	buf.Write(head)
+	if request.Body != nil {
+		chunked := len(request.TransferEncoding) > 0 && request.TransferEncoding[0] == "chunked"
+		var body io.Writer = buf
+		if chunked {
+			body = httputil.NewChunkedWriter(buf)
+		}
+		if _, err = io.Copy(body, request.Body); err != nil {
+			return errors.Wrap(err, "copy request body error")
+		}
+		if chunked {
+			body.(io.Closer).Close()
+			buf.WriteString("\r\n")
+		}
 	}
+	return buf.Flush()
+}
 
-	return nil
+// restartWriteTimeout gives the response a full write timeout again after the exchange was held
+func (ps *ProxyServer) restartWriteTimeout(ctx echo.Context) {
+	if ps.intercept == nil || ps.writeTimeout <= 0 {
+		return
+	}
+	_ = http.NewResponseController(ctx.Response().Writer).SetWriteDeadline(time.Now().Add(ps.writeTimeout))
+}
+
+// forwardTransport returns the transport plain http requests are forwarded with
+func (ps *ProxyServer) forwardTransport() http.RoundTripper {
+	if ps.transport == nil {
+		return http.DefaultTransport
+	}
+	return ps.transport
+}
+
+func (ps *ProxyServer) tunnelIdleTimeout() time.Duration {
+	if ps.idleTimeout > 0 {
+		return ps.idleTimeout
+	}
+	return defaultTunnelIdleTimeout
+}
+
+// isTunnelClosed reports whether err means the client closed the tunnel or kept it idle for too long.
+func isTunnelClosed(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// upstreamTLSConfig returns the proxy's client-side tls-config for the given upstream server name,
+// verifying its certificate by the upstream tls policy and presenting the client certificate configured for it.
+// The check and the choice hold the outcome once the handshake is done.
+func (ps *ProxyServer) upstreamTLSConfig(serverName string, protos []string) (*tls.Config, *tlsverify.Check, *clientcert.Choice) {
+	clientConfig := &tls.Config{}
+	if ps.ProxyAsClientTLSConfig != nil {
+		clientConfig = ps.ProxyAsClientTLSConfig.Clone()
+	}
+	clientConfig.ServerName = serverName
+	clientConfig.NextProtos = protos
+	return clientConfig, ps.tlsPolicy.Apply(clientConfig, serverName), ps.clientCerts.Apply(clientConfig, serverName)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
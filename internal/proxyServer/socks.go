@@ -0,0 +1,177 @@
+package proxyserver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/pkg/errors"
+)
+
+// SOCKS5 protocol values, see RFC 1928
+const (
+	socksVersion = 0x05
+
+	socksAuthNone         = 0x00
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyGeneralFailure      = 0x01
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddrNotSupported    = 0x08
+)
+
+const defaultSocksHandshakeTimeout = 10 * time.Second
+
+// socksError is a failed SOCKS request along with the reply code the client gets
+type socksError struct {
+	reply byte
+	err   error
+}
+
+func (e *socksError) Error() string {
+	return e.err.Error()
+}
+
+// listenAndServeSOCKS accepts SOCKS5 CONNECT requests and serves the streams like the proxy's tunnels.
+func (ps *ProxyServer) listenAndServeSOCKS(socksConf *config.ServerConfig, logger *servLog.ServLogger) error {
+	handshakeTimeout := time.Duration(socksConf.ReadTimeout) * time.Second
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = defaultSocksHandshakeTimeout
+	}
+	return serveListener(socksConf.Addr(), logger, func(conn net.Conn) {
+		ps.serveSOCKS(logger, conn, handshakeTimeout)
+	})
+}
+
+func (ps *ProxyServer) serveSOCKS(logger *servLog.ServLogger, connToClient net.Conn, handshakeTimeout time.Duration) {
+	requestId := middleware.NextRequestId()
+	start := time.Now()
+	defer connToClient.Close()
+
+	if err := connToClient.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "set handshake deadline error").Error())
+		return
+	}
+	target, err := socksHandshake(connToClient)
+	if err != nil {
+		var socksErr *socksError
+		if errors.As(err, &socksErr) {
+			_ = writeSocksReply(connToClient, socksErr.reply)
+		}
+		logger.Warn(requestId, errors.Wrap(err, "socks handshake error").Error())
+		return
+	}
+	defer func() {
+		logger.Access(requestId, "SOCKS", connToClient.RemoteAddr().String(), target, "", time.Since(start))
+	}()
+
+	dialCtx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	connToUpstream, err := ps.upstream.Dial(dialCtx, "tcp", target)
+	cancel()
+	if err != nil {
+		_ = writeSocksReply(connToClient, socksReplyHostUnreachable)
+		logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+		return
+	}
+	defer connToUpstream.Close()
+
+	if err = writeSocksReply(connToClient, socksReplySucceeded); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "write socks reply error").Error())
+		return
+	}
+	if err = connToClient.SetDeadline(time.Time{}); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "reset deadline error").Error())
+		return
+	}
+	ps.serveStream(logger, requestId, connToClient, connToUpstream, target)
+}
+
+// socksHandshake negotiates the authentication method and reads the client's CONNECT request,
+// returning its target as host:port. Only unauthenticated clients are accepted.
+func socksHandshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", errors.Wrap(err, "read greeting error")
+	}
+	if header[0] != socksVersion {
+		return "", errors.New("unsupported socks version " + strconv.Itoa(int(header[0])))
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", errors.Wrap(err, "read auth methods error")
+	}
+	method := byte(socksAuthNoAcceptable)
+	for _, m := range methods {
+		if m == socksAuthNone {
+			method = socksAuthNone
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", errors.Wrap(err, "write auth method error")
+	}
+	if method == socksAuthNoAcceptable {
+		return "", errors.New("client offers no supported auth method")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", errors.Wrap(err, "read request error")
+	}
+	if request[0] != socksVersion {
+		return "", &socksError{reply: socksReplyGeneralFailure, err: errors.New("bad request version")}
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		addr := make([]byte, net.IPv4len)
+		if request[3] == socksAddrIPv6 {
+			addr = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", errors.Wrap(err, "read address error")
+		}
+		host = net.IP(addr).String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", errors.Wrap(err, "read domain length error")
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", errors.Wrap(err, "read domain error")
+		}
+		host = string(domain)
+	default:
+		return "", &socksError{reply: socksReplyAddrNotSupported, err: errors.New("unsupported address type")}
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", errors.Wrap(err, "read port error")
+	}
+
+	if request[1] != socksCmdConnect {
+		return "", &socksError{reply: socksReplyCommandNotSupported, err: errors.New("unsupported command " + strconv.Itoa(int(request[1])))}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSocksReply answers the CONNECT request, the bound address is always reported as 0.0.0.0:0
+func writeSocksReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socksVersion, reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
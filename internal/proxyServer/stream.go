@@ -0,0 +1,147 @@
+package proxyserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
+	"github.com/pkg/errors"
+)
+
+// protocols told apart by the first bytes of a stream
+const (
+	streamTLS = iota
+	streamHTTP
+	streamRaw
+)
+
+const (
+	// how long to wait for the first bytes of a stream, protocols where the server speaks first send none
+	sniffTimeout     = 3 * time.Second
+	acceptRetryDelay = 100 * time.Millisecond
+)
+
+// a tls record carrying a handshake message, followed by the major version
+var tlsHandshakePrefix = []byte{0x16, 0x03}
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("TRACE "), []byte("CONNECT "),
+}
+
+// serveListener accepts tcp connections on addr and serves each of them in its own goroutine
+func serveListener(addr string, logger *servLog.ServLogger, serve func(conn net.Conn)) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listen error")
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			logger.Error(0, errors.Wrap(err, "accept error").Error())
+			time.Sleep(acceptRetryDelay)
+			continue
+		}
+		go serve(conn)
+	}
+}
+
+// peekedConn gives back the bytes peeked at while sniffing before reading from the connection again
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// sniffProtocol peeks at the first bytes the client sends and tells which protocol it speaks.
+// The returned connection still yields the peeked bytes.
+func sniffProtocol(connToClient net.Conn) (net.Conn, int, error) {
+	if err := connToClient.SetReadDeadline(time.Now().Add(sniffTimeout)); err != nil {
+		return nil, streamRaw, errors.Wrap(err, "set sniff deadline error")
+	}
+	defer connToClient.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(connToClient)
+	conn := &peekedConn{Conn: connToClient, reader: reader}
+	if _, err := reader.Peek(1); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return conn, streamRaw, nil
+		}
+		return nil, streamRaw, errors.Wrap(err, "sniff error")
+	}
+	// whatever arrived with the first byte, the longest method with its space is 8 bytes
+	prefix, _ := reader.Peek(minInt(reader.Buffered(), 8))
+	if bytes.HasPrefix(prefix, tlsHandshakePrefix) {
+		return conn, streamTLS, nil
+	}
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(prefix, method) {
+			return conn, streamHTTP, nil
+		}
+	}
+	return conn, streamRaw, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// serveStream feeds a stream to hostport whose protocol isn't known up front into the proxy:
+// tls is decrypted like a CONNECT tunnel, plaintext http is recorded like forwarded requests
+// and anything else, as well as tls that isn't decrypted, is relayed and recorded as a tunnel.
+func (ps *ProxyServer) serveStream(logger *servLog.ServLogger, requestId uint64, connToClient, connToUpstream net.Conn, hostport string) {
+	connToClient, protocol, err := sniffProtocol(connToClient)
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return
+	}
+	host, port := splitHostPort(hostport, protocol == streamTLS)
+	if protocol == streamTLS {
+		// the target may be a bare ip address, the server name in the ClientHello is what the client asked for
+		hello, helloBytes, err := readClientHello(connToClient)
+		if err != nil {
+			logger.Error(requestId, err.Error())
+			return
+		}
+		connToClient = &peekedConn{Conn: connToClient, reader: bufio.NewReader(io.MultiReader(bytes.NewReader(helloBytes), connToClient))}
+		if hello != nil && hello.ServerName != "" {
+			host = hello.ServerName
+			hostport = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+	decrypt := ps.scope == nil || ps.scope.ShouldDecrypt(host, port)
+
+	switch {
+	case protocol == streamHTTP:
+		ps.serveTunnel(logger, requestId, connToClient, connToUpstream, tunnelMeta{})
+	case protocol == streamTLS && ps.passthrough != nil && ps.passthrough.Match(host):
+		ps.relayTunnel(logger, requestId, connToClient, connToUpstream, hostport, TunnelPassthrough)
+	case protocol == streamTLS && !decrypt:
+		ps.relayTunnel(logger, requestId, connToClient, connToUpstream, hostport, TunnelOutOfScope)
+	case protocol == streamTLS:
+		ps.serveTLS(logger, requestId, connToClient, hostport, func(ctx context.Context, clientConfig *tls.Config) (*tls.Conn, error) {
+			return upstream.Handshake(ctx, connToUpstream, clientConfig)
+		})
+	default:
+		ps.relayTunnel(logger, requestId, connToClient, connToUpstream, hostport, TunnelRaw)
+	}
+}
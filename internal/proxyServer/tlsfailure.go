@@ -0,0 +1,27 @@
+package proxyserver
+
+import (
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
+	"github.com/pkg/errors"
+)
+
+// recordTLSFailure stores the failure of the tls connection to upstreamHost along with the chain it presented.
+// It returns the id of the stored failure, nil if it couldn't be stored.
+func (ps *ProxyServer) recordTLSFailure(logger *servLog.ServLogger, requestId uint64, upstreamHost string, check *tlsverify.Check, failure error) *int64 {
+	_, port := splitHostPort(upstreamHost, true)
+	logger.Warn(requestId, errors.Wrap(failure, "upstream tls failure for "+check.Host).Error())
+	id, err := ps.repo.InsertTLSFailure(&TLSFailure{
+		Host:       check.Host,
+		Port:       port,
+		ServerName: check.ServerName,
+		Mode:       check.Mode,
+		Error:      failure.Error(),
+		Chain:      tlsverify.DescribeChain(check.Chain),
+	})
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "inserting tls failure to db error").Error())
+		return nil
+	}
+	return &id
+}
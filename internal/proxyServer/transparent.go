@@ -0,0 +1,57 @@
+package proxyserver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/pkg/errors"
+)
+
+const defaultTransparentDialTimeout = 10 * time.Second
+
+// listenAndServeTransparent accepts connections redirected to the proxy by iptables REDIRECT
+// and serves them like tunnels to their original destination.
+func (ps *ProxyServer) listenAndServeTransparent(transparentConf *config.ServerConfig, logger *servLog.ServLogger) error {
+	dialTimeout := time.Duration(transparentConf.ReadTimeout) * time.Second
+	if dialTimeout <= 0 {
+		dialTimeout = defaultTransparentDialTimeout
+	}
+	return serveListener(transparentConf.Addr(), logger, func(conn net.Conn) {
+		ps.serveTransparent(logger, conn, dialTimeout)
+	})
+}
+
+func (ps *ProxyServer) serveTransparent(logger *servLog.ServLogger, connToClient net.Conn, dialTimeout time.Duration) {
+	requestId := middleware.NextRequestId()
+	start := time.Now()
+	defer connToClient.Close()
+
+	target, err := originalDst(connToClient)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "original destination error").Error())
+		return
+	}
+	// a connection made to the listener itself would be relayed back to it forever
+	if target == connToClient.LocalAddr().String() {
+		logger.Warn(requestId, "connection to "+target+" wasn't redirected")
+		return
+	}
+	defer func() {
+		logger.Access(requestId, "TRANSPARENT", connToClient.RemoteAddr().String(), target, "", time.Since(start))
+	}()
+
+	dialCtx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	connToUpstream, err := ps.upstream.Dial(dialCtx, "tcp", target)
+	cancel()
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+		return
+	}
+	defer connToUpstream.Close()
+
+	ps.serveStream(logger, requestId, connToClient, connToUpstream, target)
+}
@@ -0,0 +1,154 @@
+package proxyserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const websocketCloseTimeout = 5 * time.Second
+
+// proxyWebsocketHandler completes a plain-http websocket handshake on both legs and relays frames.
+// The handshake request is already stored under repoReqID.
+func (ps *ProxyServer) proxyWebsocketHandler(ctx echo.Context, repoReqID uint) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+	request := ctx.Request()
+
+	addr := request.URL.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+	connToUpstream, err := ps.upstream.Dial(request.Context(), "tcp", addr)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	defer connToUpstream.Close()
+
+	if err = request.Write(connToUpstream); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "write handshake request error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	upstreamReader := bufio.NewReader(connToUpstream)
+	upstreamResp, err := http.ReadResponse(upstreamReader, request)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "read handshake response error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	defer upstreamResp.Body.Close()
+
+	if err = ps.insertHandshakeResponse(repoReqID, FormResponseData(upstreamResp, nil)); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "http inserting response to db error").Error())
+	}
+
+	if upstreamResp.StatusCode != http.StatusSwitchingProtocols {
+		for key, values := range upstreamResp.Header {
+			for _, value := range values {
+				ctx.Response().Header().Add(key, value)
+			}
+		}
+		ctx.Response().WriteHeader(upstreamResp.StatusCode)
+		if _, err = io.Copy(ctx.Response(), upstreamResp.Body); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "copy upstream's response to client").Error())
+		}
+		return nil
+	}
+
+	connToClient, clientRW, err := ctx.Response().Hijack()
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "hijacking error:").Error())
+		return nil
+	}
+	defer connToClient.Close()
+	if err = connToClient.SetDeadline(time.Time{}); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "reset deadline error").Error())
+		return nil
+	}
+
+	if err = websocket.WriteHandshakeResponse(connToClient, upstreamResp); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "write handshake response error").Error())
+		return nil
+	}
+
+	ps.relayWebsocket(logger, requestId, repoReqID, connToClient, clientRW.Reader, connToUpstream, upstreamReader)
+	return nil
+}
+
+// relayWebsocket pipes frames in both directions until either side closes,
+// storing every reassembled text/binary message under repoReqID.
+func (ps *ProxyServer) relayWebsocket(logger *servLog.ServLogger, requestId uint64, repoReqID uint,
+	connToClient net.Conn, clientReader *bufio.Reader, connToUpstream net.Conn, upstreamReader *bufio.Reader) {
+	done := make(chan error, 2)
+	go func() {
+		done <- ps.relayWebsocketFrames(logger, requestId, repoReqID, true, clientReader, connToUpstream)
+	}()
+	go func() {
+		done <- ps.relayWebsocketFrames(logger, requestId, repoReqID, false, upstreamReader, connToClient)
+	}()
+
+	err := <-done
+	if err == nil {
+		// one side sent a close frame, give the other one a chance to answer it
+		select {
+		case err = <-done:
+			connToClient.Close()
+			connToUpstream.Close()
+			return
+		case <-time.After(websocketCloseTimeout):
+		}
+	}
+	// unblock the other direction
+	connToClient.Close()
+	connToUpstream.Close()
+	<-done
+
+	if err != nil && !isTunnelClosed(err) {
+		logger.Error(requestId, errors.Wrap(err, "websocket relay error").Error())
+	}
+}
+
+func (ps *ProxyServer) relayWebsocketFrames(logger *servLog.ServLogger, requestId uint64, repoReqID uint,
+	fromClient bool, src *bufio.Reader, dst io.Writer) error {
+	var builder websocket.MessageBuilder
+	for {
+		frame, err := websocket.ReadFrame(src)
+		if err != nil {
+			return err
+		}
+		if err = websocket.WriteFrame(dst, frame); err != nil {
+			return err
+		}
+		if frame.Opcode == websocket.OpClose {
+			return nil
+		}
+
+		opcode, payload, done, err := builder.Add(frame)
+		if err != nil {
+			return err
+		}
+		if !done {
+			continue
+		}
+		msg := &WebsocketMessage{
+			FromClient: fromClient,
+			Opcode:     int(opcode),
+			Payload:    payload,
+		}
+		if repoReqID == unrecordedID {
+			continue
+		}
+		if err = ps.repo.InsertWebsocketMessage(repoReqID, msg); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "inserting websocket message to db error").Error())
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package repeater
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scanner"
+	bodydecoder "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/bodyDecoder"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	ScanJobRunning  = "running"
+	ScanJobFinished = "finished"
+)
+
+// headers that can't be mutated without breaking the request itself
+var scanSkippedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Upgrade":           true,
+	"Accept-Encoding":   true,
+}
+
+// HandleActiveScan starts an active scan of a stored request and returns the job right away,
+// findings are stored as they are confirmed.
+func (rs *RepeaterServer) HandleActiveScan(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	reqId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || reqId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	req, err := rs.repo.GetRequestByID(reqId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetRequestByID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
+	}
+	host, ok := req.Headers["Host"].(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_UPSTREAM_ERR)
+	}
+
+	job, err := rs.repo.CreateScanJob(req.ID)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "CreateScanJob error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+
+	go func() {
+		sent, found := rs.runActiveScan(job.ID, req, host, func(err error) {
+			logger.Error(requestId, errors.Wrap(err, "active scan").Error())
+		})
+		if err := rs.repo.FinishScanJob(job.ID, sent, found); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "FinishScanJob error").Error())
+		}
+	}()
+
+	return ctx.JSON(http.StatusAccepted, job)
+}
+
+func (rs *RepeaterServer) HandleScanJob(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	jobId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || jobId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	job, err := rs.repo.GetScanJob(jobId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetScanJob error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if job == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_SCAN_JOB)
+	}
+	return ctx.JSON(http.StatusOK, job)
+}
+
+// HandleScanJobFindings lists the findings of a job, request_id of each finding is the history row
+// holding the exact request that triggered it.
+func (rs *RepeaterServer) HandleScanJobFindings(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	jobId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || jobId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	findings, err := rs.findings.GetScanJobFindings(int64(jobId))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetScanJobFindings error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, findings)
+}
+
+// scanInsertionPoints returns every get param, post param, cookie and header of req
+// with its original value.
+func scanInsertionPoints(req *RequestResponse) ([]FuzzPosition, []string) {
+	positions := make([]FuzzPosition, 0)
+	originals := make([]string, 0)
+	add := func(posType string, params Map, skip map[string]bool) {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			if !skip[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			positions = append(positions, FuzzPosition{Type: posType, Name: name})
+			originals = append(originals, firstValue(params[name]))
+		}
+	}
+	add(FuzzPositionGetParam, req.GetParams, nil)
+	add(FuzzPositionPostParam, req.PostParams, nil)
+	add(FuzzPositionCookie, req.Cookies, nil)
+	add(FuzzPositionHeader, req.Headers, scanSkippedHeaders)
+	return positions, originals
+}
+
+// runActiveScan scans req, storing the findings and the progress of the job as it goes.
+func (rs *RepeaterServer) runActiveScan(jobID int64, req *RequestResponse, host string, onError func(error)) (int, int) {
+	scan := &activeScan{
+		send: func(raw []byte) (*Exchange, error) {
+			return rs.Send(raw, host, req.IsHTTPS)
+		},
+		record: func(exchange *Exchange, check scanner.ActiveCheck, pos FuzzPosition, payload, evidence string) error {
+			return rs.recordActiveFinding(jobID, req, exchange, check, pos, payload, evidence)
+		},
+		progress: func(sent, found int) {
+			if err := rs.repo.UpdateScanJob(jobID, sent, found); err != nil {
+				onError(errors.Wrap(err, "UpdateScanJob error"))
+			}
+		},
+		onError: onError,
+	}
+	return scan.run(req)
+}
+
+// activeScan runs the active checks on a request, sending the mutated requests with send
+type activeScan struct {
+	send func(raw []byte) (*Exchange, error)
+	// record stores a finding along with the exchange that triggered it
+	record func(exchange *Exchange, check scanner.ActiveCheck, pos FuzzPosition, payload, evidence string) error
+	// progress is called after each check of each insertion point
+	progress func(sent, found int)
+	onError  func(error)
+}
+
+// run sends every payload of every check to every insertion point one at a time,
+// so that time-based checks aren't skewed by the scan's own load, and returns the number
+// of requests sent and of findings.
+func (s *activeScan) run(req *RequestResponse) (int, int) {
+	var sent, found int
+	send := func(raw []byte) (*Exchange, *scanner.ActiveResult, error) {
+		sent++
+		exchange, err := s.send(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, _, err := bodydecoder.Decode(exchange.Body, exchange.Response.Header)
+		if err != nil {
+			body = exchange.Body
+		}
+		return exchange, &scanner.ActiveResult{
+			Status:   exchange.Response.StatusCode,
+			Headers:  exchange.Response.Header,
+			Body:     body,
+			Duration: exchange.Duration,
+		}, nil
+	}
+
+	_, baseline, err := send(req.Raw)
+	if err != nil {
+		s.onError(errors.Wrap(err, "baseline request"))
+		return sent, found
+	}
+
+	positions, originals := scanInsertionPoints(req)
+	for i, pos := range positions {
+		for _, check := range scanner.ActiveChecks {
+			for _, payload := range check.Payloads(originals[i]) {
+				raw, err := InjectPayloads(req.Raw, []FuzzPosition{pos}, map[int]string{0: payload.Value})
+				if err != nil {
+					s.onError(errors.Wrap(err, "InjectPayloads error"))
+					break
+				}
+				exchange, result, err := send(raw)
+				if err != nil {
+					s.onError(err)
+					continue
+				}
+				detected, evidence := payload.Detect(baseline, result)
+				if detected && payload.Confirm {
+					exchange, result, err = send(raw)
+					if err != nil {
+						s.onError(err)
+						continue
+					}
+					detected, evidence = payload.Detect(baseline, result)
+				}
+				if !detected {
+					continue
+				}
+
+				if err = s.record(exchange, check, pos, payload.Value, evidence); err != nil {
+					s.onError(err)
+				} else {
+					found++
+				}
+				// one finding per check and insertion point is enough
+				break
+			}
+			s.progress(sent, found)
+		}
+	}
+	return sent, found
+}
+
+// recordActiveFinding stores the triggering exchange as a child of the scanned request
+// and the finding against it.
+func (rs *RepeaterServer) recordActiveFinding(jobID int64, req *RequestResponse, exchange *Exchange, check scanner.ActiveCheck,
+	pos FuzzPosition, payload, evidence string) error {
+	newID, err := rs.Record(exchange, req.IsHTTPS, req.ID)
+	if err != nil {
+		return err
+	}
+	finding := &scanner.Finding{
+		RequestID:   newID,
+		Check:       check.Name,
+		Severity:    check.Severity,
+		Description: check.Description + " (" + pos.Type + " " + pos.Name + ")",
+		Evidence:    "payload: " + payload + "\n" + evidence,
+		ScanJobID:   &jobID,
+	}
+	if err = rs.findings.InsertFinding(finding); err != nil {
+		return errors.Wrap(err, "InsertFinding error")
+	}
+	return nil
+}
+
+// firstValue returns the value of a stored parameter, which holds a list when it was repeated
+func firstValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		if len(list) == 0 {
+			return ""
+		}
+		value = list[0]
+	}
+	return toString(value)
+}
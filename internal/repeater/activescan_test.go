@@ -0,0 +1,135 @@
+package repeater
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scanner"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
+)
+
+type activeHit struct {
+	check    string
+	position string
+	payload  string
+	evidence string
+}
+
+// scanTestServer scans a GET request with the parameters q and id against a server answering with handler
+func scanTestServer(t *testing.T, handler http.HandlerFunc) (hits []activeHit, sent int) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	rs := &RepeaterServer{}
+	var err error
+	if rs.upstream, err = upstream.NewDialer(&config.UpstreamProxyConfig{}); err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	req := &RequestResponse{Request: Request{
+		Method:    http.MethodGet,
+		Path:      "/search",
+		GetParams: Map{"q": "shoes", "id": "7"},
+		Headers:   Map{"Host": host},
+		Raw:       Bytes("GET /search?q=shoes&id=7 HTTP/1.1\r\nHost: " + host + "\r\n\r\n"),
+	}}
+
+	scan := &activeScan{
+		send: func(raw []byte) (*Exchange, error) {
+			return rs.Send(raw, host, false)
+		},
+		record: func(exchange *Exchange, check scanner.ActiveCheck, pos FuzzPosition, payload, evidence string) error {
+			if !strings.Contains(string(exchange.Raw), "HTTP/1.1") {
+				t.Errorf("finding recorded without the exchange that triggered it")
+			}
+			hits = append(hits, activeHit{check: check.Name, position: pos.Type + " " + pos.Name, payload: payload, evidence: evidence})
+			return nil
+		},
+		progress: func(int, int) {},
+		onError: func(err error) {
+			t.Errorf("scan error: %v", err)
+		},
+	}
+	sent, found := scan.run(req)
+	if found != len(hits) {
+		t.Errorf("found = %d, recorded %d", found, len(hits))
+	}
+	return hits, sent
+}
+
+// payloadCount is the number of payloads every insertion point gets when nothing is found
+func payloadCount() int {
+	n := 0
+	for _, check := range scanner.ActiveChecks {
+		n += len(check.Payloads("x"))
+	}
+	return n
+}
+
+func TestActiveScanReflection(t *testing.T) {
+	hits, _ := scanTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		// q is reflected as is, id is escaped
+		w.Write([]byte("<p>results for " + r.URL.Query().Get("q") + " in " + html.EscapeString(r.URL.Query().Get("id")) + "</p>"))
+	})
+	if len(hits) != 1 {
+		t.Fatalf("findings = %+v, want one", hits)
+	}
+	hit := hits[0]
+	if hit.check != "reflected_xss" || hit.position != FuzzPositionGetParam+" q" {
+		t.Errorf("finding = %+v, want reflected_xss in q", hit)
+	}
+	if !strings.HasPrefix(hit.payload, "shoes") || !strings.Contains(hit.evidence, strings.TrimPrefix(hit.payload, "shoes")) {
+		t.Errorf("evidence %q doesn't show payload %q", hit.evidence, hit.payload)
+	}
+}
+
+func TestActiveScanSQLError(t *testing.T) {
+	hits, _ := scanTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.ContainsAny(r.URL.Query().Get("id"), `'"`) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("You have an error in your SQL syntax; check the manual near ''' at line 1"))
+			return
+		}
+		w.Write([]byte("item 7"))
+	})
+	if len(hits) != 1 {
+		t.Fatalf("findings = %+v, want one", hits)
+	}
+	hit := hits[0]
+	if hit.check != "sql_injection_error" || hit.position != FuzzPositionGetParam+" id" || hit.payload != "7'" {
+		t.Errorf("finding = %+v, want sql_injection_error in id with the first payload", hit)
+	}
+	if !strings.Contains(hit.evidence, "You have an error in your SQL syntax") {
+		t.Errorf("evidence = %q", hit.evidence)
+	}
+}
+
+func TestActiveScanErrorInBaseline(t *testing.T) {
+	// an error page the server shows anyway isn't a finding
+	hits, _ := scanTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("You have an error in your SQL syntax"))
+	})
+	if len(hits) != 0 {
+		t.Errorf("findings = %+v, want none", hits)
+	}
+}
+
+func TestActiveScanNothingFound(t *testing.T) {
+	hits, sent := scanTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>results for " + html.EscapeString(r.URL.Query().Get("q")) + "</p>"))
+	})
+	if len(hits) != 0 {
+		t.Errorf("findings = %+v, want none", hits)
+	}
+	// the baseline and every payload in q and id
+	if want := 1 + 2*payloadCount(); sent != want {
+		t.Errorf("sent = %d, want %d", sent, want)
+	}
+}
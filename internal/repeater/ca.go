@@ -0,0 +1,49 @@
+package repeater
+
+import (
+	"net/http"
+
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// HandleCA shows the current CA and the retired ones still cross-signing it
+func (rs *RepeaterServer) HandleCA(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, rs.caStatus())
+}
+
+// HandleDownloadCA downloads the CA certificate as pem, der or p12, ?password= protects the p12 file
+func (rs *RepeaterServer) HandleDownloadCA(ctx echo.Context) error {
+	format := ctx.Param("format")
+	data, contentType, err := rs.CA.Export(format, ctx.QueryParam("password"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_CA_FORMAT)
+	}
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="proxy-ca.`+format+`"`)
+	return ctx.Blob(http.StatusOK, contentType, data)
+}
+
+// HandleRotateCA replaces the CA with a new one, the old one cross-signs it until the grace period ends
+func (rs *RepeaterServer) HandleRotateCA(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	if _, err := rs.CA.Rotate(); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "rotate CA error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, rs.caStatus())
+}
+
+func (rs *RepeaterServer) caStatus() *CAStatus {
+	status := &CAStatus{Current: caInfo(rs.CA.Current().Leaf), Retired: make([]CAInfo, 0)}
+	for _, retired := range rs.CA.Retired() {
+		info := caInfo(retired.Cert)
+		graceUntil := retired.GraceUntil
+		info.GraceUntil = &graceUntil
+		status.Retired = append(status.Retired, info)
+	}
+	return status
+}
@@ -0,0 +1,250 @@
+package repeater
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	proxyserver "github.com/Natali-Skv/technopark_IS_http_proxy/internal/proxyServer"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// HandleEditAndRepeatRequest applies the overrides from the body to a stored request, sends it
+// and stores the sent request and its response as a new entry whose parent is the original one.
+func (rs *RepeaterServer) HandleEditAndRepeatRequest(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	reqId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || reqId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	overrides := &RepeatOverrides{}
+	if err = ctx.Bind(overrides); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_OVERRIDES)
+	}
+	req, err := rs.repo.GetRequestByID(reqId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetRequestByID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
+	}
+
+	raw, err := ApplyOverrides(req.Raw, overrides)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_OVERRIDES)
+	}
+	isHTTPS := req.IsHTTPS
+	if overrides.IsHTTPS != nil {
+		isHTTPS = *overrides.IsHTTPS
+	}
+
+	_, entry, err := rs.SendAndRecord(raw, isHTTPS, req.ID)
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	if wantRawBody(ctx) {
+		entry.UseRawBody()
+		entry.Response.UseRawBody()
+	}
+	return ctx.JSON(http.StatusOK, entry)
+}
+
+// SendAndRecord sends raw to the host from its Host header and stores the exchange as a child of parentID.
+func (rs *RepeaterServer) SendAndRecord(raw []byte, isHTTPS bool, parentID int64) (*Exchange, *RequestResponse, error) {
+	httpReq, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "http ReadRequest error")
+	}
+	if httpReq.Host == "" {
+		return nil, nil, errors.New("request has no host")
+	}
+
+	exchange, err := rs.Send(raw, httpReq.Host, isHTTPS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newID, err := rs.Record(exchange, isHTTPS, parentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, err := rs.repo.GetRequestByID(int(newID))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "GetRequestByID error")
+	}
+	if entry.Response, err = rs.repo.GetResponseByRequestID(int(newID)); err != nil {
+		return nil, nil, errors.Wrap(err, "GetResponseByRequestID error")
+	}
+	return exchange, entry, nil
+}
+
+// Record stores an exchange sent by the repeater as a child of parentID and returns its id.
+func (rs *RepeaterServer) Record(exchange *Exchange, isHTTPS bool, parentID int64) (int64, error) {
+	body, err := io.ReadAll(exchange.Request.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "read request body error")
+	}
+	repoReq := proxyserver.FormRequestData(exchange.Request, exchange.Raw, body)
+	repoReq.IsHTTPS = isHTTPS
+	repoReq.ParentID = &parentID
+	repoReq.TLSFailureID = exchange.TLSFailureID
+	repoReq.ClientCert = exchange.ClientCert
+	newID, err := rs.history.InsertRequest(repoReq)
+	if err != nil {
+		return 0, errors.Wrap(err, "inserting repeated request error")
+	}
+	repoResp := proxyserver.FormResponseData(exchange.Response, exchange.Body)
+	repoResp.IsHTTPS = isHTTPS
+	if err = rs.history.InsertResponse(newID, repoResp); err != nil {
+		return 0, errors.Wrap(err, "inserting repeated response error")
+	}
+	return int64(newID), nil
+}
+
+// ApplyOverrides patches the raw request and returns the bytes to send.
+func ApplyOverrides(raw []byte, overrides *RepeatOverrides) ([]byte, error) {
+	if len(overrides.Raw) != 0 {
+		return overrides.Raw, nil
+	}
+	httpReq, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, errors.Wrap(err, "http ReadRequest error")
+	}
+	body, err := io.ReadAll(httpReq.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read request body error")
+	}
+
+	if overrides.Method != nil {
+		httpReq.Method = *overrides.Method
+	}
+	if overrides.Path != nil {
+		httpReq.URL.Path = *overrides.Path
+		httpReq.URL.RawPath = ""
+	}
+	if overrides.GetParams != nil {
+		query := httpReq.URL.Query()
+		patchValues(query, overrides.GetParams)
+		httpReq.URL.RawQuery = query.Encode()
+	}
+	if overrides.Headers != nil {
+		if host, ok := overrides.Headers["Host"]; ok {
+			hostValue, _ := host.(string)
+			httpReq.Host = hostValue
+			delete(overrides.Headers, "Host")
+		}
+		patchValues(url.Values(httpReq.Header), canonicalHeaders(overrides.Headers))
+	}
+	if overrides.Cookies != nil {
+		patchCookies(httpReq, overrides.Cookies)
+	}
+	if overrides.PostParams != nil {
+		form, _ := url.ParseQuery(string(body))
+		patchValues(form, overrides.PostParams)
+		body = []byte(form.Encode())
+		if httpReq.Header.Get("Content-Type") == "" {
+			httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if overrides.Body != nil {
+		body = overrides.Body
+	}
+
+	return DumpRequest(httpReq, body)
+}
+
+// DumpRequest serializes a request read by http.ReadRequest with the given body in origin form.
+func DumpRequest(httpReq *http.Request, body []byte) ([]byte, error) {
+	httpReq.RequestURI = httpReq.URL.RequestURI()
+	httpReq.TransferEncoding = nil
+	httpReq.Header.Del("Transfer-Encoding")
+	if len(body) > 0 || httpReq.Header.Get("Content-Length") != "" {
+		httpReq.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	httpReq.ContentLength = int64(len(body))
+	httpReq.Body = io.NopCloser(bytes.NewReader(body))
+
+	dump, err := httputil.DumpRequest(httpReq, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "dump request error")
+	}
+	return dump, nil
+}
+
+// patchValues sets every key of patch in values: a string or a list of strings replaces the values,
+// null removes the key
+func patchValues(values url.Values, patch Map) {
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			values.Del(key)
+		case []interface{}:
+			values.Del(key)
+			for _, item := range v {
+				values.Add(key, toString(item))
+			}
+		default:
+			values.Set(key, toString(v))
+		}
+	}
+}
+
+func canonicalHeaders(headers Map) Map {
+	canonical := Map{}
+	for key, value := range headers {
+		canonical[http.CanonicalHeaderKey(key)] = value
+	}
+	return canonical
+}
+
+func patchCookies(httpReq *http.Request, patch Map) {
+	cookies := httpReq.Cookies()
+	pairs := make([]string, 0, len(cookies)+len(patch))
+	for _, cookie := range cookies {
+		value, patched := patch[cookie.Name]
+		if !patched {
+			pairs = append(pairs, cookie.Name+"="+cookie.Value)
+			continue
+		}
+		if value != nil {
+			pairs = append(pairs, cookie.Name+"="+toString(value))
+		}
+		delete(patch, cookie.Name)
+	}
+	for name, value := range patch {
+		if value != nil {
+			pairs = append(pairs, name+"="+toString(value))
+		}
+	}
+
+	httpReq.Header.Del("Cookie")
+	if len(pairs) > 0 {
+		httpReq.Header.Set("Cookie", strings.Join(pairs, "; "))
+	}
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,86 @@
+package repeater
+
+import (
+	"testing"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	const getRaw = "GET /search?q=go&page=1 HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nCookie: a=1; b=2\r\n\r\n"
+	const postRaw = "POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 13\r\n\r\nuser=u&pass=p"
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name      string
+		raw       string
+		overrides RepeatOverrides
+		want      string
+	}{
+		{
+			name: "no overrides",
+			raw:  getRaw,
+			want: "GET /search?q=go&page=1 HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nCookie: a=1; b=2\r\n\r\n",
+		},
+		{
+			name:      "raw replaces everything",
+			raw:       getRaw,
+			overrides: RepeatOverrides{Raw: Bytes("DELETE / HTTP/1.1\r\nHost: other\r\n\r\n"), Method: str("PUT")},
+			want:      "DELETE / HTTP/1.1\r\nHost: other\r\n\r\n",
+		},
+		{
+			name:      "method and path",
+			raw:       getRaw,
+			overrides: RepeatOverrides{Method: str("HEAD"), Path: str("/find")},
+			want:      "HEAD /find?q=go&page=1 HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nCookie: a=1; b=2\r\n\r\n",
+		},
+		{
+			name:      "get params are set, added and removed",
+			raw:       getRaw,
+			overrides: RepeatOverrides{GetParams: Map{"q": "rust", "page": nil, "tag": []interface{}{"a", float64(2)}}},
+			want:      "GET /search?q=rust&tag=a&tag=2 HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nCookie: a=1; b=2\r\n\r\n",
+		},
+		{
+			name:      "headers are canonicalized and host is moved",
+			raw:       getRaw,
+			overrides: RepeatOverrides{Headers: Map{"Host": "example.org", "x-debug": true, "accept": nil}},
+			want:      "GET /search?q=go&page=1 HTTP/1.1\r\nHost: example.org\r\nCookie: a=1; b=2\r\nX-Debug: true\r\n\r\n",
+		},
+		{
+			name:      "cookies are patched in place",
+			raw:       getRaw,
+			overrides: RepeatOverrides{Cookies: Map{"a": nil, "b": "3", "c": "4"}},
+			want:      "GET /search?q=go&page=1 HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nCookie: b=3; c=4\r\n\r\n",
+		},
+		{
+			name:      "post params update the body and its length",
+			raw:       postRaw,
+			overrides: RepeatOverrides{PostParams: Map{"pass": "secret", "remember": true}},
+			want:      "POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Length: 32\r\nContent-Type: application/x-www-form-urlencoded\r\n\r\npass=secret&remember=true&user=u",
+		},
+		{
+			name:      "body wins over post params",
+			raw:       postRaw,
+			overrides: RepeatOverrides{PostParams: Map{"user": "x"}, Body: Bytes("{}")},
+			want:      "POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Length: 2\r\nContent-Type: application/x-www-form-urlencoded\r\n\r\n{}",
+		},
+		{
+			name: "chunked body is sent with a length",
+			raw:  "POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+			want: "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\nabc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyOverrides([]byte(tt.raw), &tt.overrides)
+			if err != nil {
+				t.Fatalf("ApplyOverrides: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ApplyOverrides() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ApplyOverrides([]byte("not a request"), &RepeatOverrides{}); err == nil {
+		t.Error("ApplyOverrides accepted a malformed request")
+	}
+}
@@ -0,0 +1,439 @@
+package repeater
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	FuzzSniper       = "sniper"
+	FuzzBatteringRam = "battering_ram"
+	FuzzPitchfork    = "pitchfork"
+	FuzzClusterBomb  = "cluster_bomb"
+
+	FuzzPositionGetParam  = "get_param"
+	FuzzPositionHeader    = "header"
+	FuzzPositionCookie    = "cookie"
+	FuzzPositionPostParam = "post_param"
+	FuzzPositionRaw       = "raw"
+
+	FuzzJobRunning  = "running"
+	FuzzJobFinished = "finished"
+
+	defaultFuzzWorkers     = 10
+	maxFuzzWorkers         = 100
+	defaultMaxFuzzAttempts = 100000
+)
+
+// HandleFuzz starts a fuzzing job over a stored request and returns it right away,
+// attempts are stored as they complete.
+func (rs *RepeaterServer) HandleFuzz(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	reqId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || reqId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	conf := &FuzzConfig{}
+	if err = ctx.Bind(conf); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_FUZZ_CONFIG)
+	}
+	grep, err := conf.validate(rs.maxFuzzAttempts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_FUZZ_CONFIG+": "+err.Error())
+	}
+	req, err := rs.repo.GetRequestByID(reqId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetRequestByID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
+	}
+	host, ok := req.Headers["Host"].(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_UPSTREAM_ERR)
+	}
+
+	job, err := rs.repo.CreateFuzzJob(req.ID, conf.Mode)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "CreateFuzzJob error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+
+	go func() {
+		total := rs.runFuzzJob(job.ID, req, host, conf, grep, func(err error) {
+			logger.Error(requestId, errors.Wrap(err, "fuzz attempt").Error())
+		})
+		if err := rs.repo.FinishFuzzJob(job.ID, total); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "FinishFuzzJob error").Error())
+		}
+	}()
+
+	return ctx.JSON(http.StatusAccepted, job)
+}
+
+func (rs *RepeaterServer) HandleFuzzJob(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	jobId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || jobId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	job, err := rs.repo.GetFuzzJob(jobId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetFuzzJob error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if job == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_FUZZ_JOB)
+	}
+	return ctx.JSON(http.StatusOK, job)
+}
+
+// HandleFuzzAttempts lists the attempts of a job, ?sort=status|length|duration|matches&desc=true
+// brings anomalies to the top.
+func (rs *RepeaterServer) HandleFuzzAttempts(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	jobId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || jobId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	attempts, err := rs.repo.GetFuzzAttempts(jobId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetFuzzAttempts error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+
+	desc, _ := strconv.ParseBool(ctx.QueryParam("desc"))
+	less, ok := fuzzAttemptOrders[ctx.QueryParam("sort")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_SORT_KEY)
+	}
+	sort.SliceStable(attempts, func(i, j int) bool {
+		if desc {
+			return less(&attempts[j], &attempts[i])
+		}
+		return less(&attempts[i], &attempts[j])
+	})
+	return ctx.JSON(http.StatusOK, attempts)
+}
+
+var fuzzAttemptOrders = map[string]func(a, b *FuzzAttempt) bool{
+	"":         func(a, b *FuzzAttempt) bool { return a.ID < b.ID },
+	"id":       func(a, b *FuzzAttempt) bool { return a.ID < b.ID },
+	"status":   func(a, b *FuzzAttempt) bool { return a.Status < b.Status },
+	"length":   func(a, b *FuzzAttempt) bool { return a.Length < b.Length },
+	"duration": func(a, b *FuzzAttempt) bool { return a.DurationMs < b.DurationMs },
+	"matches":  func(a, b *FuzzAttempt) bool { return len(a.Matches) < len(b.Matches) },
+}
+
+// validate checks the config and fills in its defaults. A job making more than maxAttempts attempts is refused,
+// a non-positive maxAttempts stands for the default limit.
+func (conf *FuzzConfig) validate(maxAttempts int) ([]*regexp.Regexp, error) {
+	if len(conf.Positions) == 0 {
+		return nil, errors.New("no positions")
+	}
+	var prevRaw *FuzzPosition
+	for i := range conf.Positions {
+		pos := &conf.Positions[i]
+		switch pos.Type {
+		case FuzzPositionGetParam, FuzzPositionHeader, FuzzPositionCookie, FuzzPositionPostParam:
+			if pos.Name == "" {
+				return nil, errors.New("position without name")
+			}
+		case FuzzPositionRaw:
+			if pos.Start < 0 || pos.End < pos.Start {
+				return nil, errors.New("bad raw range")
+			}
+			// payloads are spliced in from the end, which only works for disjoint ranges in a definite order
+			if prevRaw != nil && (pos.Start < prevRaw.End || pos.Start == prevRaw.Start) {
+				return nil, errors.New("raw ranges overlap or are out of order")
+			}
+			prevRaw = pos
+		default:
+			return nil, errors.New("unknown position type " + pos.Type)
+		}
+	}
+
+	switch conf.Mode {
+	case FuzzSniper, FuzzBatteringRam:
+		if len(conf.Payloads) != 1 {
+			return nil, errors.New(conf.Mode + " takes exactly one payload list")
+		}
+	case FuzzPitchfork, FuzzClusterBomb:
+		if len(conf.Payloads) != len(conf.Positions) {
+			return nil, errors.New(conf.Mode + " takes one payload list per position")
+		}
+	default:
+		return nil, errors.New("unknown mode " + conf.Mode)
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxFuzzAttempts
+	}
+	if attempts := countFuzzAttempts(conf.Mode, len(conf.Positions), conf.Payloads); attempts > maxAttempts {
+		return nil, errors.New("too many attempts, at most " + strconv.Itoa(maxAttempts) + " are allowed")
+	}
+
+	if conf.Workers <= 0 {
+		conf.Workers = defaultFuzzWorkers
+	}
+	if conf.Workers > maxFuzzWorkers {
+		conf.Workers = maxFuzzWorkers
+	}
+
+	grep := make([]*regexp.Regexp, 0, len(conf.Grep))
+	for _, expr := range conf.Grep {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad grep expression")
+		}
+		grep = append(grep, re)
+	}
+	return grep, nil
+}
+
+// runFuzzJob sends every payload combination with conf.Workers concurrent workers
+// and returns the number of attempts made.
+func (rs *RepeaterServer) runFuzzJob(jobID int64, req *RequestResponse, host string, conf *FuzzConfig,
+	grep []*regexp.Regexp, onError func(error)) int {
+	combinations := make(chan map[int]string)
+	go func() {
+		defer close(combinations)
+		generateFuzzCombinations(conf.Mode, len(conf.Positions), conf.Payloads, func(combination map[int]string) {
+			combinations <- combination
+		})
+	}()
+
+	var total int
+	var mu sync.Mutex
+	wg := sync.WaitGroup{}
+	for i := 0; i < conf.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for combination := range combinations {
+				attempt := rs.fuzzAttempt(req, host, conf.Positions, combination, grep)
+				attempt.JobID = jobID
+				if err := rs.repo.InsertFuzzAttempt(attempt); err != nil {
+					onError(errors.Wrap(err, "InsertFuzzAttempt error"))
+				}
+				mu.Lock()
+				total++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return total
+}
+
+func (rs *RepeaterServer) fuzzAttempt(req *RequestResponse, host string, positions []FuzzPosition,
+	combination map[int]string, grep []*regexp.Regexp) *FuzzAttempt {
+	attempt := &FuzzAttempt{Payloads: Map{}}
+	for i, payload := range combination {
+		attempt.Payloads[strconv.Itoa(i)] = payload
+	}
+
+	raw, err := InjectPayloads(req.Raw, positions, combination)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	attempt.Request = raw
+
+	exchange, err := rs.Send(raw, host, req.IsHTTPS)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	attempt.Status = exchange.Response.StatusCode
+	attempt.Length = len(exchange.Body)
+	attempt.DurationMs = exchange.Duration.Milliseconds()
+
+	headers := &bytes.Buffer{}
+	_ = exchange.Response.Header.Write(headers)
+	attempt.Matches = make([]string, 0)
+	for _, re := range grep {
+		if re.Match(headers.Bytes()) || re.Match(exchange.Body) {
+			attempt.Matches = append(attempt.Matches, re.String())
+		}
+	}
+	return attempt
+}
+
+// InjectPayloads puts combination[i] into positions[i], positions missing from combination keep their value.
+func InjectPayloads(raw []byte, positions []FuzzPosition, combination map[int]string) ([]byte, error) {
+	// raw ranges go first and from the end, so that earlier offsets stay valid
+	rawIdx := make([]int, 0)
+	for i := range combination {
+		if positions[i].Type == FuzzPositionRaw {
+			rawIdx = append(rawIdx, i)
+		}
+	}
+	sort.Slice(rawIdx, func(a, b int) bool { return positions[rawIdx[a]].Start > positions[rawIdx[b]].Start })
+	for _, i := range rawIdx {
+		pos := positions[i]
+		if pos.End > len(raw) {
+			return nil, errors.New("raw range out of request")
+		}
+		injected := make([]byte, 0, len(raw)-(pos.End-pos.Start)+len(combination[i]))
+		injected = append(injected, raw[:pos.Start]...)
+		injected = append(injected, combination[i]...)
+		injected = append(injected, raw[pos.End:]...)
+		raw = injected
+	}
+	if len(rawIdx) > 0 {
+		raw = fixContentLength(raw)
+	}
+
+	overrides := &RepeatOverrides{}
+	for i, payload := range combination {
+		pos := positions[i]
+		var target *Map
+		switch pos.Type {
+		case FuzzPositionGetParam:
+			target = &overrides.GetParams
+		case FuzzPositionHeader:
+			target = &overrides.Headers
+		case FuzzPositionCookie:
+			target = &overrides.Cookies
+		case FuzzPositionPostParam:
+			target = &overrides.PostParams
+		default:
+			continue
+		}
+		if *target == nil {
+			*target = Map{}
+		}
+		(*target)[pos.Name] = payload
+	}
+	if len(rawIdx) == len(combination) {
+		return raw, nil
+	}
+	return ApplyOverrides(raw, overrides)
+}
+
+// fixContentLength updates the Content-Length header after the body of raw was edited in place.
+func fixContentLength(raw []byte) []byte {
+	headEnd := bytes.Index(raw, []byte("\r\n\r\n"))
+	if headEnd < 0 {
+		return raw
+	}
+	head, body := raw[:headEnd+2], raw[headEnd+4:]
+	lines := bytes.Split(head, []byte("\r\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.ToLower(line), []byte("content-length:")) {
+			lines[i] = []byte("Content-Length: " + strconv.Itoa(len(body)))
+		}
+	}
+	fixed := bytes.Join(lines, []byte("\r\n"))
+	fixed = append(fixed, "\r\n"...)
+	return append(fixed, body...)
+}
+
+// countFuzzAttempts returns the number of attempts generateFuzzCombinations makes, math.MaxInt when it doesn't fit
+func countFuzzAttempts(mode string, nPositions int, payloads [][]string) int {
+	switch mode {
+	case FuzzSniper:
+		if len(payloads[0]) > 0 && nPositions > math.MaxInt/len(payloads[0]) {
+			return math.MaxInt
+		}
+		return nPositions * len(payloads[0])
+	case FuzzBatteringRam:
+		return len(payloads[0])
+	case FuzzPitchfork:
+		count := len(payloads[0])
+		for _, list := range payloads[1:] {
+			if len(list) < count {
+				count = len(list)
+			}
+		}
+		return count
+	case FuzzClusterBomb:
+		count := 1
+		for _, list := range payloads {
+			if len(list) == 0 {
+				return 0
+			}
+			if count > math.MaxInt/len(list) {
+				count = math.MaxInt
+				continue
+			}
+			count *= len(list)
+		}
+		return count
+	}
+	return 0
+}
+
+// generateFuzzCombinations calls yield with position index -> payload for every attempt of the mode.
+func generateFuzzCombinations(mode string, nPositions int, payloads [][]string, yield func(map[int]string)) {
+	switch mode {
+	case FuzzSniper:
+		for pos := 0; pos < nPositions; pos++ {
+			for _, payload := range payloads[0] {
+				yield(map[int]string{pos: payload})
+			}
+		}
+	case FuzzBatteringRam:
+		for _, payload := range payloads[0] {
+			combination := make(map[int]string, nPositions)
+			for pos := 0; pos < nPositions; pos++ {
+				combination[pos] = payload
+			}
+			yield(combination)
+		}
+	case FuzzPitchfork:
+		for k := 0; ; k++ {
+			combination := make(map[int]string, nPositions)
+			for pos := 0; pos < nPositions; pos++ {
+				if k >= len(payloads[pos]) {
+					return
+				}
+				combination[pos] = payloads[pos][k]
+			}
+			yield(combination)
+		}
+	case FuzzClusterBomb:
+		for _, list := range payloads {
+			if len(list) == 0 {
+				return
+			}
+		}
+		indexes := make([]int, nPositions)
+		for {
+			combination := make(map[int]string, nPositions)
+			for pos, k := range indexes {
+				combination[pos] = payloads[pos][k]
+			}
+			yield(combination)
+
+			pos := nPositions - 1
+			for ; pos >= 0; pos-- {
+				indexes[pos]++
+				if indexes[pos] < len(payloads[pos]) {
+					break
+				}
+				indexes[pos] = 0
+			}
+			if pos < 0 {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package repeater
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateFuzzCombinations(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		nPositions int
+		payloads   [][]string
+		want       []map[int]string
+	}{
+		{
+			name:       "sniper",
+			mode:       FuzzSniper,
+			nPositions: 2,
+			payloads:   [][]string{{"a", "b"}},
+			want:       []map[int]string{{0: "a"}, {0: "b"}, {1: "a"}, {1: "b"}},
+		},
+		{
+			name:       "battering ram",
+			mode:       FuzzBatteringRam,
+			nPositions: 2,
+			payloads:   [][]string{{"a", "b"}},
+			want:       []map[int]string{{0: "a", 1: "a"}, {0: "b", 1: "b"}},
+		},
+		{
+			name:       "pitchfork stops at the shortest list",
+			mode:       FuzzPitchfork,
+			nPositions: 2,
+			payloads:   [][]string{{"a", "b", "c"}, {"1", "2"}},
+			want:       []map[int]string{{0: "a", 1: "1"}, {0: "b", 1: "2"}},
+		},
+		{
+			name:       "cluster bomb",
+			mode:       FuzzClusterBomb,
+			nPositions: 2,
+			payloads:   [][]string{{"a", "b"}, {"1", "2", "3"}},
+			want: []map[int]string{
+				{0: "a", 1: "1"}, {0: "a", 1: "2"}, {0: "a", 1: "3"},
+				{0: "b", 1: "1"}, {0: "b", 1: "2"}, {0: "b", 1: "3"},
+			},
+		},
+		{
+			name:       "cluster bomb with an empty list",
+			mode:       FuzzClusterBomb,
+			nPositions: 2,
+			payloads:   [][]string{{"a", "b"}, {}},
+		},
+		{
+			name:       "sniper with no payloads",
+			mode:       FuzzSniper,
+			nPositions: 3,
+			payloads:   [][]string{{}},
+		},
+		{
+			name:       "unknown mode",
+			mode:       "shotgun",
+			nPositions: 1,
+			payloads:   [][]string{{"a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []map[int]string
+			generateFuzzCombinations(tt.mode, tt.nPositions, tt.payloads, func(combination map[int]string) {
+				got = append(got, combination)
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("combinations = %v, want %v", got, tt.want)
+			}
+			if count := countFuzzAttempts(tt.mode, tt.nPositions, tt.payloads); count != len(tt.want) {
+				t.Errorf("countFuzzAttempts() = %d, want %d", count, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestCountFuzzAttemptsSaturates(t *testing.T) {
+	big := make([]string, 1<<16)
+	payloads := [][]string{big, big, big, big, big}
+	if got := countFuzzAttempts(FuzzClusterBomb, len(payloads), payloads); got != math.MaxInt {
+		t.Errorf("cluster bomb count = %d, want math.MaxInt", got)
+	}
+	if got := countFuzzAttempts(FuzzSniper, math.MaxInt/2, [][]string{{"a", "b", "c"}}); got != math.MaxInt {
+		t.Errorf("sniper count = %d, want math.MaxInt", got)
+	}
+}
+
+func TestFuzzConfigValidate(t *testing.T) {
+	raw := func(start, end int) FuzzPosition { return FuzzPosition{Type: FuzzPositionRaw, Start: start, End: end} }
+
+	tests := []struct {
+		name        string
+		conf        FuzzConfig
+		maxAttempts int
+		wantErr     bool
+	}{
+		{
+			name: "disjoint raw ranges",
+			conf: FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{raw(0, 3), raw(3, 5), raw(8, 8)}, Payloads: [][]string{{"x"}}},
+		},
+		{
+			name:    "overlapping raw ranges",
+			conf:    FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{raw(0, 4), raw(3, 5)}, Payloads: [][]string{{"x"}}},
+			wantErr: true,
+		},
+		{
+			name:    "raw ranges out of order",
+			conf:    FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{raw(5, 6), raw(0, 1)}, Payloads: [][]string{{"x"}}},
+			wantErr: true,
+		},
+		{
+			name:    "two insertion points at one offset",
+			conf:    FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{raw(2, 2), raw(2, 2)}, Payloads: [][]string{{"x"}}},
+			wantErr: true,
+		},
+		{
+			name:    "reversed raw range",
+			conf:    FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{raw(4, 2)}, Payloads: [][]string{{"x"}}},
+			wantErr: true,
+		},
+		{
+			name: "raw ranges split by a named position",
+			conf: FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{
+				raw(0, 2), {Type: FuzzPositionHeader, Name: "X-A"}, raw(2, 4),
+			}, Payloads: [][]string{{"x"}}},
+		},
+		{
+			name:    "named position without name",
+			conf:    FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{{Type: FuzzPositionCookie}}, Payloads: [][]string{{"x"}}},
+			wantErr: true,
+		},
+		{
+			name:    "pitchfork with too few lists",
+			conf:    FuzzConfig{Mode: FuzzPitchfork, Positions: []FuzzPosition{raw(0, 1), raw(1, 2)}, Payloads: [][]string{{"x"}}},
+			wantErr: true,
+		},
+		{
+			name: "attempts at the limit",
+			conf: FuzzConfig{Mode: FuzzClusterBomb, Positions: []FuzzPosition{raw(0, 1), raw(1, 2)},
+				Payloads: [][]string{{"a", "b"}, {"1", "2"}}},
+			maxAttempts: 4,
+		},
+		{
+			name: "attempts over the limit",
+			conf: FuzzConfig{Mode: FuzzClusterBomb, Positions: []FuzzPosition{raw(0, 1), raw(1, 2)},
+				Payloads: [][]string{{"a", "b"}, {"1", "2", "3"}}},
+			maxAttempts: 4,
+			wantErr:     true,
+		},
+		{
+			name:    "bad grep expression",
+			conf:    FuzzConfig{Mode: FuzzSniper, Positions: []FuzzPosition{raw(0, 1)}, Payloads: [][]string{{"x"}}, Grep: []string{"("}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.conf.validate(tt.maxAttempts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInjectPayloadsRaw(t *testing.T) {
+	raw := []byte("POST /a HTTP/1.1\r\nHost: example.com\r\nContent-Length: 7\r\n\r\nid=1&x=")
+	positions := []FuzzPosition{
+		{Type: FuzzPositionRaw, Start: 6, End: 7},
+		{Type: FuzzPositionRaw, Start: 61, End: 62},
+		{Type: FuzzPositionRaw, Start: 65, End: 65},
+	}
+	got, err := InjectPayloads(raw, positions, map[int]string{0: "bcd", 1: "42", 2: "yz"})
+	if err != nil {
+		t.Fatalf("InjectPayloads: %v", err)
+	}
+	want := "POST /bcd HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\nid=42&x=yz"
+	if string(got) != want {
+		t.Errorf("InjectPayloads() =\n%q\nwant\n%q", got, want)
+	}
+}
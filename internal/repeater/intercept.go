@@ -0,0 +1,112 @@
+package repeater
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/intercept"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/labstack/echo/v4"
+)
+
+func (rs *RepeaterServer) HandleInterceptSettings(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, rs.intercept.Settings())
+}
+
+// HandleSetInterceptSettings replaces the intercept settings, items already held keep waiting.
+func (rs *RepeaterServer) HandleSetInterceptSettings(ctx echo.Context) error {
+	settings := intercept.Settings{}
+	if err := ctx.Bind(&settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_INTERCEPT_SETTINGS)
+	}
+	if err := rs.intercept.SetSettings(settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_INTERCEPT_SETTINGS+": "+err.Error())
+	}
+	return ctx.JSON(http.StatusOK, rs.intercept.Settings())
+}
+
+func (rs *RepeaterServer) HandleInterceptQueue(ctx echo.Context) error {
+	items := rs.intercept.Items()
+	res := make([]HeldItem, 0, len(items))
+	for i := range items {
+		res = append(res, newHeldItem(&items[i]))
+	}
+	return ctx.JSON(http.StatusOK, res)
+}
+
+func (rs *RepeaterServer) HandleHeldItem(ctx echo.Context) error {
+	itemId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil || itemId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	item, err := rs.intercept.Item(itemId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_HELD_ITEM)
+	}
+	return ctx.JSON(http.StatusOK, newHeldItem(&item))
+}
+
+// HandleForwardHeldItem releases a held item. Requests can be edited with the same overrides
+// as POST /repeat/:id, responses only with raw. An empty body forwards the item unchanged.
+func (rs *RepeaterServer) HandleForwardHeldItem(ctx echo.Context) error {
+	itemId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil || itemId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	overrides := &RepeatOverrides{}
+	if err = ctx.Bind(overrides); err != nil || overrides.IsHTTPS != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_OVERRIDES)
+	}
+	item, err := rs.intercept.Item(itemId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_HELD_ITEM)
+	}
+
+	decision := intercept.Decision{Action: intercept.ActionForward}
+	switch {
+	case item.Kind == intercept.KindResponse:
+		if overrides.editsFields() {
+			return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_OVERRIDES)
+		}
+		decision.Raw = overrides.Raw
+	case len(overrides.Raw) != 0 || overrides.editsFields():
+		if decision.Raw, err = ApplyOverrides(item.Raw, overrides); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_OVERRIDES)
+		}
+	}
+
+	if err = rs.intercept.Decide(itemId, decision); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_HELD_ITEM)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (rs *RepeaterServer) HandleDropHeldItem(ctx echo.Context) error {
+	itemId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil || itemId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	if err = rs.intercept.Decide(itemId, intercept.Decision{Action: intercept.ActionDrop}); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_HELD_ITEM)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func newHeldItem(item *intercept.Item) HeldItem {
+	return HeldItem{
+		ID:      item.ID,
+		Kind:    item.Kind,
+		Host:    item.Host,
+		Path:    item.Path,
+		IsHTTPS: item.IsHTTPS,
+		Raw:     item.Raw,
+		Created: item.Created,
+		Expires: item.Expires,
+	}
+}
+
+// editsFields reports whether any override other than raw is set
+func (overrides *RepeatOverrides) editsFields() bool {
+	return overrides.Method != nil || overrides.Path != nil || overrides.GetParams != nil || overrides.Headers != nil ||
+		overrides.Cookies != nil || overrides.PostParams != nil || overrides.Body != nil
+}
@@ -1,9 +1,16 @@
 package repeater
 
 import (
+	"crypto/x509"
 	"database/sql/driver"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/cert"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
 )
 
 type Map map[string]interface{}
@@ -32,9 +39,60 @@ func (p *Map) Scan(src interface{}) error {
 	return nil
 }
 
+// Bytes is stored as bytea and rendered as a JSON string when it is valid UTF-8,
+// otherwise as {"base64": "..."}.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(struct {
+		Base64 string `json:"base64"`
+	}{Base64: base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*b = Bytes(text)
+		return nil
+	}
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+func (b Bytes) Value() (driver.Value, error) {
+	return []byte(b), nil
+}
+
+func (b *Bytes) Scan(src interface{}) error {
+	if src == nil {
+		*b = nil
+		return nil
+	}
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion .([]byte) failed")
+	}
+	*b = append((*b)[:0], source...)
+	return nil
+}
+
 type RequestResponse struct {
 	ID int64 `json:"id"`
 	Request
+	Response *Response `json:"response,omitempty"`
 }
 
 type Request struct {
@@ -44,14 +102,247 @@ type Request struct {
 	Headers    Map    `json:"headers"`
 	Cookies    Map    `json:"cookies"`
 	PostParams Map    `json:"post_params"`
-	Raw        string `json:"raw"`
-	IsHTTPS    bool   `json:"is_https"`
+	// decoded body by default, see UseRawBody
+	Body        Bytes  `json:"body"`
+	EncodedBody Bytes  `json:"-"`
+	Charset     string `json:"charset"`
+	Raw         Bytes  `json:"raw"`
+	IsHTTPS     bool   `json:"is_https"`
+	Protocol    string `json:"protocol"`
+	ParentID    *int64 `json:"parent_id,omitempty"`
+	// match-and-replace rules that rewrote the exchange in the proxy
+	RewriteRules []string `json:"rewrite_rules,omitempty"`
+	OutOfScope   bool     `json:"out_of_scope"`
+	// failure of the upstream tls connection the request went over, see TLSFailure
+	TLSFailureID *int64 `json:"tls_failure_id,omitempty"`
+	// identity of the client certificate presented to the upstream
+	ClientCert string `json:"client_cert,omitempty"`
+	// handshakes of the tls connection the request went over, see TLSSession
+	TLSSessionID *int64 `json:"tls_session_id,omitempty"`
+	// the body is longer than the stored one
+	BodyTruncated bool `json:"body_truncated"`
 }
 type Response struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Headers Map    `json:"headers"`
-	Body    string `json:"body"`
-	Raw     string `json:"raw"`
-	IsHTTPS bool   `json:"is_https"`
+	// decoded body by default, see UseRawBody
+	Body          Bytes  `json:"body"`
+	EncodedBody   Bytes  `json:"-"`
+	BodyTruncated bool   `json:"body_truncated"`
+	Charset       string `json:"charset"`
+	Raw           Bytes  `json:"raw"`
+	IsHTTPS       bool   `json:"is_https"`
+	Protocol      string `json:"protocol"`
+}
+
+// UseRawBody replaces the decoded body with the body as it went over the wire
+func (r *Request) UseRawBody() {
+	r.Body = r.EncodedBody
+}
+
+func (r *Response) UseRawBody() {
+	r.Body = r.EncodedBody
+}
+
+type WebsocketMessage struct {
+	ID         int64     `json:"id,omitempty"`
+	RequestID  int64     `json:"request_id"`
+	FromClient bool      `json:"from_client"`
+	Opcode     int       `json:"opcode"`
+	Payload    Bytes     `json:"payload"`
+	Created    time.Time `json:"created"`
+}
+
+type WebsocketResend struct {
+	Sent  *WebsocketMessage `json:"sent"`
+	Reply *WebsocketMessage `json:"reply"`
+}
+
+// RepeatOverrides is the body of POST /repeat/:id. Raw replaces the whole stored request,
+// otherwise every set field patches it. A null value in a map removes that key.
+type RepeatOverrides struct {
+	Raw        Bytes   `json:"raw"`
+	Method     *string `json:"method"`
+	Path       *string `json:"path"`
+	GetParams  Map     `json:"get_params"`
+	Headers    Map     `json:"headers"`
+	Cookies    Map     `json:"cookies"`
+	PostParams Map     `json:"post_params"`
+	Body       Bytes   `json:"body"`
+	IsHTTPS    *bool   `json:"is_https"`
+}
+
+// HeldItem is a request or response held by the proxy's intercept queue.
+type HeldItem struct {
+	ID      int64     `json:"id"`
+	Kind    string    `json:"kind"`
+	Host    string    `json:"host"`
+	Path    string    `json:"path"`
+	IsHTTPS bool      `json:"is_https"`
+	Raw     Bytes     `json:"raw"`
+	Created time.Time `json:"created"`
+	// the timeout action is applied to the item at this time
+	Expires time.Time `json:"expires"`
+}
+
+type FuzzPosition struct {
+	Type string `json:"type"`
+	// parameter, header or cookie name
+	Name string `json:"name"`
+	// byte range [start, end) of the raw request for the raw type
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type FuzzConfig struct {
+	Positions []FuzzPosition `json:"positions"`
+	// one list for sniper and battering ram, one list per position for pitchfork and cluster bomb
+	Payloads [][]string `json:"payloads"`
+	Mode     string     `json:"mode"`
+	Workers  int        `json:"workers"`
+	// regular expressions looked up in every response
+	Grep []string `json:"grep"`
+}
+
+type FuzzJob struct {
+	ID        int64      `json:"id"`
+	RequestID int64      `json:"request_id"`
+	Mode      string     `json:"mode"`
+	Status    string     `json:"status"`
+	Total     int        `json:"total"`
+	Created   time.Time  `json:"created"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+type FuzzAttempt struct {
+	ID    int64 `json:"id"`
+	JobID int64 `json:"job_id"`
+	// position index -> injected payload
+	Payloads   Map      `json:"payloads"`
+	Request    Bytes    `json:"request"`
+	Status     int      `json:"status"`
+	Length     int      `json:"length"`
+	DurationMs int64    `json:"duration_ms"`
+	Matches    []string `json:"matches"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type ScanJob struct {
+	ID        int64  `json:"id"`
+	RequestID int64  `json:"request_id"`
+	Status    string `json:"status"`
+	// number of mutated requests sent so far
+	Sent     int        `json:"sent"`
+	Findings int        `json:"findings"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type MineConfig struct {
+	// candidate GET parameter names
+	Wordlist  []string `json:"wordlist"`
+	BatchSize int      `json:"batch_size"`
+}
+
+type MineJob struct {
+	ID        int64      `json:"id"`
+	RequestID int64      `json:"request_id"`
+	Status    string     `json:"status"`
+	Tested    int        `json:"tested"`
+	Found     int        `json:"found"`
+	Created   time.Time  `json:"created"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+type MinedParam struct {
+	ID        int64  `json:"id"`
+	JobID     int64  `json:"job_id"`
+	RequestID int64  `json:"request_id"`
+	Name      string `json:"name"`
+	// what differed from the baseline: status, length, hash or reflected
+	Reasons []string  `json:"reasons"`
+	Created time.Time `json:"created"`
+}
+
+// Tunnel is a tunnel the proxy relayed without decryption: a tls tunnel to a passthrough or out-of-scope host
+// or a raw stream.
+type Tunnel struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	Host       string    `json:"host"`
+	Port       int       `json:"port"`
+	SNI        string    `json:"sni"`
+	ALPN       []string  `json:"alpn"`
+	BytesUp    int64     `json:"bytes_up"`
+	BytesDown  int64     `json:"bytes_down"`
+	DurationMs int64     `json:"duration_ms"`
+	Created    time.Time `json:"created"`
+}
+
+// TLSFailure is an upstream tls connection whose handshake failed or whose certificate didn't verify.
+type TLSFailure struct {
+	ID         int64  `json:"id"`
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	ServerName string `json:"server_name"`
+	// verification mode the connection was made in: verify, warn or ignore
+	Mode    string               `json:"mode"`
+	Error   string               `json:"error"`
+	Chain   []tlsverify.CertInfo `json:"chain"`
+	Created time.Time            `json:"created"`
+}
+
+// TLSSession is the handshake of an intercepted tls connection with the client and the one with the upstream.
+type TLSSession struct {
+	ID  int64  `json:"id"`
+	SNI string `json:"sni"`
+	// highest version the client offers
+	ClientVersion string `json:"client_version"`
+	JA3           string `json:"ja3"`
+	JA3Hash       string `json:"ja3_hash"`
+	JA4           string `json:"ja4"`
+	// cipher suites and ALPN protocols offered by the client, in its order
+	CipherSuites []string `json:"cipher_suites"`
+	ALPN         []string `json:"alpn"`
+	// negotiated with the upstream
+	UpstreamVersion string               `json:"upstream_version"`
+	UpstreamCipher  string               `json:"upstream_cipher"`
+	UpstreamALPN    string               `json:"upstream_alpn"`
+	UpstreamChain   []tlsverify.CertInfo `json:"upstream_chain"`
+	Created         time.Time            `json:"created"`
+}
+
+// TLSClient groups the tls sessions of clients sharing a fingerprint
+type TLSClient struct {
+	JA4      string `json:"ja4"`
+	JA3Hash  string `json:"ja3_hash"`
+	Sessions int64  `json:"sessions"`
+	// server names the clients connected to
+	SNIs     []string  `json:"snis"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// CAInfo describes a CA certificate
+type CAInfo struct {
+	Subject           string    `json:"subject"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	// until when a retired CA cross-signs the current one
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+}
+
+type CAStatus struct {
+	Current CAInfo   `json:"current"`
+	Retired []CAInfo `json:"retired"`
+}
+
+func caInfo(ca *x509.Certificate) CAInfo {
+	return CAInfo{
+		Subject:           ca.Subject.String(),
+		FingerprintSHA256: cert.Fingerprint(ca),
+		NotBefore:         ca.NotBefore,
+		NotAfter:          ca.NotAfter,
+	}
 }
@@ -0,0 +1,294 @@
+package repeater
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	bodydecoder "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/bodyDecoder"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	MineJobRunning  = "running"
+	MineJobFinished = "finished"
+
+	MineReasonStatus    = "status"
+	MineReasonLength    = "length"
+	MineReasonHash      = "hash"
+	MineReasonReflected = "reflected"
+
+	defaultMineBatchSize = 64
+	// keeps the request line under the limits of common servers
+	maxMineBatchSize = 512
+)
+
+// HandleMineParams starts a param mining job over a stored request and returns it right away,
+// discovered parameters are stored as they are isolated.
+func (rs *RepeaterServer) HandleMineParams(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	reqId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || reqId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	conf := &MineConfig{}
+	if err = ctx.Bind(conf); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_MINE_CONFIG)
+	}
+	if len(conf.Wordlist) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_MINE_CONFIG+": empty wordlist")
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultMineBatchSize
+	}
+	if conf.BatchSize > maxMineBatchSize {
+		conf.BatchSize = maxMineBatchSize
+	}
+	req, err := rs.repo.GetRequestByID(reqId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetRequestByID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
+	}
+	host, ok := req.Headers["Host"].(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_UPSTREAM_ERR)
+	}
+
+	job, err := rs.repo.CreateMineJob(req.ID)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "CreateMineJob error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+
+	go func() {
+		miner := &paramMiner{
+			rs:      rs,
+			jobID:   job.ID,
+			req:     req,
+			host:    host,
+			onError: func(err error) { logger.Error(requestId, errors.Wrap(err, "param mining").Error()) },
+		}
+		miner.run(conf)
+		if err := rs.repo.FinishMineJob(job.ID, miner.tested, miner.found); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "FinishMineJob error").Error())
+		}
+	}()
+
+	return ctx.JSON(http.StatusAccepted, job)
+}
+
+func (rs *RepeaterServer) HandleMineJob(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	jobId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || jobId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	job, err := rs.repo.GetMineJob(jobId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetMineJob error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if job == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_MINE_JOB)
+	}
+	return ctx.JSON(http.StatusOK, job)
+}
+
+func (rs *RepeaterServer) HandleMineJobParams(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	jobId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || jobId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	params, err := rs.repo.GetJobMinedParams(jobId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetJobMinedParams error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, params)
+}
+
+// HandleMinedParams lists the parameters discovered for a request by all of its mining jobs
+func (rs *RepeaterServer) HandleMinedParams(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	reqId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || reqId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	params, err := rs.repo.GetMinedParams(reqId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetMinedParams error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, params)
+}
+
+// mineFingerprint is the part of a response compared against the baseline
+type mineFingerprint struct {
+	status int
+	length int
+	hash   string
+	body   []byte
+}
+
+type paramMiner struct {
+	rs      *RepeaterServer
+	jobID   int64
+	req     *RequestResponse
+	host    string
+	onError func(error)
+
+	baseline *mineFingerprint
+	// dynamic pages change length or hash on every load, those can't tell anything
+	lengthStable bool
+	hashStable   bool
+
+	tested int
+	found  int
+}
+
+func (m *paramMiner) run(conf *MineConfig) {
+	first, err := m.probe(nil)
+	if err != nil {
+		m.onError(errors.Wrap(err, "baseline request"))
+		return
+	}
+	second, err := m.probe(nil)
+	if err != nil {
+		m.onError(errors.Wrap(err, "baseline request"))
+		return
+	}
+	m.baseline = first
+	m.lengthStable = first.length == second.length
+	m.hashStable = first.hash == second.hash
+
+	candidates := m.candidates(conf.Wordlist)
+	for start := 0; start < len(candidates); start += conf.BatchSize {
+		end := start + conf.BatchSize
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		m.isolate(candidates[start:end])
+		m.tested = end
+		if err := m.rs.repo.UpdateMineJob(m.jobID, m.tested, m.found); err != nil {
+			m.onError(errors.Wrap(err, "UpdateMineJob error"))
+		}
+	}
+}
+
+// candidates drops duplicates and the parameters the request already has
+func (m *paramMiner) candidates(wordlist []string) []string {
+	seen := map[string]bool{}
+	for name := range m.req.GetParams {
+		seen[name] = true
+	}
+	res := make([]string, 0, len(wordlist))
+	for _, name := range wordlist {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		res = append(res, name)
+	}
+	return res
+}
+
+// isolate sends names in one request and, when the response differs from the baseline,
+// splits them in halves until every parameter responsible for the difference is found.
+func (m *paramMiner) isolate(names []string) {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		values[name] = mineValue()
+	}
+	fp, err := m.probe(values)
+	if err != nil {
+		m.onError(err)
+		return
+	}
+	reasons := m.compare(fp, values)
+	if len(reasons) == 0 {
+		return
+	}
+	if len(names) == 1 {
+		param := &MinedParam{JobID: m.jobID, RequestID: m.req.ID, Name: names[0], Reasons: reasons}
+		if err = m.rs.repo.InsertMinedParam(param); err != nil {
+			m.onError(errors.Wrap(err, "InsertMinedParam error"))
+			return
+		}
+		m.found++
+		return
+	}
+	m.isolate(names[:len(names)/2])
+	m.isolate(names[len(names)/2:])
+}
+
+func (m *paramMiner) compare(fp *mineFingerprint, values map[string]string) []string {
+	reasons := make([]string, 0)
+	if fp.status != m.baseline.status {
+		reasons = append(reasons, MineReasonStatus)
+	}
+	if m.lengthStable && fp.length != m.baseline.length {
+		reasons = append(reasons, MineReasonLength)
+	}
+	if m.hashStable && fp.hash != m.baseline.hash {
+		reasons = append(reasons, MineReasonHash)
+	}
+	for _, value := range values {
+		if bytes.Contains(fp.body, []byte(value)) {
+			reasons = append(reasons, MineReasonReflected)
+			break
+		}
+	}
+	return reasons
+}
+
+// probe sends the stored request with values added to its query
+func (m *paramMiner) probe(values map[string]string) (*mineFingerprint, error) {
+	overrides := &RepeatOverrides{GetParams: Map{}}
+	for name, value := range values {
+		overrides.GetParams[name] = value
+	}
+	raw, err := ApplyOverrides(m.req.Raw, overrides)
+	if err != nil {
+		return nil, err
+	}
+	exchange, err := m.rs.Send(raw, m.host, m.req.IsHTTPS)
+	if err != nil {
+		return nil, err
+	}
+	body, _, err := bodydecoder.Decode(exchange.Body, exchange.Response.Header)
+	if err != nil {
+		body = exchange.Body
+	}
+	hash := sha256.Sum256(body)
+	return &mineFingerprint{
+		status: exchange.Response.StatusCode,
+		length: len(body),
+		hash:   hex.EncodeToString(hash[:]),
+		body:   body,
+	}, nil
+}
+
+// mineValue returns a random value that can't already be in a response
+func mineValue() string {
+	buf := make([]byte, 5)
+	_, _ = rand.Read(buf)
+	return "pm" + hex.EncodeToString(buf)
+}
@@ -0,0 +1,39 @@
+package repeater
+
+import (
+	"net/http"
+
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+func (rs *RepeaterServer) HandlePassthrough(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, rs.passthrough.Hosts())
+}
+
+// HandleSetPassthrough replaces the list of host globs whose tunnels aren't decrypted.
+func (rs *RepeaterServer) HandleSetPassthrough(ctx echo.Context) error {
+	hosts := make([]string, 0)
+	if err := ctx.Bind(&hosts); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_PASSTHROUGH)
+	}
+	if err := rs.passthrough.SetHosts(hosts); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_PASSTHROUGH+": "+err.Error())
+	}
+	return ctx.JSON(http.StatusOK, rs.passthrough.Hosts())
+}
+
+// HandleTunnels lists the tunnels relayed without decryption
+func (rs *RepeaterServer) HandleTunnels(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	tunnels, err := rs.repo.GetTunnels()
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetTunnels error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, tunnels)
+}
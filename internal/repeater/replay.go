@@ -0,0 +1,165 @@
+package repeater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	proxyserver "github.com/Natali-Skv/technopark_IS_http_proxy/internal/proxyServer"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
+	"github.com/pkg/errors"
+)
+
+// upper bound for a whole replayed exchange, so that a stalled upstream can't hang the repeater
+const sendTimeout = 60 * time.Second
+
+// Exchange is a request sent by the repeater together with the upstream's answer.
+type Exchange struct {
+	Raw      []byte
+	Request  *http.Request
+	Response *http.Response
+	// fully read response body, Response.Body is already closed
+	Body     []byte
+	Duration time.Duration
+	// failure of the upstream tls connection the request was sent over, nil if there was none
+	TLSFailureID *int64
+	// identity of the client certificate presented to the upstream, empty if none was
+	ClientCert string
+}
+
+// upstreamConn is a connection to the upstream along with what is recorded about its tls handshake
+type upstreamConn struct {
+	net.Conn
+	tlsFailureID *int64
+	clientCert   string
+}
+
+// Send writes raw to the upstream byte for byte, only adapting its request line to where it goes, and reads the response.
+func (rs *RepeaterServer) Send(raw []byte, host string, isHTTPS bool) (*Exchange, error) {
+	httpReq, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, errors.Wrap(err, "http ReadRequest error")
+	}
+
+	start := time.Now()
+	connToUpstream, wire, err := rs.dialUpstream(raw, host, isHTTPS)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial error")
+	}
+	defer connToUpstream.Close()
+	if err = connToUpstream.SetDeadline(time.Now().Add(sendTimeout)); err != nil {
+		return nil, errors.Wrap(err, "set deadline error")
+	}
+
+	if _, err = connToUpstream.Write(wire); err != nil {
+		return nil, errors.Wrap(err, "write request error")
+	}
+
+	upstreamResp, err := http.ReadResponse(bufio.NewReader(connToUpstream), httpReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "read response error")
+	}
+	defer upstreamResp.Body.Close()
+	body, err := io.ReadAll(upstreamResp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read response body error")
+	}
+
+	return &Exchange{
+		Raw:          raw,
+		Request:      httpReq,
+		Response:     upstreamResp,
+		Body:         body,
+		Duration:     time.Since(start),
+		TLSFailureID: connToUpstream.tlsFailureID,
+		ClientCert:   connToUpstream.clientCert,
+	}, nil
+}
+
+// dialUpstream connects to the host of a stored request, adding the scheme's default port when it is missing,
+// and returns the bytes to write: plain http requests going through an http upstream proxy are sent to it
+// in absolute form, every other request goes to the server in origin form with a Host header.
+func (rs *RepeaterServer) dialUpstream(raw []byte, host string, isHTTPS bool) (*upstreamConn, []byte, error) {
+	if !isHTTPS {
+		if proxyURL := rs.upstream.Proxy(host); proxyURL != nil && proxyURL.Scheme != "socks5" {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+			conn, err := rs.upstream.DialProxy(ctx, proxyURL)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &upstreamConn{Conn: conn}, upstream.AbsoluteForm(raw, host, proxyURL), nil
+		}
+	}
+	conn, err := rs.dialTunnel(host, isHTTPS)
+	return conn, upstream.OriginForm(raw, host), err
+}
+
+// dialTunnel opens a connection to host that carries raw bytes end to end,
+// an http upstream proxy is asked for a CONNECT tunnel. The upstream's certificate is verified
+// by the upstream tls policy, a failure is recorded, and the client certificate configured for it is presented.
+func (rs *RepeaterServer) dialTunnel(host string, isHTTPS bool) (*upstreamConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if !isHTTPS {
+		conn, err := rs.upstream.Dial(ctx, "tcp", upstreamAddr(host, "80"))
+		if err != nil {
+			return nil, err
+		}
+		return &upstreamConn{Conn: conn}, nil
+	}
+	addr := upstreamAddr(host, "443")
+	name, _, _ := net.SplitHostPort(addr)
+	clientConfig := &tls.Config{}
+	if rs.ProxyAsClientTLSConfig != nil {
+		clientConfig = rs.ProxyAsClientTLSConfig.Clone()
+	}
+	clientConfig.ServerName = name
+	check := rs.tlsPolicy.Apply(clientConfig, name)
+	clientCert := rs.clientCerts.Apply(clientConfig, name)
+	conn, err := rs.upstream.DialTLS(ctx, addr, clientConfig)
+	var tlsFailureID *int64
+	if failure := check.Failure(err); failure != nil {
+		id, recordErr := rs.recordTLSFailure(addr, check, failure)
+		if recordErr != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, recordErr
+		}
+		tlsFailureID = &id
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upstreamConn{Conn: conn, tlsFailureID: tlsFailureID, clientCert: clientCert.Identity()}, nil
+}
+
+// recordTLSFailure stores the failure of the tls connection to addr along with the chain it presented
+func (rs *RepeaterServer) recordTLSFailure(addr string, check *tlsverify.Check, failure error) (int64, error) {
+	_, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	id, err := rs.history.InsertTLSFailure(&proxyserver.TLSFailure{
+		Host:       check.Host,
+		Port:       portNum,
+		ServerName: check.ServerName,
+		Mode:       check.Mode,
+		Error:      failure.Error(),
+		Chain:      tlsverify.DescribeChain(check.Chain),
+	})
+	return id, errors.Wrap(err, "inserting tls failure error")
+}
+
+func upstreamAddr(host, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, defaultPort)
+}
@@ -9,8 +9,43 @@ type RepeaterRepository struct {
 }
 
 const (
-	getAllQueries  = `SELECT id, method, path, get_params, headers, cookies, post_params, raw, is_https from requests;`
-	getRequestByID = `SELECT id, method, path, get_params, headers, cookies, post_params, raw, is_https from requests WHERE id = $1;`
+	getAllQueries  = `SELECT id, method, path, get_params, headers, cookies, post_params, body, body_truncated, decoded_body, charset, raw, is_https, protocol, parent_id, rewrite_rules, out_of_scope, tls_failure_id, client_cert, tls_session_id from requests;`
+	getRequestByID = `SELECT id, method, path, get_params, headers, cookies, post_params, body, body_truncated, decoded_body, charset, raw, is_https, protocol, parent_id, rewrite_rules, out_of_scope, tls_failure_id, client_cert, tls_session_id from requests WHERE id = $1;`
+
+	getAllResponses        = `SELECT request_id, code, message, headers, body, body_truncated, decoded_body, charset, raw, is_https, protocol from responses ORDER BY id;`
+	getResponseByRequestID = `SELECT request_id, code, message, headers, body, body_truncated, decoded_body, charset, raw, is_https, protocol from responses WHERE request_id = $1 ORDER BY id LIMIT 1;`
+
+	createFuzzJob     = `INSERT INTO fuzz_jobs(request_id, mode, status) VALUES($1, $2, $3) RETURNING id, request_id, mode, status, total, created, finished;`
+	finishFuzzJob     = `UPDATE fuzz_jobs SET status = $2, total = $3, finished = now() WHERE id = $1;`
+	getFuzzJob        = `SELECT id, request_id, mode, status, total, created, finished from fuzz_jobs WHERE id = $1;`
+	insertFuzzAttempt = `INSERT INTO fuzz_attempts(job_id, payloads, request, status, length, duration_ms, matches, error) VALUES($1, $2, $3, $4, $5, $6, $7, $8);`
+	getFuzzAttempts   = `SELECT id, job_id, payloads, request, status, length, duration_ms, matches, error from fuzz_attempts WHERE job_id = $1 ORDER BY id;`
+
+	createScanJob = `INSERT INTO scan_jobs(request_id, status) VALUES($1, $2) RETURNING id, request_id, status, sent, findings, created, finished;`
+	updateScanJob = `UPDATE scan_jobs SET sent = $2, findings = $3 WHERE id = $1;`
+	finishScanJob = `UPDATE scan_jobs SET status = $2, sent = $3, findings = $4, finished = now() WHERE id = $1;`
+	getScanJob    = `SELECT id, request_id, status, sent, findings, created, finished from scan_jobs WHERE id = $1;`
+
+	createMineJob     = `INSERT INTO mine_jobs(request_id, status) VALUES($1, $2) RETURNING id, request_id, status, tested, found, created, finished;`
+	updateMineJob     = `UPDATE mine_jobs SET tested = $2, found = $3 WHERE id = $1;`
+	finishMineJob     = `UPDATE mine_jobs SET status = $2, tested = $3, found = $4, finished = now() WHERE id = $1;`
+	getMineJob        = `SELECT id, request_id, status, tested, found, created, finished from mine_jobs WHERE id = $1;`
+	insertMinedParam  = `INSERT INTO mined_params(job_id, request_id, name, reasons) VALUES($1, $2, $3, $4);`
+	getMinedParams    = `SELECT id, job_id, request_id, name, reasons, created from mined_params WHERE request_id = $1 ORDER BY id;`
+	getJobMinedParams = `SELECT id, job_id, request_id, name, reasons, created from mined_params WHERE job_id = $1 ORDER BY id;`
+
+	getTunnels = `SELECT id, kind, host, port, sni, alpn, bytes_up, bytes_down, duration_ms, created from tunnels ORDER BY id;`
+
+	getTLSFailures       = `SELECT id, host, port, server_name, mode, error, chain, created from tls_failures ORDER BY id;`
+	getTLSFailuresByHost = `SELECT id, host, port, server_name, mode, error, chain, created from tls_failures WHERE host = $1 ORDER BY id;`
+
+	getTLSSessions = `SELECT id, sni, client_version, ja3, ja3_hash, ja4, cipher_suites, alpn, upstream_version, upstream_cipher, upstream_alpn, upstream_chain, created from tls_sessions
+		WHERE ($1::text = '' OR sni = $1) AND ($2::text = '' OR ja3_hash = $2) AND ($3::text = '' OR ja4 = $3) ORDER BY id;`
+	getTLSSessionByID = `SELECT id, sni, client_version, ja3, ja3_hash, ja4, cipher_suites, alpn, upstream_version, upstream_cipher, upstream_alpn, upstream_chain, created from tls_sessions WHERE id = $1;`
+	getTLSClients     = `SELECT ja4, ja3_hash, count(*), array_agg(DISTINCT sni), max(created) from tls_sessions GROUP BY ja4, ja3_hash ORDER BY count(*) DESC;`
+
+	getWebsocketMessagesByRequestID = `SELECT id, request_id, from_client, opcode, payload, created from websocket_messages WHERE request_id = $1 ORDER BY id;`
+	getWebsocketMessageByID         = `SELECT id, request_id, from_client, opcode, payload, created from websocket_messages WHERE id = $1;`
 )
 
 func NewRepeaterRepository(conn *pgx.ConnPool) *RepeaterRepository {
@@ -30,7 +65,7 @@ func (p *RepeaterRepository) GetAllRequests() ([]RequestResponse, error) {
 
 	for rows.Next() {
 		req := RequestResponse{}
-		err = rows.Scan(&req.ID, &req.Method, &req.Path, &req.GetParams, &req.Headers, &req.Cookies, &req.PostParams, &req.Raw, &req.IsHTTPS)
+		err = rows.Scan(&req.ID, &req.Method, &req.Path, &req.GetParams, &req.Headers, &req.Cookies, &req.PostParams, &req.EncodedBody, &req.BodyTruncated, &req.Body, &req.Charset, &req.Raw, &req.IsHTTPS, &req.Protocol, &req.ParentID, &req.RewriteRules, &req.OutOfScope, &req.TLSFailureID, &req.ClientCert, &req.TLSSessionID)
 		if err == pgx.ErrNoRows {
 			return nil, nil
 		}
@@ -46,7 +81,7 @@ func (p *RepeaterRepository) GetRequestByID(id int) (*RequestResponse, error) {
 	req := &RequestResponse{}
 
 	err := p.conn.QueryRow(getRequestByID, id).
-		Scan(&req.ID, &req.Method, &req.Path, &req.GetParams, &req.Headers, &req.Cookies, &req.PostParams, &req.Raw, &req.IsHTTPS)
+		Scan(&req.ID, &req.Method, &req.Path, &req.GetParams, &req.Headers, &req.Cookies, &req.PostParams, &req.EncodedBody, &req.BodyTruncated, &req.Body, &req.Charset, &req.Raw, &req.IsHTTPS, &req.Protocol, &req.ParentID, &req.RewriteRules, &req.OutOfScope, &req.TLSFailureID, &req.ClientCert, &req.TLSSessionID)
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
@@ -56,3 +91,349 @@ func (p *RepeaterRepository) GetRequestByID(id int) (*RequestResponse, error) {
 
 	return req, nil
 }
+
+// GetAllResponses returns the first response of every request, keyed by request id
+func (p *RepeaterRepository) GetAllResponses() (map[int64]*Response, error) {
+	rows, err := p.conn.Query(getAllResponses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[int64]*Response)
+
+	for rows.Next() {
+		resp := &Response{}
+		var reqID int64
+		err = rows.Scan(&reqID, &resp.Code, &resp.Message, &resp.Headers, &resp.EncodedBody, &resp.BodyTruncated, &resp.Body, &resp.Charset, &resp.Raw, &resp.IsHTTPS, &resp.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := res[reqID]; !ok {
+			res[reqID] = resp
+		}
+	}
+
+	return res, rows.Err()
+}
+
+func (p *RepeaterRepository) GetResponseByRequestID(reqID int) (*Response, error) {
+	resp := &Response{}
+	var id int64
+
+	err := p.conn.QueryRow(getResponseByRequestID, reqID).
+		Scan(&id, &resp.Code, &resp.Message, &resp.Headers, &resp.EncodedBody, &resp.BodyTruncated, &resp.Body, &resp.Charset, &resp.Raw, &resp.IsHTTPS, &resp.Protocol)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (p *RepeaterRepository) GetWebsocketMessages(reqID int) ([]WebsocketMessage, error) {
+	rows, err := p.conn.Query(getWebsocketMessagesByRequestID, reqID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]WebsocketMessage, 0)
+
+	for rows.Next() {
+		msg := WebsocketMessage{}
+		err = rows.Scan(&msg.ID, &msg.RequestID, &msg.FromClient, &msg.Opcode, &msg.Payload, &msg.Created)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, msg)
+	}
+
+	return res, rows.Err()
+}
+
+func (p *RepeaterRepository) GetWebsocketMessageByID(id int) (*WebsocketMessage, error) {
+	msg := &WebsocketMessage{}
+
+	err := p.conn.QueryRow(getWebsocketMessageByID, id).
+		Scan(&msg.ID, &msg.RequestID, &msg.FromClient, &msg.Opcode, &msg.Payload, &msg.Created)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func (p *RepeaterRepository) CreateFuzzJob(reqID int64, mode string) (*FuzzJob, error) {
+	job := &FuzzJob{}
+	err := p.conn.QueryRow(createFuzzJob, reqID, mode, FuzzJobRunning).
+		Scan(&job.ID, &job.RequestID, &job.Mode, &job.Status, &job.Total, &job.Created, &job.Finished)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (p *RepeaterRepository) FinishFuzzJob(jobID int64, total int) error {
+	_, err := p.conn.Exec(finishFuzzJob, jobID, FuzzJobFinished, total)
+	return err
+}
+
+func (p *RepeaterRepository) GetFuzzJob(id int) (*FuzzJob, error) {
+	job := &FuzzJob{}
+	err := p.conn.QueryRow(getFuzzJob, id).
+		Scan(&job.ID, &job.RequestID, &job.Mode, &job.Status, &job.Total, &job.Created, &job.Finished)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (p *RepeaterRepository) CreateScanJob(reqID int64) (*ScanJob, error) {
+	job := &ScanJob{}
+	err := p.conn.QueryRow(createScanJob, reqID, ScanJobRunning).
+		Scan(&job.ID, &job.RequestID, &job.Status, &job.Sent, &job.Findings, &job.Created, &job.Finished)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (p *RepeaterRepository) UpdateScanJob(jobID int64, sent, findings int) error {
+	_, err := p.conn.Exec(updateScanJob, jobID, sent, findings)
+	return err
+}
+
+func (p *RepeaterRepository) FinishScanJob(jobID int64, sent, findings int) error {
+	_, err := p.conn.Exec(finishScanJob, jobID, ScanJobFinished, sent, findings)
+	return err
+}
+
+func (p *RepeaterRepository) GetScanJob(id int) (*ScanJob, error) {
+	job := &ScanJob{}
+	err := p.conn.QueryRow(getScanJob, id).
+		Scan(&job.ID, &job.RequestID, &job.Status, &job.Sent, &job.Findings, &job.Created, &job.Finished)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (p *RepeaterRepository) CreateMineJob(reqID int64) (*MineJob, error) {
+	job := &MineJob{}
+	err := p.conn.QueryRow(createMineJob, reqID, MineJobRunning).
+		Scan(&job.ID, &job.RequestID, &job.Status, &job.Tested, &job.Found, &job.Created, &job.Finished)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (p *RepeaterRepository) UpdateMineJob(jobID int64, tested, found int) error {
+	_, err := p.conn.Exec(updateMineJob, jobID, tested, found)
+	return err
+}
+
+func (p *RepeaterRepository) FinishMineJob(jobID int64, tested, found int) error {
+	_, err := p.conn.Exec(finishMineJob, jobID, MineJobFinished, tested, found)
+	return err
+}
+
+func (p *RepeaterRepository) GetMineJob(id int) (*MineJob, error) {
+	job := &MineJob{}
+	err := p.conn.QueryRow(getMineJob, id).
+		Scan(&job.ID, &job.RequestID, &job.Status, &job.Tested, &job.Found, &job.Created, &job.Finished)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (p *RepeaterRepository) InsertMinedParam(param *MinedParam) error {
+	_, err := p.conn.Exec(insertMinedParam, param.JobID, param.RequestID, param.Name, param.Reasons)
+	return err
+}
+
+// GetMinedParams returns the parameters discovered by every mining job of a request
+func (p *RepeaterRepository) GetMinedParams(reqID int) ([]MinedParam, error) {
+	rows, err := p.conn.Query(getMinedParams, reqID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMinedParams(rows)
+}
+
+func (p *RepeaterRepository) GetJobMinedParams(jobID int) ([]MinedParam, error) {
+	rows, err := p.conn.Query(getJobMinedParams, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMinedParams(rows)
+}
+
+func scanMinedParams(rows *pgx.Rows) ([]MinedParam, error) {
+	defer rows.Close()
+
+	res := make([]MinedParam, 0)
+
+	for rows.Next() {
+		param := MinedParam{}
+		err := rows.Scan(&param.ID, &param.JobID, &param.RequestID, &param.Name, &param.Reasons, &param.Created)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, param)
+	}
+
+	return res, rows.Err()
+}
+
+func (p *RepeaterRepository) GetTunnels() ([]Tunnel, error) {
+	rows, err := p.conn.Query(getTunnels)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]Tunnel, 0)
+
+	for rows.Next() {
+		tunnel := Tunnel{}
+		err = rows.Scan(&tunnel.ID, &tunnel.Kind, &tunnel.Host, &tunnel.Port, &tunnel.SNI, &tunnel.ALPN, &tunnel.BytesUp, &tunnel.BytesDown, &tunnel.DurationMs, &tunnel.Created)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, tunnel)
+	}
+
+	return res, rows.Err()
+}
+
+// GetTLSFailures returns the recorded upstream tls failures, only those of host when it isn't empty
+func (p *RepeaterRepository) GetTLSFailures(host string) ([]TLSFailure, error) {
+	var rows *pgx.Rows
+	var err error
+	if host == "" {
+		rows, err = p.conn.Query(getTLSFailures)
+	} else {
+		rows, err = p.conn.Query(getTLSFailuresByHost, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]TLSFailure, 0)
+
+	for rows.Next() {
+		failure := TLSFailure{}
+		err = rows.Scan(&failure.ID, &failure.Host, &failure.Port, &failure.ServerName, &failure.Mode, &failure.Error, &failure.Chain, &failure.Created)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, failure)
+	}
+
+	return res, rows.Err()
+}
+
+// GetTLSSessions returns the recorded tls sessions, the filters that aren't empty must match
+func (p *RepeaterRepository) GetTLSSessions(sni, ja3Hash, ja4 string) ([]TLSSession, error) {
+	rows, err := p.conn.Query(getTLSSessions, sni, ja3Hash, ja4)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]TLSSession, 0)
+
+	for rows.Next() {
+		session := TLSSession{}
+		err = rows.Scan(&session.ID, &session.SNI, &session.ClientVersion, &session.JA3, &session.JA3Hash, &session.JA4, &session.CipherSuites, &session.ALPN,
+			&session.UpstreamVersion, &session.UpstreamCipher, &session.UpstreamALPN, &session.UpstreamChain, &session.Created)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, session)
+	}
+
+	return res, rows.Err()
+}
+
+func (p *RepeaterRepository) GetTLSSessionByID(id int64) (*TLSSession, error) {
+	session := &TLSSession{}
+	err := p.conn.QueryRow(getTLSSessionByID, id).
+		Scan(&session.ID, &session.SNI, &session.ClientVersion, &session.JA3, &session.JA3Hash, &session.JA4, &session.CipherSuites, &session.ALPN,
+			&session.UpstreamVersion, &session.UpstreamCipher, &session.UpstreamALPN, &session.UpstreamChain, &session.Created)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetTLSClients groups the tls sessions by the fingerprints of the clients, the most frequent first
+func (p *RepeaterRepository) GetTLSClients() ([]TLSClient, error) {
+	rows, err := p.conn.Query(getTLSClients)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]TLSClient, 0)
+
+	for rows.Next() {
+		client := TLSClient{}
+		err = rows.Scan(&client.JA4, &client.JA3Hash, &client.Sessions, &client.SNIs, &client.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, client)
+	}
+
+	return res, rows.Err()
+}
+
+func (p *RepeaterRepository) InsertFuzzAttempt(attempt *FuzzAttempt) error {
+	_, err := p.conn.Exec(insertFuzzAttempt, attempt.JobID, attempt.Payloads, attempt.Request, attempt.Status,
+		attempt.Length, attempt.DurationMs, attempt.Matches, attempt.Error)
+	return err
+}
+
+func (p *RepeaterRepository) GetFuzzAttempts(jobID int) ([]FuzzAttempt, error) {
+	rows, err := p.conn.Query(getFuzzAttempts, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]FuzzAttempt, 0)
+
+	for rows.Next() {
+		attempt := FuzzAttempt{}
+		err = rows.Scan(&attempt.ID, &attempt.JobID, &attempt.Payloads, &attempt.Request, &attempt.Status,
+			&attempt.Length, &attempt.DurationMs, &attempt.Matches, &attempt.Error)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, attempt)
+	}
+
+	return res, rows.Err()
+}
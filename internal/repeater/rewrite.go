@@ -0,0 +1,50 @@
+package repeater
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/rewrite"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/labstack/echo/v4"
+)
+
+func (rs *RepeaterServer) HandleRewriteRules(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, rs.rewrite.Rules())
+}
+
+// HandleSetRewriteRules replaces all the match-and-replace rules with the list from the body.
+func (rs *RepeaterServer) HandleSetRewriteRules(ctx echo.Context) error {
+	rules := make([]rewrite.Rule, 0)
+	if err := ctx.Bind(&rules); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REWRITE_RULE)
+	}
+	res, err := rs.rewrite.SetRules(rules)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REWRITE_RULE+": "+err.Error())
+	}
+	return ctx.JSON(http.StatusOK, res)
+}
+
+func (rs *RepeaterServer) HandleAddRewriteRule(ctx echo.Context) error {
+	rule := rewrite.Rule{}
+	if err := ctx.Bind(&rule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REWRITE_RULE)
+	}
+	res, err := rs.rewrite.AddRule(rule)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REWRITE_RULE+": "+err.Error())
+	}
+	return ctx.JSON(http.StatusCreated, res)
+}
+
+func (rs *RepeaterServer) HandleDeleteRewriteRule(ctx echo.Context) error {
+	ruleId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil || ruleId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	if err = rs.rewrite.DeleteRule(ruleId); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REWRITE_RULE)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
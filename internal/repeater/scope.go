@@ -0,0 +1,25 @@
+package repeater
+
+import (
+	"net/http"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scope"
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/labstack/echo/v4"
+)
+
+func (rs *RepeaterServer) HandleScope(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, rs.scope.Settings())
+}
+
+// HandleSetScope replaces the target scope, tunnels already open keep their decryption decision.
+func (rs *RepeaterServer) HandleSetScope(ctx echo.Context) error {
+	settings := scope.Settings{}
+	if err := ctx.Bind(&settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_SCOPE)
+	}
+	if err := rs.scope.SetSettings(settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_SCOPE+": "+err.Error())
+	}
+	return ctx.JSON(http.StatusOK, rs.scope.Settings())
+}
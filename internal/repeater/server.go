@@ -1,18 +1,23 @@
 package repeater
 
 import (
-	"bufio"
 	"crypto/tls"
-	"fmt"
-	"io"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/intercept"
+	proxyserver "github.com/Natali-Skv/technopark_IS_http_proxy/internal/proxyServer"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/rewrite"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scanner"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/scope"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/cert"
+	clientcert "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/clientCert"
 	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
 	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	tlsverify "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/tlsVerify"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"github.com/pkg/errors"
@@ -20,18 +25,41 @@ import (
 
 type RepeaterServer struct {
 	repo RepeaterRepository
-	CA   *tls.Certificate
+	// stores the requests sent by the repeater in the proxy's history
+	history   *proxyserver.ProxyRepository
+	findings  *scanner.ScannerRepository
+	intercept *intercept.Queue
+	rewrite   *rewrite.Engine
+	scope     *scope.Scope
+	// hosts the proxy doesn't decrypt
+	passthrough *proxyserver.PassthroughList
+	CA          *cert.Authority
 	// proxy server's tls-config for connecting to client as server
 	ProxyAsServerTLSConfig *tls.Config
 
 	// proxy server's tls-config for connecting to upstream-server as client
 	ProxyAsClientTLSConfig *tls.Config
+
+	// connects to upstream servers, through the upstream proxy when one is configured
+	upstream *upstream.Dialer
+	// how the certificates of upstream servers are verified
+	tlsPolicy *tlsverify.Policy
+	// client certificates presented to the upstreams requiring mutual tls
+	clientCerts *clientcert.Store
+	// most attempts a fuzzing job may make
+	maxFuzzAttempts int
 }
 
-func NewRepeaterServer(repo *RepeaterRepository, caCert *tls.Certificate, servConf, clientConf *tls.Config) *RepeaterServer {
+func NewRepeaterServer(repo *RepeaterRepository, history *proxyserver.ProxyRepository, findings *scanner.ScannerRepository, interceptQueue *intercept.Queue, rewriteEngine *rewrite.Engine, targetScope *scope.Scope, passthrough *proxyserver.PassthroughList, ca *cert.Authority, servConf, clientConf *tls.Config) *RepeaterServer {
 	return &RepeaterServer{
 		repo:                   *repo,
-		CA:                     caCert,
+		history:                history,
+		findings:               findings,
+		intercept:              interceptQueue,
+		rewrite:                rewriteEngine,
+		scope:                  targetScope,
+		passthrough:            passthrough,
+		CA:                     ca,
 		ProxyAsServerTLSConfig: servConf,
 		ProxyAsClientTLSConfig: clientConf,
 	}
@@ -40,6 +68,18 @@ func NewRepeaterServer(repo *RepeaterRepository, caCert *tls.Certificate, servCo
 func (rs *RepeaterServer) ListenAndServe(repeaterConf *config.ServerConfig, mw *middleware.CommonMiddleware) {
 	e := echo.New()
 	e.Use(echomw.Recover(), mw.RequestIdMiddleware, mw.AccessLogMiddleware, mw.PanicMiddleware)
+	dialer, err := upstream.NewDialer(&repeaterConf.UpstreamProxy)
+	if err != nil {
+		e.Logger.Fatal(errors.Wrap(err, "upstream proxy config error"))
+	}
+	rs.upstream = dialer
+	rs.maxFuzzAttempts = repeaterConf.MaxFuzzAttempts
+	if rs.tlsPolicy, err = tlsverify.NewPolicy(&repeaterConf.UpstreamTLS); err != nil {
+		e.Logger.Fatal(errors.Wrap(err, "upstream tls config error"))
+	}
+	if rs.clientCerts, err = clientcert.NewStore(repeaterConf.UpstreamTLS.ClientCerts); err != nil {
+		e.Logger.Fatal(errors.Wrap(err, "upstream client certificates config error"))
+	}
 
 	httpServ := http.Server{
 		Addr:         repeaterConf.Addr(),
@@ -51,6 +91,43 @@ func (rs *RepeaterServer) ListenAndServe(repeaterConf *config.ServerConfig, mw *
 	e.GET("/requests", rs.HandleAllRequests)
 	e.GET("/requests/:id", rs.HandleRequestByID)
 	e.GET("/repeat/:id", rs.HandleRepeatRequest)
+	e.POST("/repeat/:id", rs.HandleEditAndRepeatRequest)
+	e.GET("/findings", rs.HandleFindings)
+	e.POST("/fuzz/:id", rs.HandleFuzz)
+	e.GET("/fuzz/jobs/:id", rs.HandleFuzzJob)
+	e.GET("/fuzz/jobs/:id/attempts", rs.HandleFuzzAttempts)
+	e.POST("/scan/:id", rs.HandleActiveScan)
+	e.GET("/scan/jobs/:id", rs.HandleScanJob)
+	e.GET("/scan/jobs/:id/findings", rs.HandleScanJobFindings)
+	e.POST("/mine/:id", rs.HandleMineParams)
+	e.GET("/mine/jobs/:id", rs.HandleMineJob)
+	e.GET("/mine/jobs/:id/params", rs.HandleMineJobParams)
+	e.GET("/requests/:id/params", rs.HandleMinedParams)
+	e.GET("/intercept", rs.HandleInterceptSettings)
+	e.PUT("/intercept", rs.HandleSetInterceptSettings)
+	e.GET("/intercept/queue", rs.HandleInterceptQueue)
+	e.GET("/intercept/queue/:id", rs.HandleHeldItem)
+	e.POST("/intercept/queue/:id/forward", rs.HandleForwardHeldItem)
+	e.POST("/intercept/queue/:id/drop", rs.HandleDropHeldItem)
+	e.GET("/rewrite/rules", rs.HandleRewriteRules)
+	e.PUT("/rewrite/rules", rs.HandleSetRewriteRules)
+	e.POST("/rewrite/rules", rs.HandleAddRewriteRule)
+	e.DELETE("/rewrite/rules/:id", rs.HandleDeleteRewriteRule)
+	e.GET("/scope", rs.HandleScope)
+	e.PUT("/scope", rs.HandleSetScope)
+	e.GET("/passthrough", rs.HandlePassthrough)
+	e.PUT("/passthrough", rs.HandleSetPassthrough)
+	e.GET("/tunnels", rs.HandleTunnels)
+	e.GET("/tls/failures", rs.HandleTLSFailures)
+	e.GET("/tls/sessions", rs.HandleTLSSessions)
+	e.GET("/tls/sessions/:id", rs.HandleTLSSession)
+	e.GET("/tls/clients", rs.HandleTLSClients)
+	e.GET("/ca", rs.HandleCA)
+	e.POST("/ca/rotate", rs.HandleRotateCA)
+	e.GET("/ca/:format", rs.HandleDownloadCA)
+	e.GET("/requests/:id/response", rs.HandleResponseByRequestID)
+	e.GET("/requests/:id/websocket", rs.HandleWebsocketMessages)
+	e.GET("/repeat/websocket/:id", rs.HandleRepeatWebsocketMessage)
 
 	e.Logger.Fatal(e.StartServer(&httpServ))
 }
@@ -63,6 +140,24 @@ func (rs *RepeaterServer) HandleAllRequests(ctx echo.Context) error {
 		logger.Error(requestId, errors.Wrap(err, "request dump error").Error())
 		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
 	}
+	if withResponse, _ := strconv.ParseBool(ctx.QueryParam("with_response")); withResponse {
+		responses, err := rs.repo.GetAllResponses()
+		if err != nil {
+			logger.Error(requestId, errors.Wrap(err, "GetAllResponses error").Error())
+			return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
+		}
+		for i := range requests {
+			requests[i].Response = responses[requests[i].ID]
+		}
+	}
+	if wantRawBody(ctx) {
+		for i := range requests {
+			requests[i].UseRawBody()
+			if requests[i].Response != nil {
+				requests[i].Response.UseRawBody()
+			}
+		}
+	}
 	return ctx.JSON(http.StatusOK, requests)
 }
 
@@ -83,59 +178,17 @@ func (rs *RepeaterServer) HandleRepeatRequest(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
 	}
 
-	httpReq, err := http.ReadRequest(bufio.NewReader(strings.NewReader(req.Raw)))
-	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "http ReadRequest error").Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
-	}
-	var upstreamResp *http.Response
-
 	host, ok := req.Headers["Host"].(string)
 	if !ok {
 		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_UPSTREAM_ERR)
 	}
 
-	httpReq.Host = host
-	httpReq.URL.Host = host
-	httpReq.URL.Scheme = "http"
-	httpReq.URL.Opaque = ""
-
-	if req.IsHTTPS {
-		httpReq.Host = fmt.Sprintf("%s:%s", host, "443")
-		clientConfig := tls.Config{}
-		if rs.ProxyAsClientTLSConfig != nil {
-			clientConfig = *rs.ProxyAsClientTLSConfig
-		}
-		clientConfig.InsecureSkipVerify = true
-		connToUpstream, err := tls.Dial("tcp", httpReq.Host, &clientConfig)
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "dial error").Error())
-			return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
-		}
-		defer connToUpstream.Close()
-
-		_, err = connToUpstream.Write([]byte(req.Raw))
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "write request error").Error())
-			return nil
-		}
-
-		serverReader := bufio.NewReader(connToUpstream)
-		upstreamResp, err = http.ReadResponse(serverReader, httpReq)
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "read response error").Error())
-			return nil
-		}
-
-	} else {
-		upstreamResp, err = http.DefaultTransport.RoundTrip(httpReq)
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "round trip").Error())
-			return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
-		}
-		defer upstreamResp.Body.Close()
-
+	exchange, err := rs.Send(req.Raw, host, req.IsHTTPS)
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
 	}
+	upstreamResp := exchange.Response
 
 	for key, values := range upstreamResp.Header {
 		for _, value := range values {
@@ -144,7 +197,7 @@ func (rs *RepeaterServer) HandleRepeatRequest(ctx echo.Context) error {
 	}
 
 	ctx.Response().Status = upstreamResp.StatusCode
-	if _, err = io.Copy(ctx.Response(), upstreamResp.Body); err != nil {
+	if _, err = ctx.Response().Write(exchange.Body); err != nil {
 		logger.Error(requestId, errors.Wrap(err, "copy upstream's response to client").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
@@ -168,5 +221,58 @@ func (rs *RepeaterServer) HandleRequestByID(ctx echo.Context) error {
 	if req == nil {
 		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
 	}
+	if wantRawBody(ctx) {
+		req.UseRawBody()
+	}
 	return ctx.JSON(http.StatusOK, req)
 }
+
+func (rs *RepeaterServer) HandleResponseByRequestID(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	reqId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || reqId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	resp, err := rs.repo.GetResponseByRequestID(reqId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetResponseByRequestID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if resp == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_RESPONSE)
+	}
+	if wantRawBody(ctx) {
+		resp.UseRawBody()
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// HandleFindings lists scanner findings, optionally filtered by ?request_id= and ?severity=
+func (rs *RepeaterServer) HandleFindings(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	var reqId int64
+	if param := ctx.QueryParam("request_id"); param != "" {
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || id < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+		}
+		reqId = id
+	}
+	findings, err := rs.findings.GetFindings(reqId, ctx.QueryParam("severity"))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetFindings error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, findings)
+}
+
+// wantRawBody reports whether the client asked for bodies as they went over the wire (?raw=true)
+// instead of decoded ones
+func wantRawBody(ctx echo.Context) bool {
+	raw, _ := strconv.ParseBool(ctx.QueryParam("raw"))
+	return raw
+}
@@ -0,0 +1,70 @@
+package repeater
+
+import (
+	"net/http"
+	"strconv"
+
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// HandleTLSFailures lists the upstream tls failures with the chains the servers presented, ?host= narrows them to one host
+func (rs *RepeaterServer) HandleTLSFailures(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	failures, err := rs.repo.GetTLSFailures(ctx.QueryParam("host"))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetTLSFailures error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, failures)
+}
+
+// HandleTLSSessions lists the handshakes of the intercepted tls connections,
+// optionally filtered by ?sni=, ?ja3= (the JA3 hash) and ?ja4=
+func (rs *RepeaterServer) HandleTLSSessions(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	sessions, err := rs.repo.GetTLSSessions(ctx.QueryParam("sni"), ctx.QueryParam("ja3"), ctx.QueryParam("ja4"))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetTLSSessions error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, sessions)
+}
+
+func (rs *RepeaterServer) HandleTLSSession(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil || id < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	session, err := rs.repo.GetTLSSessionByID(id)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetTLSSessionByID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if session == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_TLS_SESSION)
+	}
+	return ctx.JSON(http.StatusOK, session)
+}
+
+// HandleTLSClients groups the tls sessions by client fingerprint
+func (rs *RepeaterServer) HandleTLSClients(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	clients, err := rs.repo.GetTLSClients()
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetTLSClients error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, clients)
+}
@@ -0,0 +1,146 @@
+package repeater
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	httperrors "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/httpErrors"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/middleware"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const websocketReplyTimeout = 5 * time.Second
+
+func (rs *RepeaterServer) HandleWebsocketMessages(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	reqId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || reqId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	messages, err := rs.repo.GetWebsocketMessages(reqId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetWebsocketMessages error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, messages)
+}
+
+// HandleRepeatWebsocketMessage opens a fresh websocket connection using the stored handshake request,
+// sends the chosen message and returns the first message the server answers with.
+func (rs *RepeaterServer) HandleRepeatWebsocketMessage(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	msgId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || msgId < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	msg, err := rs.repo.GetWebsocketMessageByID(msgId)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetWebsocketMessageByID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if msg == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_WS_MESSAGE)
+	}
+	req, err := rs.repo.GetRequestByID(int(msg.RequestID))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetRequestByID error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
+	}
+
+	handshake, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(req.Raw)))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "http ReadRequest error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	host, ok := req.Headers["Host"].(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_UPSTREAM_ERR)
+	}
+	key, err := websocket.NewKey()
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	handshake.Header.Set("Sec-WebSocket-Key", key)
+	websocket.DisableExtensions(handshake)
+
+	connToUpstream, err := rs.dialTunnel(host, req.IsHTTPS)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	defer connToUpstream.Close()
+	if err = connToUpstream.SetDeadline(time.Now().Add(websocketReplyTimeout)); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "set deadline error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+
+	if err = handshake.Write(connToUpstream); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "write handshake request error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	upstreamReader := bufio.NewReader(connToUpstream)
+	handshakeResp, err := http.ReadResponse(upstreamReader, handshake)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "read handshake response error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	handshakeResp.Body.Close()
+	if handshakeResp.StatusCode != http.StatusSwitchingProtocols {
+		return echo.NewHTTPError(http.StatusBadGateway, httperrors.WS_HANDSHAKE_ERR)
+	}
+
+	frame, err := websocket.NewClientFrame(byte(msg.Opcode), msg.Payload)
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if err = websocket.WriteFrame(connToUpstream, frame); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "write frame error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+
+	result := WebsocketResend{Sent: msg}
+	var builder websocket.MessageBuilder
+	for result.Reply == nil {
+		frame, err = websocket.ReadFrame(upstreamReader)
+		if err != nil {
+			// the server is not obliged to answer, a timeout just means no reply
+			break
+		}
+		if frame.Opcode == websocket.OpClose {
+			break
+		}
+		opcode, replyPayload, done, err := builder.Add(frame)
+		if err != nil {
+			logger.Error(requestId, errors.Wrap(err, "read reply error").Error())
+			break
+		}
+		if done {
+			result.Reply = &WebsocketMessage{
+				RequestID: msg.RequestID,
+				Opcode:    int(opcode),
+				Payload:   replyPayload,
+				Created:   time.Now(),
+			}
+		}
+	}
+
+	if closeFrame, err := websocket.NewClientFrame(websocket.OpClose, nil); err == nil {
+		_ = websocket.WriteFrame(connToUpstream, closeFrame)
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
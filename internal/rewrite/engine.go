@@ -0,0 +1,468 @@
+package rewrite
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	bodydecoder "github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/bodyDecoder"
+	"github.com/pkg/errors"
+)
+
+var ErrNoSuchRule = errors.New("no such rewrite rule")
+
+type compiledRule struct {
+	Rule
+	match       *regexp.Regexp
+	header      *regexp.Regexp
+	host        *regexp.Regexp
+	path        *regexp.Regexp
+	method      *regexp.Regexp
+	contentType *regexp.Regexp
+}
+
+// Engine applies the match-and-replace rules to the traffic passing through the proxy.
+type Engine struct {
+	mu     sync.RWMutex
+	rules  []*compiledRule
+	nextID int64
+}
+
+func NewEngine(conf *config.RewriteConfig) (*Engine, error) {
+	rules := make([]Rule, 0, len(conf.Rules))
+	for _, rule := range conf.Rules {
+		rules = append(rules, Rule{
+			Name:        rule.Name,
+			Disabled:    rule.Disabled,
+			Target:      rule.Target,
+			Action:      rule.Action,
+			Match:       rule.Match,
+			Replace:     rule.Replace,
+			Regex:       rule.Regex,
+			Header:      rule.Header,
+			Value:       rule.Value,
+			Host:        rule.Host,
+			Path:        rule.Path,
+			Method:      rule.Method,
+			ContentType: rule.ContentType,
+		})
+	}
+	e := &Engine{}
+	if _, err := e.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	res := make([]Rule, 0, len(e.rules))
+	for _, rule := range e.rules {
+		res = append(res, rule.Rule)
+	}
+	return res
+}
+
+// SetRules replaces all the rules, they are applied in the given order.
+func (e *Engine) SetRules(rules []Rule) ([]Rule, error) {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for i := range rules {
+		rule, err := compileRule(rules[i])
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, rule)
+	}
+
+	e.mu.Lock()
+	for _, rule := range compiled {
+		e.nextID++
+		rule.ID = e.nextID
+		if rule.Name == "" {
+			rule.Name = "rule " + strconv.FormatInt(rule.ID, 10)
+		}
+	}
+	e.rules = compiled
+	e.mu.Unlock()
+	return e.Rules(), nil
+}
+
+// AddRule appends a rule and returns it with its id.
+func (e *Engine) AddRule(rule Rule) (Rule, error) {
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextID++
+	compiled.ID = e.nextID
+	if compiled.Name == "" {
+		compiled.Name = "rule " + strconv.FormatInt(compiled.ID, 10)
+	}
+	e.rules = append(e.rules, compiled)
+	return compiled.Rule, nil
+}
+
+func (e *Engine) DeleteRule(id int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, rule := range e.rules {
+		if rule.ID == id {
+			e.rules = append(e.rules[:i:i], e.rules[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoSuchRule
+}
+
+func compileRule(rule Rule) (*compiledRule, error) {
+	if rule.Action == "" {
+		rule.Action = ActionReplace
+	}
+	switch rule.Target {
+	case TargetRequestLine, TargetRequestHeader, TargetRequestBody, TargetResponseHeader, TargetResponseBody:
+	default:
+		return nil, errors.New("unknown target " + rule.Target)
+	}
+	switch rule.Action {
+	case ActionReplace:
+		if rule.Match == "" {
+			return nil, errors.New("replace rule without match")
+		}
+	case ActionAddHeader, ActionRemoveHeader:
+		if rule.Target != TargetRequestHeader && rule.Target != TargetResponseHeader {
+			return nil, errors.New(rule.Action + " needs a header target")
+		}
+		if rule.Header == "" {
+			return nil, errors.New(rule.Action + " rule without header")
+		}
+	default:
+		return nil, errors.New("unknown action " + rule.Action)
+	}
+
+	compiled := &compiledRule{Rule: rule}
+	var err error
+	if rule.Regex {
+		if compiled.match, err = regexp.Compile(rule.Match); err != nil {
+			return nil, errors.Wrap(err, "bad match expression")
+		}
+		// the expression has to match the whole name, so that Host doesn't match X-Forwarded-Host
+		if compiled.header, err = regexp.Compile("^(?i:" + rule.Header + ")$"); err != nil {
+			return nil, errors.Wrap(err, "bad header expression")
+		}
+	}
+	scope := []struct {
+		expr string
+		re   **regexp.Regexp
+	}{
+		{rule.Host, &compiled.host},
+		{rule.Path, &compiled.path},
+		{rule.Method, &compiled.method},
+		{rule.ContentType, &compiled.contentType},
+	}
+	for _, s := range scope {
+		if *s.re, err = regexp.Compile(s.expr); err != nil {
+			return nil, errors.Wrap(err, "bad scope expression "+s.expr)
+		}
+	}
+	return compiled, nil
+}
+
+// exchangeScope is what the scope conditions of the rules are matched against
+type exchangeScope struct {
+	host   string
+	path   string
+	method string
+}
+
+func newExchangeScope(r *http.Request) exchangeScope {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return exchangeScope{host: host, path: r.URL.Path, method: r.Method}
+}
+
+// inScope returns the enabled rules for the targets whose scope matches
+func (e *Engine) inScope(scope exchangeScope, contentType string, targets ...string) []*compiledRule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	res := make([]*compiledRule, 0)
+	for _, rule := range e.rules {
+		if rule.Disabled || !containsTarget(targets, rule.Target) {
+			continue
+		}
+		if rule.host.MatchString(scope.host) && rule.path.MatchString(scope.path) &&
+			rule.method.MatchString(scope.method) && rule.contentType.MatchString(contentType) {
+			res = append(res, rule)
+		}
+	}
+	return res
+}
+
+// RewriteRequest applies the request rules to r in place and returns the names of the rules that changed it.
+// A body longer than maxBody bytes is left to stream through unchanged.
+func (e *Engine) RewriteRequest(r *http.Request, maxBody int) ([]string, error) {
+	rules := e.inScope(newExchangeScope(r), r.Header.Get("Content-Type"), TargetRequestLine, TargetRequestHeader, TargetRequestBody)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	fired := make([]string, 0)
+
+	requestLine := make([]*compiledRule, 0)
+	bodyRules := make([]*compiledRule, 0)
+	for _, rule := range rules {
+		switch rule.Target {
+		case TargetRequestLine:
+			requestLine = append(requestLine, rule)
+		case TargetRequestBody:
+			bodyRules = append(bodyRules, rule)
+		}
+	}
+
+	if len(requestLine) > 0 {
+		uri := r.RequestURI
+		if uri == "" {
+			uri = r.URL.RequestURI()
+		}
+		line, names := applyReplaceRules(requestLine, []byte(r.Method+" "+uri+" "+r.Proto))
+		if len(names) > 0 {
+			if err := setRequestLine(r, string(line)); err != nil {
+				return nil, err
+			}
+			fired = append(fired, names...)
+		}
+	}
+
+	headers := http.Header{"Host": {r.Host}}
+	for key, values := range r.Header {
+		headers[key] = values
+	}
+	headers, names := applyHeaderRules(rules, TargetRequestHeader, headers)
+	if len(names) > 0 {
+		// a request can't go without a host, a rule removing it leaves the old one
+		if host := headers.Get("Host"); host != "" {
+			r.Host = host
+		}
+		headers.Del("Host")
+		r.Header = headers
+		fired = append(fired, names...)
+	}
+
+	if len(bodyRules) > 0 && r.Body != nil && r.Body != http.NoBody && r.ContentLength <= int64(maxBody) {
+		body, rest, err := readBody(r.Body, maxBody)
+		if err != nil {
+			return nil, errors.Wrap(err, "read request body error")
+		}
+		if rest != nil {
+			r.Body = rest
+			return fired, nil
+		}
+		body, names := applyReplaceRules(bodyRules, body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if len(names) > 0 {
+			r.ContentLength = int64(len(body))
+			r.TransferEncoding = nil
+			r.Header.Del("Transfer-Encoding")
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			fired = append(fired, names...)
+		}
+	}
+	return fired, nil
+}
+
+// RewriteResponse applies the response rules to resp in place and returns the names of the rules that changed it.
+// A response is read in full only when a body rule is in scope, its content-encoding is undone then.
+// A body longer than maxBody bytes is left to stream through unchanged.
+func (e *Engine) RewriteResponse(r *http.Request, resp *http.Response, maxBody int) ([]string, error) {
+	rules := e.inScope(newExchangeScope(r), resp.Header.Get("Content-Type"), TargetResponseHeader, TargetResponseBody)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	fired := make([]string, 0)
+
+	bodyRules := make([]*compiledRule, 0)
+	for _, rule := range rules {
+		if rule.Target == TargetResponseBody {
+			bodyRules = append(bodyRules, rule)
+		}
+	}
+
+	if len(bodyRules) > 0 && resp.Body != nil && resp.Body != http.NoBody &&
+		resp.StatusCode != http.StatusSwitchingProtocols && resp.ContentLength <= int64(maxBody) {
+		body, rest, err := readBody(resp.Body, maxBody)
+		if err != nil {
+			return nil, errors.Wrap(err, "read response body error")
+		}
+		if rest != nil {
+			resp.Body = rest
+		} else {
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			if decoded, err := bodydecoder.Decompress(body, resp.Header); err == nil {
+				rewritten, names := applyReplaceRules(bodyRules, decoded)
+				if len(names) > 0 {
+					resp.Body = io.NopCloser(bytes.NewReader(rewritten))
+					resp.ContentLength = int64(len(rewritten))
+					resp.TransferEncoding = nil
+					resp.Header.Del("Content-Encoding")
+					resp.Header.Del("Transfer-Encoding")
+					resp.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+					fired = append(fired, names...)
+				}
+			}
+		}
+	}
+
+	headers, names := applyHeaderRules(rules, TargetResponseHeader, resp.Header)
+	if len(names) > 0 {
+		resp.Header = headers
+		fired = append(fired, names...)
+	}
+	return fired, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readBody reads body when it is at most limit bytes long and closes it. A longer body is not read through:
+// it comes back in rest, which yields the bytes already taken and then the remainder of body.
+func readBody(body io.ReadCloser, limit int) (data []byte, rest io.ReadCloser, err error) {
+	data, err = io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > limit {
+		return nil, readCloser{Reader: io.MultiReader(bytes.NewReader(data), body), Closer: body}, nil
+	}
+	return data, nil, body.Close()
+}
+
+// applyReplaceRules runs the replace rules over data one after another
+func applyReplaceRules(rules []*compiledRule, data []byte) ([]byte, []string) {
+	fired := make([]string, 0)
+	for _, rule := range rules {
+		replaced := rule.replace(data)
+		if !bytes.Equal(replaced, data) {
+			fired = append(fired, rule.Name)
+			data = replaced
+		}
+	}
+	return data, fired
+}
+
+func (rule *compiledRule) replace(data []byte) []byte {
+	if rule.Regex {
+		return rule.match.ReplaceAll(data, []byte(rule.Replace))
+	}
+	return bytes.ReplaceAll(data, []byte(rule.Match), []byte(rule.Replace))
+}
+
+// applyHeaderRules runs the rules for target over headers and returns the resulting headers
+func applyHeaderRules(rules []*compiledRule, target string, headers http.Header) (http.Header, []string) {
+	fired := make([]string, 0)
+	for _, rule := range rules {
+		if rule.Target != target {
+			continue
+		}
+		var changed bool
+		switch rule.Action {
+		case ActionAddHeader:
+			headers = headers.Clone()
+			headers.Add(rule.Header, rule.Value)
+			changed = true
+		case ActionRemoveHeader:
+			headers, changed = rule.removeHeader(headers)
+		default:
+			headers, changed = rule.replaceHeaderLines(headers)
+		}
+		if changed {
+			fired = append(fired, rule.Name)
+		}
+	}
+	return headers, fired
+}
+
+func (rule *compiledRule) removeHeader(headers http.Header) (http.Header, bool) {
+	res := http.Header{}
+	var removed bool
+	for key, values := range headers {
+		if rule.Regex && rule.header.MatchString(key) || !rule.Regex && strings.EqualFold(key, rule.Header) {
+			removed = true
+			continue
+		}
+		res[key] = values
+	}
+	return res, removed
+}
+
+func (rule *compiledRule) replaceHeaderLines(headers http.Header) (http.Header, bool) {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := http.Header{}
+	var changed bool
+	for _, key := range keys {
+		for _, value := range headers[key] {
+			line := key + ": " + value
+			replaced := string(rule.replace([]byte(line)))
+			if replaced == line {
+				res.Add(key, value)
+				continue
+			}
+			changed = true
+			name, newValue, ok := strings.Cut(replaced, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				continue
+			}
+			res.Add(textproto.TrimString(name), textproto.TrimString(newValue))
+		}
+	}
+	return res, changed
+}
+
+// setRequestLine parses a rewritten "METHOD URI PROTO" line into r
+func setRequestLine(r *http.Request, line string) error {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return errors.New("malformed rewritten request line " + line)
+	}
+	u, err := url.ParseRequestURI(parts[1])
+	if err != nil {
+		return errors.Wrap(err, "bad rewritten request uri")
+	}
+	if u.Host == "" {
+		u.Scheme, u.Host = r.URL.Scheme, r.URL.Host
+	}
+	if major, minor, ok := http.ParseHTTPVersion(parts[2]); ok {
+		r.Proto, r.ProtoMajor, r.ProtoMinor = parts[2], major, minor
+	}
+	r.Method = parts[0]
+	r.URL = u
+	r.RequestURI = parts[1]
+	return nil
+}
+
+func containsTarget(targets []string, target string) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
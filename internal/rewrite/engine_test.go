@@ -0,0 +1,424 @@
+package rewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testMaxBody = 64
+
+func newTestEngine(t *testing.T, rules ...Rule) *Engine {
+	t.Helper()
+	e := &Engine{}
+	if _, err := e.SetRules(rules); err != nil {
+		t.Fatalf("SetRules: %v", err)
+	}
+	return e
+}
+
+func newTestRequest(method, target, body string, header http.Header) *http.Request {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	return r
+}
+
+func TestRewriteRequestHeaders(t *testing.T) {
+	header := http.Header{
+		"Cookie":           {"session=abc"},
+		"X-Forwarded-Host": {"front.example.com"},
+		"User-Agent":       {"curl/8.0"},
+	}
+
+	tests := []struct {
+		name       string
+		rule       Rule
+		wantFired  []string
+		wantHost   string
+		wantHeader http.Header
+	}{
+		{
+			name:      "add",
+			rule:      Rule{Name: "add", Target: TargetRequestHeader, Action: ActionAddHeader, Header: "X-Debug", Value: "1"},
+			wantFired: []string{"add"},
+			wantHost:  "example.com",
+			wantHeader: http.Header{"Cookie": {"session=abc"}, "X-Forwarded-Host": {"front.example.com"},
+				"User-Agent": {"curl/8.0"}, "X-Debug": {"1"}},
+		},
+		{
+			name:       "replace a line",
+			rule:       Rule{Name: "ua", Target: TargetRequestHeader, Match: "curl/8.0", Replace: "Mozilla/5.0"},
+			wantFired:  []string{"ua"},
+			wantHost:   "example.com",
+			wantHeader: http.Header{"Cookie": {"session=abc"}, "X-Forwarded-Host": {"front.example.com"}, "User-Agent": {"Mozilla/5.0"}},
+		},
+		{
+			name: "replace a line with a regex",
+			rule: Rule{Name: "session", Target: TargetRequestHeader, Regex: true,
+				Match: `^Cookie: session=\w+`, Replace: "Cookie: session=admin"},
+			wantFired:  []string{"session"},
+			wantHost:   "example.com",
+			wantHeader: http.Header{"Cookie": {"session=admin"}, "X-Forwarded-Host": {"front.example.com"}, "User-Agent": {"curl/8.0"}},
+		},
+		{
+			name:       "replace the host",
+			rule:       Rule{Name: "host", Target: TargetRequestHeader, Match: "Host: example.com", Replace: "Host: staging.example.com"},
+			wantFired:  []string{"host"},
+			wantHost:   "staging.example.com",
+			wantHeader: header,
+		},
+		{
+			name:       "a line replaced with nothing is removed",
+			rule:       Rule{Name: "drop", Target: TargetRequestHeader, Regex: true, Match: `^User-Agent: .*`},
+			wantFired:  []string{"drop"},
+			wantHost:   "example.com",
+			wantHeader: http.Header{"Cookie": {"session=abc"}, "X-Forwarded-Host": {"front.example.com"}},
+		},
+		{
+			name:       "remove",
+			rule:       Rule{Name: "rm", Target: TargetRequestHeader, Action: ActionRemoveHeader, Header: "cookie"},
+			wantFired:  []string{"rm"},
+			wantHost:   "example.com",
+			wantHeader: http.Header{"X-Forwarded-Host": {"front.example.com"}, "User-Agent": {"curl/8.0"}},
+		},
+		{
+			name:       "remove by regex matches whole names",
+			rule:       Rule{Name: "rm", Target: TargetRequestHeader, Action: ActionRemoveHeader, Regex: true, Header: "host|user-agent"},
+			wantFired:  []string{"rm"},
+			wantHost:   "example.com",
+			wantHeader: http.Header{"Cookie": {"session=abc"}, "X-Forwarded-Host": {"front.example.com"}},
+		},
+		{
+			name:       "remove by regex with a wildcard",
+			rule:       Rule{Name: "rm", Target: TargetRequestHeader, Action: ActionRemoveHeader, Regex: true, Header: "x-.*"},
+			wantFired:  []string{"rm"},
+			wantHost:   "example.com",
+			wantHeader: http.Header{"Cookie": {"session=abc"}, "User-Agent": {"curl/8.0"}},
+		},
+		{
+			name:       "removing the host keeps it",
+			rule:       Rule{Name: "rm", Target: TargetRequestHeader, Action: ActionRemoveHeader, Regex: true, Header: ".*"},
+			wantFired:  []string{"rm"},
+			wantHost:   "example.com",
+			wantHeader: http.Header{},
+		},
+		{
+			name:       "blanking the host keeps it",
+			rule:       Rule{Name: "blank", Target: TargetRequestHeader, Regex: true, Match: `^Host: .*`},
+			wantFired:  []string{"blank"},
+			wantHost:   "example.com",
+			wantHeader: header,
+		},
+		{
+			name:       "no match",
+			rule:       Rule{Name: "none", Target: TargetRequestHeader, Match: "Referer: x"},
+			wantHost:   "example.com",
+			wantHeader: header,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, tt.rule)
+			r := newTestRequest(http.MethodGet, "http://example.com/", "", header.Clone())
+
+			fired, err := e.RewriteRequest(r, testMaxBody)
+			if err != nil {
+				t.Fatalf("RewriteRequest: %v", err)
+			}
+			if len(fired) != len(tt.wantFired) || len(fired) > 0 && !reflect.DeepEqual(fired, tt.wantFired) {
+				t.Errorf("fired = %q, want %q", fired, tt.wantFired)
+			}
+			if r.Host != tt.wantHost {
+				t.Errorf("host = %q, want %q", r.Host, tt.wantHost)
+			}
+			if !reflect.DeepEqual(r.Header, tt.wantHeader) {
+				t.Errorf("header = %v, want %v", r.Header, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestRewriteResponseHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       Rule
+		wantHeader http.Header
+	}{
+		{
+			name:       "cookie rule doesn't touch set-cookie",
+			rule:       Rule{Target: TargetResponseHeader, Action: ActionRemoveHeader, Regex: true, Header: "cookie"},
+			wantHeader: http.Header{"Set-Cookie": {"id=1; Secure"}, "Content-Security-Policy": {"default-src 'self'"}},
+		},
+		{
+			name:       "remove",
+			rule:       Rule{Target: TargetResponseHeader, Action: ActionRemoveHeader, Header: "Content-Security-Policy"},
+			wantHeader: http.Header{"Set-Cookie": {"id=1; Secure"}},
+		},
+		{
+			name:       "replace",
+			rule:       Rule{Target: TargetResponseHeader, Match: "; Secure", Replace: ""},
+			wantHeader: http.Header{"Set-Cookie": {"id=1"}, "Content-Security-Policy": {"default-src 'self'"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, tt.rule)
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+				"Set-Cookie":              {"id=1; Secure"},
+				"Content-Security-Policy": {"default-src 'self'"},
+			}}
+			if _, err := e.RewriteResponse(httptest.NewRequest(http.MethodGet, "http://example.com/", nil), resp, testMaxBody); err != nil {
+				t.Fatalf("RewriteResponse: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Header, tt.wantHeader) {
+				t.Errorf("header = %v, want %v", resp.Header, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestRewriteRequestBody(t *testing.T) {
+	rule := Rule{Name: "role", Target: TargetRequestBody, Match: "role=user", Replace: "role=administrator"}
+	longBody := "role=user&" + strings.Repeat("a", testMaxBody)
+
+	tests := []struct {
+		name       string
+		body       string
+		chunked    bool
+		wantFired  []string
+		wantBody   string
+		wantLength string
+	}{
+		{
+			name:       "replace fixes content-length",
+			body:       "name=x&role=user",
+			wantFired:  []string{"role"},
+			wantBody:   "name=x&role=administrator",
+			wantLength: "25",
+		},
+		{
+			name:       "chunked body gets a length",
+			body:       "role=user",
+			chunked:    true,
+			wantFired:  []string{"role"},
+			wantBody:   "role=administrator",
+			wantLength: "18",
+		},
+		{
+			name:       "no match keeps the body",
+			body:       "name=x",
+			wantBody:   "name=x",
+			wantLength: "6",
+		},
+		{
+			name:       "body over the cap streams through",
+			body:       longBody,
+			wantBody:   longBody,
+			wantLength: "74",
+		},
+		{
+			name:     "chunked body over the cap streams through",
+			body:     longBody,
+			chunked:  true,
+			wantBody: longBody,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, rule)
+			r := newTestRequest(http.MethodPost, "http://example.com/profile", tt.body, nil)
+			if tt.chunked {
+				r.ContentLength = -1
+				r.TransferEncoding = []string{"chunked"}
+			} else {
+				r.Header.Set("Content-Length", strconv.Itoa(len(tt.body)))
+			}
+
+			fired, err := e.RewriteRequest(r, testMaxBody)
+			if err != nil {
+				t.Fatalf("RewriteRequest: %v", err)
+			}
+			if len(fired) != len(tt.wantFired) || len(fired) > 0 && !reflect.DeepEqual(fired, tt.wantFired) {
+				t.Errorf("fired = %q, want %q", fired, tt.wantFired)
+			}
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := r.Header.Get("Content-Length"); got != tt.wantLength {
+				t.Errorf("Content-Length = %q, want %q", got, tt.wantLength)
+			}
+			if len(tt.wantFired) > 0 && (r.ContentLength != int64(len(tt.wantBody)) || r.TransferEncoding != nil) {
+				t.Errorf("ContentLength = %d and TransferEncoding = %q after a rewrite", r.ContentLength, r.TransferEncoding)
+			}
+		})
+	}
+}
+
+func TestRewriteResponseBody(t *testing.T) {
+	rule := Rule{Name: "debug", Target: TargetResponseBody, Regex: true, Match: `"debug":\s*false`, Replace: `"debug":true`}
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.Bytes()
+	}
+	longBody := `{"debug": false, "pad": "` + strings.Repeat("a", testMaxBody) + `"}`
+
+	tests := []struct {
+		name         string
+		body         []byte
+		encoding     string
+		wantFired    bool
+		wantBody     string
+		wantEncoding string
+	}{
+		{
+			name:      "plain",
+			body:      []byte(`{"debug": false}`),
+			wantFired: true,
+			wantBody:  `{"debug":true}`,
+		},
+		{
+			name:      "gzip is undone",
+			body:      gzipped(`{"debug": false}`),
+			encoding:  "gzip",
+			wantFired: true,
+			wantBody:  `{"debug":true}`,
+		},
+		{
+			name:         "unknown encoding is left alone",
+			body:         []byte(`{"debug": false}`),
+			encoding:     "zstd",
+			wantBody:     `{"debug": false}`,
+			wantEncoding: "zstd",
+		},
+		{
+			name:     "over the cap streams through",
+			body:     []byte(longBody),
+			wantBody: longBody,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, rule)
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{"Content-Type": {"application/json"}},
+				Body:          io.NopCloser(bytes.NewReader(tt.body)),
+				ContentLength: -1,
+			}
+			if tt.encoding != "" {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			fired, err := e.RewriteResponse(httptest.NewRequest(http.MethodGet, "http://example.com/", nil), resp, testMaxBody)
+			if err != nil {
+				t.Fatalf("RewriteResponse: %v", err)
+			}
+			if (len(fired) > 0) != tt.wantFired {
+				t.Errorf("fired = %q, want fired %v", fired, tt.wantFired)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantFired && (resp.ContentLength != int64(len(tt.wantBody)) || resp.Header.Get("Content-Length") == "") {
+				t.Errorf("ContentLength = %d, Content-Length %q after a rewrite", resp.ContentLength, resp.Header.Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestRewriteScope(t *testing.T) {
+	base := Rule{Name: "r", Target: TargetRequestHeader, Action: ActionAddHeader, Header: "X-Hit", Value: "1"}
+	with := func(change func(r *Rule)) Rule {
+		rule := base
+		change(&rule)
+		return rule
+	}
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		method string
+		target string
+		want   bool
+	}{
+		{"no scope", base, http.MethodGet, "http://example.com/", true},
+		{"host matches", with(func(r *Rule) { r.Host = `^api\.example\.com$` }), http.MethodGet, "http://api.example.com:8080/", true},
+		{"host doesn't match", with(func(r *Rule) { r.Host = `^api\.example\.com$` }), http.MethodGet, "http://example.com/", false},
+		{"path matches", with(func(r *Rule) { r.Path = `^/admin` }), http.MethodGet, "http://example.com/admin/users", true},
+		{"path doesn't match", with(func(r *Rule) { r.Path = `^/admin` }), http.MethodGet, "http://example.com/user/admin", false},
+		{"method matches", with(func(r *Rule) { r.Method = `^(POST|PUT)$` }), http.MethodPut, "http://example.com/", true},
+		{"method doesn't match", with(func(r *Rule) { r.Method = `^(POST|PUT)$` }), http.MethodGet, "http://example.com/", false},
+		{"content type doesn't match", with(func(r *Rule) { r.ContentType = `json` }), http.MethodGet, "http://example.com/", false},
+		{"disabled", with(func(r *Rule) { r.Disabled = true }), http.MethodGet, "http://example.com/", false},
+		{"other target", with(func(r *Rule) { r.Target = TargetResponseHeader }), http.MethodGet, "http://example.com/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, tt.rule)
+			r := newTestRequest(tt.method, tt.target, "", nil)
+			fired, err := e.RewriteRequest(r, testMaxBody)
+			if err != nil {
+				t.Fatalf("RewriteRequest: %v", err)
+			}
+			if got := r.Header.Get("X-Hit") != ""; got != tt.want || (len(fired) > 0) != tt.want {
+				t.Errorf("rule applied = %v (fired %q), want %v", got, fired, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteRequestLine(t *testing.T) {
+	e := newTestEngine(t, Rule{Name: "debug", Target: TargetRequestLine, Match: "debug=0", Replace: "debug=1"})
+	r := newTestRequest(http.MethodGet, "http://example.com/app?debug=0", "", nil)
+	fired, err := e.RewriteRequest(r, testMaxBody)
+	if err != nil {
+		t.Fatalf("RewriteRequest: %v", err)
+	}
+	if !reflect.DeepEqual(fired, []string{"debug"}) || r.URL.RawQuery != "debug=1" || r.URL.Host != "example.com" {
+		t.Errorf("fired %q, url %s", fired, r.URL)
+	}
+}
+
+func TestCompileRuleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"unknown target", Rule{Target: "trailer", Match: "a"}},
+		{"unknown action", Rule{Target: TargetRequestBody, Action: "append", Match: "a"}},
+		{"replace without match", Rule{Target: TargetRequestBody}},
+		{"header action on a body", Rule{Target: TargetRequestBody, Action: ActionAddHeader, Header: "X-A"}},
+		{"header action without header", Rule{Target: TargetRequestHeader, Action: ActionRemoveHeader}},
+		{"bad match expression", Rule{Target: TargetRequestBody, Regex: true, Match: "("}},
+		{"bad header expression", Rule{Target: TargetRequestHeader, Action: ActionRemoveHeader, Regex: true, Header: "("}},
+		{"bad scope expression", Rule{Target: TargetRequestBody, Match: "a", Host: "("}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileRule(tt.rule); err == nil {
+				t.Error("compileRule accepted a bad rule")
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package rewrite
+
+const (
+	TargetRequestLine    = "request_line"
+	TargetRequestHeader  = "request_header"
+	TargetRequestBody    = "request_body"
+	TargetResponseHeader = "response_header"
+	TargetResponseBody   = "response_body"
+
+	ActionReplace      = "replace"
+	ActionAddHeader    = "add_header"
+	ActionRemoveHeader = "remove_header"
+)
+
+// Rule rewrites the part of the traffic named by Target.
+// The replace action substitutes Match with Replace, in header targets line by line ("Name: value"),
+// and a header line replaced with an empty string is removed.
+type Rule struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Disabled bool   `json:"disabled"`
+	Target   string `json:"target"`
+	Action   string `json:"action"`
+	Match    string `json:"match"`
+	Replace  string `json:"replace"`
+	// Match is a regular expression and Replace may refer to its groups as $1
+	Regex bool `json:"regex"`
+	// header added or removed by the header actions, removal matches whole names by Regex too
+	Header string `json:"header"`
+	Value  string `json:"value"`
+
+	// scope: regular expressions matched against the request's host, path and method
+	// and the content type of the rewritten message, empty ones match anything
+	Host        string `json:"host"`
+	Path        string `json:"path"`
+	Method      string `json:"method"`
+	ContentType string `json:"content_type"`
+}
@@ -0,0 +1,212 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ActiveResult is one response to a mutated request, as seen by the active checks.
+type ActiveResult struct {
+	Status  int
+	Headers http.Header
+	// decoded response body
+	Body     []byte
+	Duration time.Duration
+}
+
+type ActivePayload struct {
+	Value string
+	// Detect compares the result of the mutated request with the unmodified one
+	// and returns the evidence when the flaw shows up
+	Detect func(baseline, result *ActiveResult) (bool, string)
+	// time-based payloads are sent again before reporting, to rule out a slow network
+	Confirm bool
+}
+
+type ActiveCheck struct {
+	Name        string
+	Severity    string
+	Description string
+	// Payloads builds the values that replace the original value of an insertion point
+	Payloads func(original string) []ActivePayload
+}
+
+const (
+	activeSleepSeconds = 5
+	// a time-based payload counts when the response is this much slower than the baseline
+	activeSleepThreshold = activeSleepSeconds*time.Second - 500*time.Millisecond
+)
+
+var ActiveChecks = []ActiveCheck{
+	{
+		Name:        "sql_injection_error",
+		Severity:    SeverityHigh,
+		Description: "database error triggered by a quote in the parameter",
+		Payloads: func(original string) []ActivePayload {
+			return []ActivePayload{
+				{Value: original + "'", Detect: detectNewPattern(sqlErrorPatterns)},
+				{Value: original + "\"", Detect: detectNewPattern(sqlErrorPatterns)},
+				{Value: original + "')", Detect: detectNewPattern(sqlErrorPatterns)},
+			}
+		},
+	},
+	{
+		Name:        "sql_injection_time",
+		Severity:    SeverityHigh,
+		Description: "database sleep function delayed the response",
+		Payloads: func(original string) []ActivePayload {
+			sleep := strconv.Itoa(activeSleepSeconds)
+			values := []string{
+				original + "' AND SLEEP(" + sleep + ")-- -",
+				original + " AND SLEEP(" + sleep + ")",
+				original + "'||pg_sleep(" + sleep + ")--",
+				original + ";SELECT pg_sleep(" + sleep + ")--",
+				original + "';WAITFOR DELAY '0:0:" + sleep + "'--",
+			}
+			return timePayloads(values)
+		},
+	},
+	{
+		Name:        "os_command_injection_time",
+		Severity:    SeverityHigh,
+		Description: "injected sleep command delayed the response",
+		Payloads: func(original string) []ActivePayload {
+			sleep := strconv.Itoa(activeSleepSeconds)
+			values := []string{
+				original + ";sleep " + sleep + ";",
+				original + "|sleep " + sleep,
+				original + "&&sleep " + sleep,
+				original + "$(sleep " + sleep + ")",
+				original + "`sleep " + sleep + "`",
+			}
+			return timePayloads(values)
+		},
+	},
+	{
+		Name:        "os_command_injection_echo",
+		Severity:    SeverityHigh,
+		Description: "injected shell arithmetic was evaluated in the response",
+		Payloads: func(original string) []ActivePayload {
+			a, b := randomNumber(), randomNumber()
+			expr := "$((" + strconv.Itoa(a) + "+" + strconv.Itoa(b) + "))"
+			detect := detectNewPattern([]*regexp.Regexp{regexp.MustCompile(`\b` + strconv.Itoa(a+b) + `\b`)})
+			return []ActivePayload{
+				{Value: original + ";echo " + expr + ";", Detect: detect},
+				{Value: original + "|echo " + expr, Detect: detect},
+				{Value: original + "$(echo " + expr + ")", Detect: detect},
+				{Value: original + "`echo " + expr + "`", Detect: detect},
+			}
+		},
+	},
+	{
+		Name:        "reflected_xss",
+		Severity:    SeverityHigh,
+		Description: "injected markup is reflected without encoding",
+		Payloads: func(original string) []ActivePayload {
+			marker := "x" + strconv.Itoa(randomNumber())
+			values := []string{
+				original + "\"'><svg/onload=" + marker + ">",
+				original + "<script>" + marker + "</script>",
+				original + "\" autofocus onfocus=" + marker + " x=\"",
+			}
+			payloads := make([]ActivePayload, 0, len(values))
+			for _, value := range values {
+				payloads = append(payloads, ActivePayload{Value: value, Detect: detectReflection(value[len(original):])})
+			}
+			return payloads
+		},
+	},
+	{
+		Name:        "path_traversal",
+		Severity:    SeverityHigh,
+		Description: "a system file was read through the parameter",
+		Payloads: func(_ string) []ActivePayload {
+			detect := detectNewPattern(traversalPatterns)
+			values := []string{
+				"../../../../../../../../etc/passwd",
+				"..%2f..%2f..%2f..%2f..%2f..%2f..%2f..%2fetc%2fpasswd",
+				"....//....//....//....//....//....//etc/passwd",
+				"/etc/passwd",
+				"..\\..\\..\\..\\..\\..\\windows\\win.ini",
+				"C:\\windows\\win.ini",
+			}
+			payloads := make([]ActivePayload, 0, len(values))
+			for _, value := range values {
+				payloads = append(payloads, ActivePayload{Value: value, Detect: detect})
+			}
+			return payloads
+		},
+	},
+}
+
+var sqlErrorPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`You have an error in your SQL syntax`),
+	regexp.MustCompile(`(?i)warning: mysqli?_`),
+	regexp.MustCompile(`(?i)unclosed quotation mark after the character string`),
+	regexp.MustCompile(`(?i)quoted string not properly terminated`),
+	regexp.MustCompile(`ORA-\d{5}`),
+	regexp.MustCompile(`(?i)PSQLException|pg_query\(\)|unterminated quoted string at or near`),
+	regexp.MustCompile(`SQLSTATE\[`),
+	regexp.MustCompile(`(?i)SQLite3?::|sqlite3\.OperationalError|near ".*": syntax error`),
+	regexp.MustCompile(`Microsoft OLE DB Provider|ODBC SQL Server Driver`),
+}
+
+var traversalPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`root:[^:\n]*:0:0:`),
+	regexp.MustCompile(`(?i)\[fonts\][\s\S]*\[extensions\]|; for 16-bit app support`),
+}
+
+func timePayloads(values []string) []ActivePayload {
+	payloads := make([]ActivePayload, 0, len(values))
+	for _, value := range values {
+		payloads = append(payloads, ActivePayload{Value: value, Detect: detectDelay, Confirm: true})
+	}
+	return payloads
+}
+
+func detectDelay(baseline, result *ActiveResult) (bool, string) {
+	delay := result.Duration - baseline.Duration
+	if delay < activeSleepThreshold {
+		return false, ""
+	}
+	return true, "response delayed by " + delay.Round(time.Millisecond).String()
+}
+
+// detectNewPattern matches the patterns that are in the result but were not in the baseline
+func detectNewPattern(patterns []*regexp.Regexp) func(baseline, result *ActiveResult) (bool, string) {
+	return func(baseline, result *ActiveResult) (bool, string) {
+		for _, re := range patterns {
+			loc := re.FindIndex(result.Body)
+			if loc != nil && !re.Match(baseline.Body) {
+				return true, excerpt(result.Body, loc[0], loc[1])
+			}
+		}
+		return false, ""
+	}
+}
+
+func detectReflection(injected string) func(baseline, result *ActiveResult) (bool, string) {
+	return func(_, result *ActiveResult) (bool, string) {
+		if !isHTML(result.Headers) {
+			return false, ""
+		}
+		idx := bytes.Index(result.Body, []byte(injected))
+		if idx < 0 {
+			return false, ""
+		}
+		return true, excerpt(result.Body, idx, idx+len(injected))
+	}
+}
+
+func randomNumber() int {
+	n, err := rand.Int(rand.Reader, big.NewInt(90000))
+	if err != nil {
+		return 48611
+	}
+	return int(n.Int64()) + 10000
+}
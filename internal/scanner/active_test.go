@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func activeCheck(t *testing.T, name string) ActiveCheck {
+	t.Helper()
+	for _, check := range ActiveChecks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatalf("no active check %s", name)
+	return ActiveCheck{}
+}
+
+var shellSum = regexp.MustCompile(`\$\(\((\d+)\+(\d+)\)\)`)
+
+// evaluatedEcho answers the way a shell would to the echo payloads of os_command_injection_echo
+func evaluatedEcho(value string) string {
+	m := shellSum.FindStringSubmatch(value)
+	if m == nil {
+		return value
+	}
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[2])
+	return "result " + strconv.Itoa(a+b)
+}
+
+func TestActiveChecksDetect(t *testing.T) {
+	html := http.Header{"Content-Type": {"text/html"}}
+	plain := http.Header{"Content-Type": {"text/plain"}}
+
+	tests := []struct {
+		name     string
+		check    string
+		baseline ActiveResult
+		// respond builds the result of a payload
+		respond func(payload string) ActiveResult
+		want    bool
+	}{
+		{
+			name:     "sql error",
+			check:    "sql_injection_error",
+			baseline: ActiveResult{Body: []byte("item 7")},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Body: []byte("ERROR: unterminated quoted string at or near \"'\"")}
+			},
+			want: true,
+		},
+		{
+			name:     "sql error already in the baseline",
+			check:    "sql_injection_error",
+			baseline: ActiveResult{Body: []byte("ORA-00933: SQL command not properly ended")},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Body: []byte("ORA-00933: SQL command not properly ended")}
+			},
+		},
+		{
+			name:     "reflected markup",
+			check:    "reflected_xss",
+			baseline: ActiveResult{Headers: html, Body: []byte("<p>shoes</p>")},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Headers: html, Body: []byte("<p>" + payload + "</p>")}
+			},
+			want: true,
+		},
+		{
+			name:     "reflected markup in plain text",
+			check:    "reflected_xss",
+			baseline: ActiveResult{Headers: plain, Body: []byte("shoes")},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Headers: plain, Body: []byte(payload)}
+			},
+		},
+		{
+			name:     "evaluated shell arithmetic",
+			check:    "os_command_injection_echo",
+			baseline: ActiveResult{Body: []byte("pong")},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Body: []byte(evaluatedEcho(payload))}
+			},
+			want: true,
+		},
+		{
+			name:     "echoed shell arithmetic",
+			check:    "os_command_injection_echo",
+			baseline: ActiveResult{Body: []byte("pong")},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Body: []byte(payload)}
+			},
+		},
+		{
+			name:     "passwd read",
+			check:    "path_traversal",
+			baseline: ActiveResult{Body: []byte("file not found")},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Body: []byte("root:x:0:0:root:/root:/bin/bash\n")}
+			},
+			want: true,
+		},
+		{
+			name:     "sleep",
+			check:    "sql_injection_time",
+			baseline: ActiveResult{Duration: 100 * time.Millisecond},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Duration: 100*time.Millisecond + activeSleepSeconds*time.Second}
+			},
+			want: true,
+		},
+		{
+			name:     "slow but not slept",
+			check:    "os_command_injection_time",
+			baseline: ActiveResult{Duration: 100 * time.Millisecond},
+			respond: func(payload string) ActiveResult {
+				return ActiveResult{Duration: 2 * time.Second}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads := activeCheck(t, tt.check).Payloads("shoes")
+			if len(payloads) == 0 {
+				t.Fatal("no payloads")
+			}
+			for _, payload := range payloads {
+				result := tt.respond(payload.Value)
+				detected, evidence := payload.Detect(&tt.baseline, &result)
+				if detected != tt.want {
+					t.Errorf("payload %q detected = %v, want %v", payload.Value, detected, tt.want)
+				}
+				if detected && evidence == "" {
+					t.Errorf("payload %q detected without evidence", payload.Value)
+				}
+				if payload.Confirm != (tt.check == "sql_injection_time" || tt.check == "os_command_injection_time") {
+					t.Errorf("payload %q confirm = %v", payload.Value, payload.Confirm)
+				}
+			}
+		})
+	}
+}
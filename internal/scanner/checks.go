@@ -0,0 +1,233 @@
+package scanner
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type passiveFinding struct {
+	Finding
+	// reported once per host instead of once per request
+	hostWide bool
+}
+
+type passiveCheck func(exchange *Exchange) []passiveFinding
+
+var passiveChecks = []passiveCheck{
+	checkSecurityHeaders,
+	checkCookieFlags,
+	checkReflectedParams,
+	checkVerboseErrors,
+	checkLeakedSecrets,
+	checkMixedContent,
+}
+
+// reflected values shorter than this match by accident too often
+const minReflectedLength = 4
+
+// evidence is cut to this many bytes around the match
+const evidenceContext = 60
+
+func checkSecurityHeaders(exchange *Exchange) []passiveFinding {
+	if !isHTML(exchange.ResponseHeaders) {
+		return nil
+	}
+	required := map[string]string{
+		"Content-Security-Policy": SeverityLow,
+		"X-Content-Type-Options":  SeverityLow,
+		"X-Frame-Options":         SeverityLow,
+	}
+	if exchange.IsHTTPS {
+		required["Strict-Transport-Security"] = SeverityLow
+	}
+
+	findings := make([]passiveFinding, 0)
+	for header, severity := range required {
+		if exchange.ResponseHeaders.Get(header) != "" {
+			continue
+		}
+		// frame-ancestors in the CSP makes X-Frame-Options redundant
+		if header == "X-Frame-Options" && strings.Contains(exchange.ResponseHeaders.Get("Content-Security-Policy"), "frame-ancestors") {
+			continue
+		}
+		findings = append(findings, passiveFinding{
+			Finding: Finding{
+				Check:       "missing_security_header",
+				Severity:    severity,
+				Description: "missing " + header + " header",
+				Evidence:    exchange.Path,
+			},
+			hostWide: true,
+		})
+	}
+	return findings
+}
+
+func checkCookieFlags(exchange *Exchange) []passiveFinding {
+	resp := http.Response{Header: exchange.ResponseHeaders}
+	findings := make([]passiveFinding, 0)
+	for _, cookie := range resp.Cookies() {
+		var missing []string
+		if exchange.IsHTTPS && !cookie.Secure {
+			missing = append(missing, "Secure")
+		}
+		if !cookie.HttpOnly {
+			missing = append(missing, "HttpOnly")
+		}
+		// a bare "SameSite" attribute is parsed as the default mode and means as little as none at all
+		if cookie.SameSite == 0 || cookie.SameSite == http.SameSiteDefaultMode {
+			missing = append(missing, "SameSite")
+		}
+		for _, flag := range missing {
+			severity := SeverityLow
+			if flag == "Secure" {
+				severity = SeverityMedium
+			}
+			findings = append(findings, passiveFinding{
+				Finding: Finding{
+					Check:       "cookie_flags",
+					Severity:    severity,
+					Description: "cookie " + cookie.Name + " without " + flag + " flag",
+					Evidence:    cookie.Raw,
+				},
+				hostWide: true,
+			})
+		}
+	}
+	return findings
+}
+
+func checkReflectedParams(exchange *Exchange) []passiveFinding {
+	if !isHTML(exchange.ResponseHeaders) {
+		return nil
+	}
+	findings := make([]passiveFinding, 0)
+	for name, values := range exchange.Params {
+		for _, value := range values {
+			if len(value) < minReflectedLength {
+				continue
+			}
+			idx := bytes.Index(exchange.ResponseBody, []byte(value))
+			if idx < 0 {
+				continue
+			}
+			findings = append(findings, passiveFinding{
+				Finding: Finding{
+					Check:       "reflected_parameter",
+					Severity:    SeverityMedium,
+					Description: "value of parameter " + name + " is reflected in the response",
+					Evidence:    excerpt(exchange.ResponseBody, idx, idx+len(value)),
+				},
+			})
+			break
+		}
+	}
+	return findings
+}
+
+var verboseErrorPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`Traceback \(most recent call last\)`),
+	regexp.MustCompile(`Exception in thread "`),
+	regexp.MustCompile(`at [a-zA-Z0-9_.$]+\([A-Za-z0-9_]+\.java:\d+\)`),
+	regexp.MustCompile(`System\.[A-Za-z]+Exception`),
+	regexp.MustCompile(`(?i)<b>(fatal error|warning|parse error)</b>:`),
+	regexp.MustCompile(`goroutine \d+ \[running\]`),
+	regexp.MustCompile(`You have an error in your SQL syntax`),
+	regexp.MustCompile(`ORA-\d{5}`),
+	regexp.MustCompile(`(?i)pg_query\(\)|PSQLException|SQLSTATE\[`),
+	regexp.MustCompile(`Microsoft OLE DB Provider`),
+	regexp.MustCompile(`(?i)unclosed quotation mark after the character string`),
+}
+
+func checkVerboseErrors(exchange *Exchange) []passiveFinding {
+	findings := make([]passiveFinding, 0)
+	for _, re := range verboseErrorPatterns {
+		loc := re.FindIndex(exchange.ResponseBody)
+		if loc == nil {
+			continue
+		}
+		findings = append(findings, passiveFinding{
+			Finding: Finding{
+				Check:       "verbose_error",
+				Severity:    SeverityMedium,
+				Description: "response contains an error message or stack trace",
+				Evidence:    excerpt(exchange.ResponseBody, loc[0], loc[1]),
+			},
+		})
+		break
+	}
+	return findings
+}
+
+var secretPatterns = []struct {
+	name     string
+	severity string
+	re       *regexp.Regexp
+}{
+	{"private key", SeverityHigh, regexp.MustCompile(`-----BEGIN (RSA |EC |DSA |OPENSSH |ENCRYPTED )?PRIVATE KEY-----`)},
+	{"AWS access key", SeverityHigh, regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"Google API key", SeverityMedium, regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{"GitHub token", SeverityHigh, regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"Slack token", SeverityHigh, regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}`)},
+	{"JSON web token", SeverityMedium, regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{10,}\.eyJ[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]+`)},
+}
+
+func checkLeakedSecrets(exchange *Exchange) []passiveFinding {
+	findings := make([]passiveFinding, 0)
+	for _, secret := range secretPatterns {
+		loc := secret.re.FindIndex(exchange.ResponseBody)
+		if loc == nil {
+			continue
+		}
+		findings = append(findings, passiveFinding{
+			Finding: Finding{
+				Check:       "leaked_secret",
+				Severity:    secret.severity,
+				Description: secret.name + " in response body",
+				Evidence:    excerpt(exchange.ResponseBody, loc[0], loc[1]),
+			},
+		})
+	}
+	return findings
+}
+
+var mixedContentPattern = regexp.MustCompile(`(?i)<(script|iframe|link|img|audio|video|source|embed|object|form)\b[^>]*\b(src|href|action|data)\s*=\s*["']?http://`)
+
+func checkMixedContent(exchange *Exchange) []passiveFinding {
+	if !exchange.IsHTTPS || !isHTML(exchange.ResponseHeaders) {
+		return nil
+	}
+	loc := mixedContentPattern.FindIndex(exchange.ResponseBody)
+	if loc == nil {
+		return nil
+	}
+	return []passiveFinding{{
+		Finding: Finding{
+			Check:       "mixed_content",
+			Severity:    SeverityMedium,
+			Description: "https page loads resources over plain http",
+			Evidence:    excerpt(exchange.ResponseBody, loc[0], loc[1]),
+		},
+	}}
+}
+
+func isHTML(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// excerpt returns body[start:end] with a bit of surrounding context
+func excerpt(body []byte, start, end int) string {
+	from := start - evidenceContext
+	if from < 0 {
+		from = 0
+	}
+	to := end + evidenceContext
+	if to > len(body) {
+		to = len(body)
+	}
+	return strings.ToValidUTF8(string(body[from:to]), "")
+}
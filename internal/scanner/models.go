@@ -0,0 +1,39 @@
+package scanner
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	SeverityInfo   = "info"
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+type Finding struct {
+	ID          int64  `json:"id"`
+	RequestID   int64  `json:"request_id"`
+	Check       string `json:"check"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	Evidence    string `json:"evidence"`
+	// set for findings of an active scan job
+	ScanJobID *int64    `json:"scan_job_id,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// Exchange is a stored request/response pair as seen by the checks.
+type Exchange struct {
+	RequestID int64
+	IsHTTPS   bool
+	Host      string
+	Path      string
+	// get and post parameters of the request
+	Params          map[string][]string
+	ResponseCode    int
+	ResponseHeaders http.Header
+	// decoded response body
+	ResponseBody []byte
+}
@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"container/list"
+	"sync"
+
+	servLog "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
+	"github.com/pkg/errors"
+)
+
+const (
+	passiveQueueSize      = 1024
+	defaultPassiveWorkers = 2
+	// host-wide findings remembered as reported, the least recently seen ones are forgotten first
+	maxReportedFindings = 10000
+)
+
+// PassiveScanner runs the passive checks on stored exchanges in the background.
+type PassiveScanner struct {
+	repo   *ScannerRepository
+	logger *servLog.ServLogger
+	queue  chan *Exchange
+
+	// host-wide findings (missing headers, cookie flags) are reported once per host
+	reported *reportedSet
+}
+
+func NewPassiveScanner(repo *ScannerRepository, logger *servLog.ServLogger) *PassiveScanner {
+	return &PassiveScanner{
+		repo:   repo,
+		logger: logger,
+		queue:  make(chan *Exchange, passiveQueueSize),
+		reported: &reportedSet{
+			size: maxReportedFindings,
+			lru:  list.New(),
+			keys: map[string]*list.Element{},
+		},
+	}
+}
+
+// Start launches the scanning workers.
+func (s *PassiveScanner) Start(workers int) {
+	if workers <= 0 {
+		workers = defaultPassiveWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for exchange := range s.queue {
+				s.scan(exchange)
+			}
+		}()
+	}
+}
+
+// Submit queues the exchange for scanning. It never blocks the proxy: when the queue is full the exchange is skipped.
+func (s *PassiveScanner) Submit(exchange *Exchange) {
+	select {
+	case s.queue <- exchange:
+	default:
+		s.logger.Warn(0, "passive scanner queue is full, skipping request")
+	}
+}
+
+func (s *PassiveScanner) scan(exchange *Exchange) {
+	for _, finding := range s.findings(exchange) {
+		if err := s.repo.InsertFinding(&finding); err != nil {
+			s.logger.Error(0, errors.Wrap(err, "passive scanner").Error())
+		}
+	}
+}
+
+// findings runs the passive checks on exchange, leaving out the host-wide findings reported already
+func (s *PassiveScanner) findings(exchange *Exchange) []Finding {
+	res := make([]Finding, 0)
+	for _, check := range passiveChecks {
+		for _, finding := range check(exchange) {
+			if finding.hostWide && s.reported.add(exchange.Host+"|"+finding.Check+"|"+finding.Description) {
+				continue
+			}
+			finding.RequestID = exchange.RequestID
+			res = append(res, finding.Finding)
+		}
+	}
+	return res
+}
+
+// reportedSet remembers the last size host-wide findings reported
+type reportedSet struct {
+	mu   sync.Mutex
+	size int
+	lru  *list.List
+	keys map[string]*list.Element
+}
+
+// add remembers key, it reports whether key was remembered already
+func (r *reportedSet) add(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.keys[key]; ok {
+		r.lru.MoveToFront(elem)
+		return true
+	}
+	r.keys[key] = r.lru.PushFront(key)
+	for r.lru.Len() > r.size {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.keys, oldest.Value.(string))
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// exchangeFindings returns the sorted descriptions of the findings s reports for exchange
+func exchangeFindings(t *testing.T, s *PassiveScanner, exchange *Exchange) []string {
+	t.Helper()
+	res := make([]string, 0)
+	for _, finding := range s.findings(exchange) {
+		if finding.RequestID != exchange.RequestID {
+			t.Errorf("%q is attached to request %d, want %d", finding.Description, finding.RequestID, exchange.RequestID)
+		}
+		res = append(res, finding.Description)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestPassiveFindingsOncePerHost(t *testing.T) {
+	s := NewPassiveScanner(nil, nil)
+	exchange := func(id int64, host string) *Exchange {
+		return &Exchange{
+			RequestID: id,
+			Host:      host,
+			Path:      "/",
+			ResponseHeaders: http.Header{
+				"Content-Type":            {"text/html"},
+				"Content-Security-Policy": {"frame-ancestors 'none'"},
+				"X-Content-Type-Options":  {"nosniff"},
+				"Set-Cookie":              {"session=1; SameSite=Lax"},
+			},
+			ResponseBody: []byte("You have an error in your SQL syntax"),
+		}
+	}
+	hostWide := "cookie session without HttpOnly flag"
+	perRequest := "response contains an error message or stack trace"
+
+	tests := []struct {
+		exchange *Exchange
+		want     []string
+	}{
+		{exchange(1, "a.example.com"), []string{hostWide, perRequest}},
+		{exchange(2, "a.example.com"), []string{perRequest}},
+		{exchange(3, "b.example.com"), []string{hostWide, perRequest}},
+		{exchange(4, "a.example.com"), []string{perRequest}},
+	}
+	for _, tt := range tests {
+		if got := exchangeFindings(t, s, tt.exchange); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("request %d to %s: findings = %q, want %q", tt.exchange.RequestID, tt.exchange.Host, got, tt.want)
+		}
+	}
+}
+
+func TestReportedSetEviction(t *testing.T) {
+	s := NewPassiveScanner(nil, nil)
+	s.reported.size = 2
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"a", false},
+		{"b", false},
+		{"a", true},
+		// c pushes out b, the least recently seen
+		{"c", false},
+		{"a", true},
+		{"b", false},
+		{"c", false},
+	}
+	for i, tt := range tests {
+		if got := s.reported.add(tt.key); got != tt.want {
+			t.Errorf("step %d: add(%q) = %v, want %v", i, tt.key, got, tt.want)
+		}
+	}
+	if len(s.reported.keys) != 2 || s.reported.lru.Len() != 2 {
+		t.Errorf("%d keys remembered, want 2", len(s.reported.keys))
+	}
+}
+
+func TestReportedSetIsBounded(t *testing.T) {
+	s := NewPassiveScanner(nil, nil)
+	for i := 0; i < maxReportedFindings+100; i++ {
+		s.reported.add(fmt.Sprintf("host%d|cookie_flags|x", i))
+	}
+	if len(s.reported.keys) != maxReportedFindings {
+		t.Errorf("%d findings remembered, want %d", len(s.reported.keys), maxReportedFindings)
+	}
+}
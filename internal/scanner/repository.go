@@ -0,0 +1,69 @@
+package scanner
+
+import (
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+type ScannerRepository struct {
+	conn *pgx.ConnPool
+}
+
+const (
+	insertFindingQuery = `INSERT INTO findings(request_id, check_name, severity, description, evidence, scan_job_id) VALUES($1, $2, $3, $4, $5, $6);`
+	getFindingsQuery   = `SELECT id, request_id, check_name, severity, description, evidence, scan_job_id, created from findings
+		WHERE ($1::bigint = 0 OR request_id = $1) AND ($2::text = '' OR severity = $2) ORDER BY id;`
+	getScanJobFindingsQuery = `SELECT id, request_id, check_name, severity, description, evidence, scan_job_id, created from findings
+		WHERE scan_job_id = $1 ORDER BY id;`
+)
+
+func NewScannerRepository(conn *pgx.ConnPool) *ScannerRepository {
+	return &ScannerRepository{
+		conn: conn,
+	}
+}
+
+func (p *ScannerRepository) InsertFinding(finding *Finding) error {
+	res, err := p.conn.Exec(insertFindingQuery, finding.RequestID, finding.Check, finding.Severity, finding.Description, finding.Evidence, finding.ScanJobID)
+	if err != nil {
+		return errors.Wrap(err, "inserting finding error")
+	}
+	if res.RowsAffected() != 1 {
+		return errors.New("inserting finding error")
+	}
+	return nil
+}
+
+// GetFindings returns findings filtered by request id and severity, zero values disable a filter
+func (p *ScannerRepository) GetFindings(reqID int64, severity string) ([]Finding, error) {
+	rows, err := p.conn.Query(getFindingsQuery, reqID, severity)
+	if err != nil {
+		return nil, err
+	}
+	return scanFindings(rows)
+}
+
+func (p *ScannerRepository) GetScanJobFindings(jobID int64) ([]Finding, error) {
+	rows, err := p.conn.Query(getScanJobFindingsQuery, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFindings(rows)
+}
+
+func scanFindings(rows *pgx.Rows) ([]Finding, error) {
+	defer rows.Close()
+
+	res := make([]Finding, 0)
+
+	for rows.Next() {
+		finding := Finding{}
+		err := rows.Scan(&finding.ID, &finding.RequestID, &finding.Check, &finding.Severity, &finding.Description, &finding.Evidence, &finding.ScanJobID, &finding.Created)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, finding)
+	}
+
+	return res, rows.Err()
+}
@@ -0,0 +1,26 @@
+package scope
+
+const (
+	// out-of-scope traffic is proxied but not stored
+	OutOfScopeSkip = "skip"
+	// out-of-scope traffic is stored with the out_of_scope flag
+	OutOfScopeFlag = "flag"
+)
+
+// Rule matches a url, empty fields match anything.
+type Rule struct {
+	Scheme string `json:"scheme"`
+	// glob, e.g. *.example.com
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// regular expression
+	Path string `json:"path"`
+}
+
+type Settings struct {
+	// everything is in scope when there are no include rules
+	Include []Rule `json:"include"`
+	Exclude []Rule `json:"exclude"`
+	// what happens to out-of-scope traffic: skip or flag
+	OutOfScope string `json:"out_of_scope"`
+}
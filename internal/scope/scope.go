@@ -0,0 +1,159 @@
+package scope
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/pkg/errors"
+)
+
+type compiledRule struct {
+	Rule
+	path *regexp.Regexp
+}
+
+// Scope decides which traffic is recorded, intercepted and decrypted.
+type Scope struct {
+	mu       sync.RWMutex
+	settings Settings
+	include  []compiledRule
+	exclude  []compiledRule
+}
+
+func NewScope(conf *config.ScopeConfig) (*Scope, error) {
+	settings := Settings{
+		Include:    make([]Rule, 0, len(conf.Include)),
+		Exclude:    make([]Rule, 0, len(conf.Exclude)),
+		OutOfScope: conf.OutOfScope,
+	}
+	for _, rule := range conf.Include {
+		settings.Include = append(settings.Include, Rule{Scheme: rule.Scheme, Host: rule.Host, Port: rule.Port, Path: rule.Path})
+	}
+	for _, rule := range conf.Exclude {
+		settings.Exclude = append(settings.Exclude, Rule{Scheme: rule.Scheme, Host: rule.Host, Port: rule.Port, Path: rule.Path})
+	}
+	s := &Scope{}
+	if err := s.SetSettings(settings); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Scope) Settings() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings
+}
+
+func (s *Scope) SetSettings(settings Settings) error {
+	switch settings.OutOfScope {
+	case "":
+		settings.OutOfScope = OutOfScopeSkip
+	case OutOfScopeSkip, OutOfScopeFlag:
+	default:
+		return errors.New("unknown out-of-scope mode " + settings.OutOfScope)
+	}
+	if settings.Include == nil {
+		settings.Include = []Rule{}
+	}
+	if settings.Exclude == nil {
+		settings.Exclude = []Rule{}
+	}
+	include, err := compileRules(settings.Include)
+	if err != nil {
+		return err
+	}
+	exclude, err := compileRules(settings.Exclude)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = settings
+	s.include = include
+	s.exclude = exclude
+	return nil
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	res := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.Scheme = strings.ToLower(rule.Scheme)
+		rule.Host = strings.ToLower(rule.Host)
+		if _, err := path.Match(rule.Host, ""); err != nil {
+			return nil, errors.Wrap(err, "bad host glob "+rule.Host)
+		}
+		re, err := regexp.Compile(rule.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad path expression")
+		}
+		res = append(res, compiledRule{Rule: rule, path: re})
+	}
+	return res, nil
+}
+
+// RecordOutOfScope reports whether out-of-scope traffic is stored with a flag rather than skipped.
+func (s *Scope) RecordOutOfScope() bool {
+	return s.Settings().OutOfScope == OutOfScopeFlag
+}
+
+// InScope reports whether the url is included and not excluded.
+func (s *Scope) InScope(scheme, host string, port int, urlPath string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	scheme, host = strings.ToLower(scheme), strings.ToLower(host)
+	for _, rule := range s.exclude {
+		if rule.matchOrigin(scheme, host, port) && rule.path.MatchString(urlPath) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, rule := range s.include {
+		if rule.matchOrigin(scheme, host, port) && rule.path.MatchString(urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldDecrypt reports whether a CONNECT tunnel to host and port may carry in-scope requests.
+// The paths are unknown at that point, so only exclude rules without a path rule a tunnel out.
+func (s *Scope) ShouldDecrypt(host string, port int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	host = strings.ToLower(host)
+	for _, rule := range s.exclude {
+		if rule.Path == "" && rule.matchOrigin("https", host, port) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, rule := range s.include {
+		if rule.matchOrigin("https", host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *compiledRule) matchOrigin(scheme, host string, port int) bool {
+	if rule.Scheme != "" && rule.Scheme != scheme {
+		return false
+	}
+	if rule.Port != 0 && rule.Port != port {
+		return false
+	}
+	if rule.Host == "" {
+		return true
+	}
+	matched, _ := path.Match(rule.Host, host)
+	return matched
+}
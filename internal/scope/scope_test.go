@@ -0,0 +1,144 @@
+package scope
+
+import (
+	"testing"
+)
+
+func newTestScope(t *testing.T, include, exclude []Rule) *Scope {
+	t.Helper()
+	s := &Scope{}
+	if err := s.SetSettings(Settings{Include: include, Exclude: exclude}); err != nil {
+		t.Fatalf("SetSettings: %v", err)
+	}
+	return s
+}
+
+func TestInScope(t *testing.T) {
+	type target struct {
+		scheme string
+		host   string
+		port   int
+		path   string
+		want   bool
+	}
+	tests := []struct {
+		name    string
+		include []Rule
+		exclude []Rule
+		targets []target
+	}{
+		{
+			name: "empty scope takes everything",
+			targets: []target{
+				{"http", "example.com", 80, "/", true},
+				{"https", "anything.test", 8443, "/a/b", true},
+			},
+		},
+		{
+			name:    "host glob",
+			include: []Rule{{Host: "*.example.com"}},
+			targets: []target{
+				{"https", "www.example.com", 443, "/", true},
+				{"https", "a.b.example.com", 443, "/", true},
+				{"https", "WWW.Example.COM", 443, "/", true},
+				{"https", "example.com", 443, "/", false},
+				{"https", "www.example.com.evil.test", 443, "/", false},
+			},
+		},
+		{
+			name:    "glob classes and single characters",
+			include: []Rule{{Host: "api-[0-9].example.?om"}},
+			targets: []target{
+				{"https", "api-1.example.com", 443, "/", true},
+				{"https", "api-x.example.com", 443, "/", false},
+				{"https", "api-12.example.com", 443, "/", false},
+			},
+		},
+		{
+			name:    "scheme, port and path",
+			include: []Rule{{Scheme: "HTTPS", Host: "example.com", Port: 8443, Path: "^/api/"}},
+			targets: []target{
+				{"https", "example.com", 8443, "/api/users", true},
+				{"http", "example.com", 8443, "/api/users", false},
+				{"https", "example.com", 443, "/api/users", false},
+				{"https", "example.com", 8443, "/static/app.js", false},
+			},
+		},
+		{
+			name:    "exclude wins over include",
+			include: []Rule{{Host: "*.example.com"}},
+			exclude: []Rule{{Host: "static.example.com"}, {Path: `\.(png|css)$`}},
+			targets: []target{
+				{"https", "www.example.com", 443, "/index.html", true},
+				{"https", "static.example.com", 443, "/index.html", false},
+				{"https", "www.example.com", 443, "/logo.png", false},
+			},
+		},
+		{
+			name:    "exclude alone",
+			exclude: []Rule{{Host: "*.mozilla.com"}},
+			targets: []target{
+				{"https", "example.com", 443, "/", true},
+				{"https", "push.services.mozilla.com", 443, "/", false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScope(t, tt.include, tt.exclude)
+			for _, target := range tt.targets {
+				if got := s.InScope(target.scheme, target.host, target.port, target.path); got != target.want {
+					t.Errorf("InScope(%s://%s:%d%s) = %v, want %v",
+						target.scheme, target.host, target.port, target.path, got, target.want)
+				}
+			}
+		})
+	}
+}
+
+func TestShouldDecrypt(t *testing.T) {
+	s := newTestScope(t,
+		[]Rule{{Host: "*.example.com"}, {Scheme: "http", Host: "plain.test"}},
+		[]Rule{{Host: "pinned.example.com"}, {Host: "www.example.com", Path: "^/private"}})
+
+	tests := []struct {
+		host string
+		port int
+		want bool
+	}{
+		{"api.example.com", 443, true},
+		// a path rule can't be decided for a whole tunnel
+		{"www.example.com", 443, true},
+		{"pinned.example.com", 443, false},
+		{"other.test", 443, false},
+		// tunnels are https
+		{"plain.test", 443, false},
+	}
+	for _, tt := range tests {
+		if got := s.ShouldDecrypt(tt.host, tt.port); got != tt.want {
+			t.Errorf("ShouldDecrypt(%s:%d) = %v, want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestSetSettingsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+	}{
+		{"bad host glob", Settings{Include: []Rule{{Host: "[a-"}}}},
+		{"bad path expression", Settings{Exclude: []Rule{{Path: "("}}}},
+		{"unknown out-of-scope mode", Settings{OutOfScope: "drop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScope(t, nil, []Rule{{Host: "kept.test"}})
+			if err := s.SetSettings(tt.settings); err == nil {
+				t.Fatal("SetSettings accepted bad settings")
+			}
+			if s.InScope("https", "kept.test", 443, "/") {
+				t.Error("bad settings replaced the previous ones")
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package bodydecoder
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html/charset"
+)
+
+// decompressed bodies are cut at this size, so that a tiny compressed payload can't exhaust memory
+const maxDecodedSize = 32 << 20
+
+// Decode undoes the Content-Encoding of body and converts textual content to UTF-8.
+// It returns the decoded body and the detected charset, which is empty for non-textual content.
+// A body cut off in the middle of a compressed stream is decoded as far as possible.
+func Decode(body []byte, header http.Header) (decoded []byte, charsetName string, err error) {
+	decoded, err = Decompress(body, header)
+	if err != nil {
+		return body, "", err
+	}
+
+	contentType := header.Get("Content-Type")
+	if !isText(contentType) {
+		return decoded, "", nil
+	}
+	enc, charsetName, _ := charset.DetermineEncoding(decoded, contentType)
+	utf8Body, err := enc.NewDecoder().Bytes(decoded)
+	if err != nil {
+		return decoded, charsetName, errors.Wrap(err, "charset decoding error")
+	}
+	return utf8Body, charsetName, nil
+}
+
+// Decompress only undoes the Content-Encoding of body.
+func Decompress(body []byte, header http.Header) ([]byte, error) {
+	encodings := header.Values("Content-Encoding")
+	codings := make([]string, 0, len(encodings))
+	for _, value := range encodings {
+		for _, coding := range strings.Split(value, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	decoded := body
+	var err error
+	// codings are listed in the order they were applied
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err = decompress(decoded, codings[i])
+		if err != nil {
+			return body, err
+		}
+	}
+	return decoded, nil
+}
+
+func decompress(body []byte, coding string) ([]byte, error) {
+	var reader io.Reader
+	var err error
+	switch coding {
+	case "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw deflate
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, errors.New("unsupported content-encoding " + coding)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, coding+" decoding error")
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedSize))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errors.Wrap(err, coding+" decoding error")
+	}
+	return decoded, nil
+}
+
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, suffix := range []string{"json", "xml", "javascript", "x-www-form-urlencoded"} {
+		if strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+	return false
+}
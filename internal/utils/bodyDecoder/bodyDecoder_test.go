@@ -0,0 +1,194 @@
+package bodydecoder
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encode applies the codings to body in order
+func encode(t *testing.T, body []byte, codings ...string) []byte {
+	t.Helper()
+	for _, coding := range codings {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch coding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "zlib":
+			w = zlib.NewWriter(&buf)
+		case "flate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		default:
+			t.Fatalf("no encoder for %s", coding)
+		}
+		if _, err := w.Write(body); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		body = buf.Bytes()
+	}
+	return body
+}
+
+func TestDecompressRoundTrip(t *testing.T) {
+	body := []byte(strings.Repeat("<p>привет, мир</p>\n", 100))
+
+	tests := []struct {
+		name string
+		// encoders applied to the body, in order
+		codings []string
+		// Content-Encoding header values
+		header []string
+	}{
+		{"no encoding", nil, nil},
+		{"identity", nil, []string{"identity"}},
+		{"gzip", []string{"gzip"}, []string{"gzip"}},
+		{"x-gzip", []string{"gzip"}, []string{"x-gzip"}},
+		{"upper case", []string{"gzip"}, []string{"GZIP"}},
+		{"zlib deflate", []string{"zlib"}, []string{"deflate"}},
+		{"raw deflate", []string{"flate"}, []string{"deflate"}},
+		{"brotli", []string{"br"}, []string{"br"}},
+		{"stacked in one header", []string{"gzip", "br"}, []string{"gzip, br"}},
+		{"stacked in two headers", []string{"br", "zlib"}, []string{"br", "deflate"}},
+		{"stacked with identity", []string{"gzip", "gzip"}, []string{"gzip,identity , gzip"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Content-Encoding": tt.header}
+			got, err := Decompress(encode(t, body, tt.codings...), header)
+			if err != nil {
+				t.Fatalf("Decompress: %v", err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("Decompress() returned %d bytes, want the original %d", len(got), len(body))
+			}
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	body := []byte("hello")
+	tests := []struct {
+		name   string
+		body   []byte
+		header []string
+	}{
+		{"unknown encoding", body, []string{"zstd"}},
+		{"unknown encoding under a known one", encode(t, body, "gzip"), []string{"zstd, gzip"}},
+		{"not gzip", body, []string{"gzip"}},
+		{"not deflate", body, []string{"deflate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decompress(tt.body, http.Header{"Content-Encoding": tt.header})
+			if err == nil {
+				t.Fatal("Decompress accepted a body it can't decode")
+			}
+			if !bytes.Equal(got, tt.body) {
+				t.Errorf("Decompress() = %q on error, want the body as is", got)
+			}
+			if decoded, charset, err := Decode(tt.body, http.Header{"Content-Encoding": tt.header}); err == nil || !bytes.Equal(decoded, tt.body) || charset != "" {
+				t.Errorf("Decode() = %q, %q, %v, want the body as is and an error", decoded, charset, err)
+			}
+		})
+	}
+}
+
+func TestDecompressTruncated(t *testing.T) {
+	body := []byte(strings.Repeat("0123456789", 10000))
+	tests := []struct {
+		encoder string
+		coding  string
+	}{
+		{"gzip", "gzip"},
+		{"zlib", "deflate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.coding, func(t *testing.T) {
+			encoded := encode(t, body, tt.encoder)
+			got, err := Decompress(encoded[:len(encoded)/2], http.Header{"Content-Encoding": {tt.coding}})
+			if err != nil {
+				t.Fatalf("Decompress: %v", err)
+			}
+			if len(got) == 0 || !bytes.HasPrefix(body, got) {
+				t.Errorf("decoded %d bytes of a cut stream, want a prefix of the body", len(got))
+			}
+		})
+	}
+}
+
+func TestDecompressSizeCap(t *testing.T) {
+	// a few kilobytes inflating past the cap
+	bomb := encode(t, make([]byte, maxDecodedSize+1<<20), "gzip")
+	got, err := Decompress(bomb, http.Header{"Content-Encoding": {"gzip"}})
+	if err != nil {
+		t.Fatalf("Decompress: %v", err)
+	}
+	if len(got) != maxDecodedSize {
+		t.Errorf("decoded %d bytes, want the cap of %d", len(got), maxDecodedSize)
+	}
+}
+
+func TestDecodeCharset(t *testing.T) {
+	// "привет" in windows-1251
+	cp1251 := []byte{0xef, 0xf0, 0xe8, 0xe2, 0xe5, 0xf2}
+
+	tests := []struct {
+		name        string
+		body        []byte
+		header      http.Header
+		want        []byte
+		wantCharset string
+	}{
+		{
+			name:        "charset parameter",
+			body:        cp1251,
+			header:      http.Header{"Content-Type": {"text/plain; charset=windows-1251"}},
+			want:        []byte("привет"),
+			wantCharset: "windows-1251",
+		},
+		{
+			name:        "meta tag in compressed html",
+			body:        encode(t, append([]byte(`<html><head><meta charset="windows-1251"></head><body>`), cp1251...), "gzip"),
+			header:      http.Header{"Content-Type": {"text/html"}, "Content-Encoding": {"gzip"}},
+			want:        []byte(`<html><head><meta charset="windows-1251"></head><body>привет`),
+			wantCharset: "windows-1251",
+		},
+		{
+			name:        "utf-8 json",
+			body:        []byte(`{"a":"привет"}`),
+			header:      http.Header{"Content-Type": {"application/json"}},
+			want:        []byte(`{"a":"привет"}`),
+			wantCharset: "utf-8",
+		},
+		{
+			name:   "binary is left alone",
+			body:   cp1251,
+			header: http.Header{"Content-Type": {"image/png"}},
+			want:   cp1251,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, charset, err := Decode(tt.body, tt.header)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) || charset != tt.wantCharset {
+				t.Errorf("Decode() = %q, %q, want %q, %q", got, charset, tt.want, tt.wantCharset)
+			}
+		})
+	}
+}
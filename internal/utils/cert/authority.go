@@ -0,0 +1,211 @@
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// formats the CA can be exported in
+const (
+	FormatPEM    = "pem"
+	FormatDER    = "der"
+	FormatPKCS12 = "p12"
+)
+
+// Authority is the proxy's CA. After a rotation the previous CA cross-signs the new one for a grace period,
+// so clients that only trust the previous CA still accept the leaves signed by the new one.
+type Authority struct {
+	certFile   string
+	keyFile    string
+	commonName string
+	grace      time.Duration
+
+	mu      sync.RWMutex
+	current *tls.Certificate
+	retired []RetiredCA
+}
+
+// RetiredCA is a CA replaced by a rotation that is still trusted until the end of its grace period
+type RetiredCA struct {
+	Cert *x509.Certificate
+	// the CA that replaced it, signed by this one
+	CrossSigned *x509.Certificate
+	GraceUntil  time.Time
+}
+
+// NewAuthority loads the CA from the files, generating it on first start.
+func NewAuthority(certFile, keyFile, commonName string, grace time.Duration) (*Authority, error) {
+	ca, err := LoadCA(certFile, keyFile, commonName)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		commonName: commonName,
+		grace:      grace,
+		current:    ca,
+	}, nil
+}
+
+// Current returns the CA leaves are signed with
+func (a *Authority) Current() *tls.Certificate {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.current
+}
+
+// Retired returns the previous CAs whose grace period hasn't ended yet
+func (a *Authority) Retired() []RetiredCA {
+	now := time.Now()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	res := make([]RetiredCA, 0, len(a.retired))
+	for _, retired := range a.retired {
+		if now.Before(retired.GraceUntil) {
+			res = append(res, retired)
+		}
+	}
+	return res
+}
+
+// Intermediates returns the cross-signed certificates sent along with the leaves during grace periods
+func (a *Authority) Intermediates() [][]byte {
+	retired := a.Retired()
+	res := make([][]byte, 0, len(retired))
+	for _, r := range retired {
+		res = append(res, r.CrossSigned.Raw)
+	}
+	return res
+}
+
+// Rotate replaces the CA with a newly generated one, which is written to the CA files.
+// The replaced CA cross-signs the new one until the grace period ends.
+func (a *Authority) Rotate() (*tls.Certificate, error) {
+	certPEM, keyPEM, err := GenCA(a.commonName)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating CA")
+	}
+	next, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing CA certificate from cert-PEM and key-PEM")
+	}
+	if next.Leaf, err = x509.ParseCertificate(next.Certificate[0]); err != nil {
+		return nil, errors.Wrap(err, "error parsing new CA")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	retired := make([]RetiredCA, 0, len(a.retired)+1)
+	if a.grace > 0 {
+		crossSigned, err := crossSign(a.current, next.Leaf, now.Add(a.grace))
+		if err != nil {
+			return nil, err
+		}
+		retired = append(retired, RetiredCA{Cert: a.current.Leaf, CrossSigned: crossSigned, GraceUntil: now.Add(a.grace)})
+	}
+	// the CAs retired before still lead to the new one through the cross-signature of the CA they were replaced by
+	for _, r := range a.retired {
+		if now.Before(r.GraceUntil) {
+			retired = append(retired, r)
+		}
+	}
+
+	if err = replaceFile(a.certFile, certPEM); err != nil {
+		return nil, errors.Wrap(err, "error writing cert-PEM to file")
+	}
+	if err = replaceFile(a.keyFile, keyPEM); err != nil {
+		return nil, errors.Wrap(err, "error writing key-PEM to file")
+	}
+	a.current = &next
+	a.retired = retired
+	return a.current, nil
+}
+
+// Export encodes the current CA certificate in the given format, password protects PKCS#12 files.
+// It returns the encoded certificate and its content type.
+func (a *Authority) Export(format, password string) ([]byte, string, error) {
+	ca := a.Current().Leaf
+	switch format {
+	case FormatPEM:
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), "application/x-pem-file", nil
+	case FormatDER:
+		return ca.Raw, "application/x-x509-ca-cert", nil
+	case FormatPKCS12:
+		// legacy 3DES keeps the trust store readable by older keychains and java, it only holds a public certificate
+		p12, err := pkcs12.LegacyDES.EncodeTrustStoreEntries([]pkcs12.TrustStoreEntry{{Cert: ca, FriendlyName: ca.Subject.CommonName}}, password)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "encode pkcs12 trust store error")
+		}
+		return p12, "application/x-pkcs12", nil
+	default:
+		return nil, "", errors.New("unknown CA format " + format)
+	}
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert the way openssl prints it
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// crossSign signs the public key and subject of next with ca, so that next chains up to ca until notAfter
+func crossSign(ca *tls.Certificate, next *x509.Certificate, notAfter time.Time) (*x509.Certificate, error) {
+	serialNumber, err := genSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               next.Subject,
+		SubjectKeyId:          next.SubjectKeyId,
+		NotBefore:             time.Now().Add(-1 * time.Hour).UTC(),
+		NotAfter:              notAfter.UTC(),
+		KeyUsage:              caUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SignatureAlgorithm:    x509.ECDSAWithSHA512,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, next.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error cross-signing new CA")
+	}
+	return x509.ParseCertificate(der)
+}
+
+// replaceFile writes data next to path and renames it over path, which works for the read-only CA files too
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0400); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
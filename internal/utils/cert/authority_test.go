@@ -0,0 +1,87 @@
+package cert
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func newTestAuthority(t *testing.T) *Authority {
+	t.Helper()
+	dir := t.TempDir()
+	a, err := NewAuthority(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), "test-proxy-ca", 0)
+	if err != nil {
+		t.Fatalf("NewAuthority: %v", err)
+	}
+	return a
+}
+
+func TestExportPKCS12RoundTrip(t *testing.T) {
+	a := newTestAuthority(t)
+	ca := a.Current().Leaf
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{"empty password", ""},
+		{"ascii password", "secret"},
+		{"non-ascii password", "пароль"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p12, contentType, err := a.Export(FormatPKCS12, tt.password)
+			if err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			if contentType != "application/x-pkcs12" {
+				t.Errorf("content type = %q", contentType)
+			}
+
+			certs, err := pkcs12.DecodeTrustStore(p12, tt.password)
+			if err != nil {
+				t.Fatalf("DecodeTrustStore: %v", err)
+			}
+			if len(certs) != 1 || !certs[0].Equal(ca) {
+				t.Fatalf("decoded %d certificates, want the CA alone", len(certs))
+			}
+			if _, err = pkcs12.DecodeTrustStore(p12, tt.password+"wrong"); err == nil {
+				t.Error("decoded with a wrong password")
+			}
+		})
+	}
+}
+
+func TestExportPKCS12OpenSSL(t *testing.T) {
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	a := newTestAuthority(t)
+	p12, _, err := a.Export(FormatPKCS12, "secret")
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "ca.p12")
+	if err = os.WriteFile(file, p12, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(opensslPath, "pkcs12", "-info", "-nokeys", "-in", file, "-passin", "pass:secret").CombinedOutput()
+	if err != nil {
+		t.Fatalf("openssl pkcs12 -info: %v\n%s", err, out)
+	}
+	for _, want := range []string{"friendlyName: test-proxy-ca", "BEGIN CERTIFICATE"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("openssl output lacks %q:\n%s", want, out)
+		}
+	}
+	// openssl fails when the mac doesn't verify
+	if out, err = exec.Command(opensslPath, "pkcs12", "-info", "-nokeys", "-in", file, "-passin", "pass:wrong").CombinedOutput(); err == nil {
+		t.Errorf("openssl accepted a wrong password:\n%s", out)
+	}
+}
@@ -0,0 +1,251 @@
+package cert
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/pkg/errors"
+)
+
+// leaf key types
+const (
+	KeyP256    = "p256"
+	KeyP521    = "p521"
+	KeyRSA2048 = "rsa2048"
+	KeyEd25519 = "ed25519"
+)
+
+const (
+	defaultCacheSize = 1000
+	// cached leaves are renewed in the background once they get this close to expiry
+	leafRenewBefore = leafMaxAge / 4
+	// leaves this close to expiry aren't handed out anymore
+	leafMinRemaining = time.Minute
+)
+
+// Cache hands out leaves signed by the CA, keyed by server name or by the mirrored upstream leaf.
+// Every leaf is signed at most once at a time, the least recently used ones are dropped when the cache is full.
+type Cache struct {
+	ca      *Authority
+	keyType string
+	size    int
+	// copy the upstream leaf's fields instead of signing a leaf for the server name only
+	mirror bool
+	// key pairs generated ahead of time
+	keys chan crypto.Signer
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	pending map[string]*pendingLeaf
+}
+
+type cacheEntry struct {
+	key  string
+	leaf *tls.Certificate
+	// the CA the leaf is signed by, leaves of a rotated CA are signed again
+	signer *tls.Certificate
+	// mirrored leaves keep the upstream's validity window and are never renewed
+	renew bool
+}
+
+// pendingLeaf is a leaf being signed, the callers asking for the same name wait for done
+type pendingLeaf struct {
+	done chan struct{}
+	leaf *tls.Certificate
+	err  error
+}
+
+func NewCache(ca *Authority, conf *config.LeafCertConfig) (*Cache, error) {
+	keyType := strings.ToLower(conf.KeyType)
+	if keyType == "" {
+		keyType = KeyP256
+	}
+	if _, ok := keyGenerators[keyType]; !ok {
+		return nil, errors.New("unknown leaf key type " + keyType)
+	}
+	size := conf.CacheSize
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	c := &Cache{
+		ca:      ca,
+		keyType: keyType,
+		size:    size,
+		mirror:  conf.Mirror,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		pending: map[string]*pendingLeaf{},
+	}
+	if conf.PoolSize > 0 {
+		c.keys = make(chan crypto.Signer, conf.PoolSize)
+		go c.fillKeyPool()
+	}
+	return c, nil
+}
+
+// Leaf returns the leaf to present for name: a mirror of the upstream leaf when mirroring is on
+// and the upstream sent one, a leaf for name only otherwise.
+func (c *Cache) Leaf(name string, upstream *x509.Certificate) (*tls.Certificate, error) {
+	if c.mirror && upstream != nil {
+		return c.GetMirrored(upstream)
+	}
+	return c.Get(name)
+}
+
+// Get returns a leaf for name, signing one when the cache has none that is fresh enough.
+func (c *Cache) Get(name string) (*tls.Certificate, error) {
+	name = strings.ToLower(name)
+	return c.get(name, true, func(ca *tls.Certificate, key crypto.Signer) (*tls.Certificate, error) {
+		return genCertWithKey(ca, key, name)
+	})
+}
+
+// GetMirrored returns a leaf that only differs from the upstream leaf in issuer and key.
+func (c *Cache) GetMirrored(upstream *x509.Certificate) (*tls.Certificate, error) {
+	sum := sha256.Sum256(upstream.Raw)
+	return c.get("mirror:"+hex.EncodeToString(sum[:]), false, func(ca *tls.Certificate, key crypto.Signer) (*tls.Certificate, error) {
+		return genMirroredCert(ca, key, upstream)
+	})
+}
+
+func (c *Cache) get(cacheKey string, renew bool, issue func(ca *tls.Certificate, key crypto.Signer) (*tls.Certificate, error)) (*tls.Certificate, error) {
+	now := time.Now()
+	ca := c.ca.Current()
+
+	c.mu.Lock()
+	if elem, ok := c.entries[cacheKey]; ok && elem.Value.(*cacheEntry).signer == ca {
+		entry := elem.Value.(*cacheEntry)
+		if !entry.renew || now.Before(entry.leaf.Leaf.NotAfter.Add(-leafRenewBefore)) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return c.withIntermediates(entry.leaf), nil
+		}
+		if now.Before(entry.leaf.Leaf.NotAfter.Add(-leafMinRemaining)) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			go c.sign(cacheKey, renew, issue)
+			return c.withIntermediates(entry.leaf), nil
+		}
+	}
+	c.mu.Unlock()
+	leaf, err := c.sign(cacheKey, renew, issue)
+	if err != nil {
+		return nil, err
+	}
+	return c.withIntermediates(leaf), nil
+}
+
+// withIntermediates adds the CA's cross-signatures to the chain of leaf while a rotation's grace period lasts
+func (c *Cache) withIntermediates(leaf *tls.Certificate) *tls.Certificate {
+	intermediates := c.ca.Intermediates()
+	if len(intermediates) == 0 {
+		return leaf
+	}
+	chained := *leaf
+	chained.Certificate = append(append([][]byte{}, leaf.Certificate...), intermediates...)
+	return &chained
+}
+
+// sign signs a new leaf and caches it, waiting for the one being signed if there is one
+func (c *Cache) sign(cacheKey string, renew bool, issue func(ca *tls.Certificate, key crypto.Signer) (*tls.Certificate, error)) (*tls.Certificate, error) {
+	c.mu.Lock()
+	if pending, ok := c.pending[cacheKey]; ok {
+		c.mu.Unlock()
+		<-pending.done
+		return pending.leaf, pending.err
+	}
+	pending := &pendingLeaf{done: make(chan struct{})}
+	c.pending[cacheKey] = pending
+	c.mu.Unlock()
+
+	signer := c.ca.Current()
+	key, err := c.nextKey()
+	if err == nil {
+		pending.leaf, err = issue(signer, key)
+	}
+	pending.err = err
+
+	c.mu.Lock()
+	delete(c.pending, cacheKey)
+	if err == nil {
+		c.add(&cacheEntry{key: cacheKey, leaf: pending.leaf, signer: signer, renew: renew})
+	}
+	c.mu.Unlock()
+	close(pending.done)
+	return pending.leaf, pending.err
+}
+
+// add caches entry, the caller holds c.mu
+func (c *Cache) add(entry *cacheEntry) {
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// nextKey takes a key pair from the pool, generating one right away when the pool is empty
+func (c *Cache) nextKey() (crypto.Signer, error) {
+	select {
+	case key := <-c.keys:
+		return key, nil
+	default:
+		return GenKey(c.keyType)
+	}
+}
+
+// fillKeyPool keeps the key pool full for the lifetime of the process
+func (c *Cache) fillKeyPool() {
+	for {
+		key, err := GenKey(c.keyType)
+		if err != nil {
+			return
+		}
+		c.keys <- key
+	}
+}
+
+var keyGenerators = map[string]func() (crypto.Signer, error){
+	KeyP256: func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	},
+	KeyP521: func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	},
+	KeyRSA2048: func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	},
+	KeyEd25519: func() (crypto.Signer, error) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	},
+}
+
+// GenKey generates a leaf key pair of the given type
+func GenKey(keyType string) (crypto.Signer, error) {
+	generate, ok := keyGenerators[keyType]
+	if !ok {
+		return nil, errors.New("unknown leaf key type " + keyType)
+	}
+	return generate()
+}
@@ -0,0 +1,270 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+)
+
+func newTestCache(t *testing.T, conf config.LeafCertConfig) *Cache {
+	t.Helper()
+	c, err := NewCache(newTestAuthority(t), &conf)
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	return c
+}
+
+func mustGet(t *testing.T, c *Cache, name string) *tls.Certificate {
+	t.Helper()
+	leaf, err := c.Get(name)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", name, err)
+	}
+	return leaf
+}
+
+// cachedKeys returns the cache keys from the most to the least recently used
+func cachedKeys(c *Cache) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*cacheEntry).key)
+	}
+	return keys
+}
+
+func cachedLeaf(c *Cache, key string) *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		return elem.Value.(*cacheEntry).leaf
+	}
+	return nil
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := newTestCache(t, config.LeafCertConfig{CacheSize: 2})
+
+	a := mustGet(t, c, "a.example.com")
+	b := mustGet(t, c, "b.example.com")
+	if again := mustGet(t, c, "A.example.com"); again != a {
+		t.Fatal("a cached leaf was signed again")
+	}
+	mustGet(t, c, "c.example.com")
+
+	want := []string{"c.example.com", "a.example.com"}
+	if got := cachedKeys(c); !reflect.DeepEqual(got, want) {
+		t.Fatalf("cached = %q, want %q", got, want)
+	}
+	if again := mustGet(t, c, "a.example.com"); again != a {
+		t.Error("the recently used leaf was evicted")
+	}
+	if again := mustGet(t, c, "b.example.com"); again == b {
+		t.Error("the least recently used leaf was kept")
+	}
+	if got := cachedKeys(c); !reflect.DeepEqual(got, []string{"b.example.com", "a.example.com"}) {
+		t.Errorf("cached = %q after signing b again", got)
+	}
+}
+
+func TestCacheConcurrentLeaf(t *testing.T) {
+	upstream := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "www.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		Raw:          []byte("upstream leaf"),
+	}
+	tests := []struct {
+		name     string
+		mirror   bool
+		upstream *x509.Certificate
+	}{
+		{"by name", false, nil},
+		{"by name without an upstream leaf", true, nil},
+		{"mirrored", true, upstream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t, config.LeafCertConfig{Mirror: tt.mirror, PoolSize: 2})
+
+			const callers = 32
+			leaves := make([]*tls.Certificate, callers)
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					<-start
+					leaf, err := c.Leaf("example.com", tt.upstream)
+					if err != nil {
+						t.Errorf("Leaf: %v", err)
+						return
+					}
+					leaves[i] = leaf
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			for i, leaf := range leaves {
+				if leaf != leaves[0] {
+					t.Fatalf("caller %d got another certificate", i)
+				}
+			}
+			if keys := cachedKeys(c); len(keys) != 1 {
+				t.Errorf("cached = %q, want a single leaf", keys)
+			}
+			if tt.upstream != nil && !reflect.DeepEqual(leaves[0].Leaf.DNSNames, tt.upstream.DNSNames) {
+				t.Errorf("mirrored names = %q, want %q", leaves[0].Leaf.DNSNames, tt.upstream.DNSNames)
+			}
+		})
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining time.Duration
+		// the cached leaf is handed out once more
+		wantSame bool
+		// a new leaf is signed, in the background when the cached one is handed out
+		wantRenewed bool
+	}{
+		{"fresh", leafMaxAge - time.Hour, true, false},
+		{"close to expiry", leafRenewBefore / 2, true, true},
+		{"about to expire", leafMinRemaining / 2, false, true},
+		{"expired", -time.Hour, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t, config.LeafCertConfig{})
+			old := mustGet(t, c, "example.com")
+			old.Leaf.NotAfter = time.Now().Add(tt.remaining)
+
+			got := mustGet(t, c, "example.com")
+			if (got == old) != tt.wantSame {
+				t.Fatalf("cached leaf handed out = %v, want %v", got == old, tt.wantSame)
+			}
+			if !tt.wantRenewed {
+				if cachedLeaf(c, "example.com") != old {
+					t.Error("a fresh leaf was replaced")
+				}
+				return
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for cachedLeaf(c, "example.com") == old {
+				if time.Now().After(deadline) {
+					t.Fatal("the leaf wasn't renewed")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			renewed := cachedLeaf(c, "example.com")
+			if renewed.Leaf.SerialNumber.Cmp(old.Leaf.SerialNumber) == 0 {
+				t.Error("the renewed leaf kept the serial number")
+			}
+			if time.Until(renewed.Leaf.NotAfter) < leafMaxAge-2*time.Hour {
+				t.Errorf("the renewed leaf expires at %v", renewed.Leaf.NotAfter)
+			}
+		})
+	}
+}
+
+func TestCacheMirroredLeafIsNotRenewed(t *testing.T) {
+	c := newTestCache(t, config.LeafCertConfig{Mirror: true})
+	upstream := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Minute / 2),
+		Raw:          []byte("upstream leaf close to expiry"),
+	}
+	first, err := c.Leaf("example.com", upstream)
+	if err != nil {
+		t.Fatalf("Leaf: %v", err)
+	}
+	second, err := c.Leaf("example.com", upstream)
+	if err != nil {
+		t.Fatalf("Leaf: %v", err)
+	}
+	if second != first {
+		t.Error("a mirrored leaf was signed again")
+	}
+	if !first.Leaf.NotAfter.Equal(upstream.NotAfter.Truncate(time.Second)) {
+		t.Errorf("mirrored leaf expires at %v, want %v", first.Leaf.NotAfter, upstream.NotAfter)
+	}
+}
+
+func TestCacheSignsAgainAfterRotation(t *testing.T) {
+	c := newTestCache(t, config.LeafCertConfig{})
+	old := mustGet(t, c, "example.com")
+	ca, err := c.ca.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	leaf := mustGet(t, c, "example.com")
+	if leaf == old {
+		t.Fatal("the leaf of the rotated CA is still handed out")
+	}
+	if err = leaf.Leaf.CheckSignatureFrom(ca.Leaf); err != nil {
+		t.Errorf("leaf is not signed by the new CA: %v", err)
+	}
+}
+
+func TestCacheKeyTypes(t *testing.T) {
+	tests := []struct {
+		keyType string
+		check   func(pub interface{}) bool
+	}{
+		// P-256 is the default, leaves used to get a P-521 key
+		{"", isCurve(elliptic.P256())},
+		{"P256", isCurve(elliptic.P256())},
+		{KeyP521, isCurve(elliptic.P521())},
+		{KeyRSA2048, func(pub interface{}) bool {
+			key, ok := pub.(*rsa.PublicKey)
+			return ok && key.N.BitLen() == 2048
+		}},
+		{KeyEd25519, func(pub interface{}) bool {
+			_, ok := pub.(ed25519.PublicKey)
+			return ok
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			for _, poolSize := range []int{0, 1} {
+				c := newTestCache(t, config.LeafCertConfig{KeyType: tt.keyType, PoolSize: poolSize})
+				leaf := mustGet(t, c, "example.com")
+				if !tt.check(leaf.Leaf.PublicKey) {
+					t.Errorf("pool size %d: leaf key is %T", poolSize, leaf.Leaf.PublicKey)
+				}
+			}
+		})
+	}
+
+	if _, err := NewCache(newTestAuthority(t), &config.LeafCertConfig{KeyType: "dsa"}); err == nil {
+		t.Error("NewCache accepted an unknown key type")
+	}
+}
+
+func isCurve(curve elliptic.Curve) func(pub interface{}) bool {
+	return func(pub interface{}) bool {
+		key, ok := pub.(*ecdsa.PublicKey)
+		return ok && key.Curve == curve
+	}
+}
@@ -1,6 +1,7 @@
 package cert
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -10,6 +11,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
 	"os"
 	"time"
 
@@ -30,15 +32,15 @@ const (
 	leafUsage = caUsage
 )
 
-func GenCert(ca *tls.Certificate, names ...string) (*tls.Certificate, error) {
+// genCertWithKey signs a leaf for names with the CA, key is the leaf's own key pair
+func genCertWithKey(ca *tls.Certificate, key crypto.Signer, names ...string) (*tls.Certificate, error) {
 	now := time.Now().Add(-1 * time.Hour).UTC()
 	if !ca.Leaf.IsCA {
 		return nil, errors.New("CA cert is not a CA")
 	}
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	serialNumber, err := genSerialNumber()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+		return nil, err
 	}
 	tmpl := &x509.Certificate{
 		SerialNumber:          serialNumber,
@@ -47,32 +49,81 @@ func GenCert(ca *tls.Certificate, names ...string) (*tls.Certificate, error) {
 		NotAfter:              now.Add(leafMaxAge),
 		KeyUsage:              leafUsage,
 		BasicConstraintsValid: true,
-		DNSNames:              names,
 		SignatureAlgorithm:    x509.ECDSAWithSHA512,
 	}
-	key, err := genKeyPair()
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+	x, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, key.Public(), ca.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
-	x, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, key.Public(), ca.PrivateKey)
+	return signedLeaf(x, key), nil
+}
+
+// genMirroredCert signs a leaf that copies upstream's subject, SANs, validity and usages,
+// so that it only differs from the upstream leaf in issuer, key and serial number.
+// The serial is always fresh: the issuer and serial pair must stay unique among the certificates of the CA.
+func genMirroredCert(ca *tls.Certificate, key crypto.Signer, upstream *x509.Certificate) (*tls.Certificate, error) {
+	if !ca.Leaf.IsCA {
+		return nil, errors.New("CA cert is not a CA")
+	}
+	serialNumber, err := genSerialNumber()
 	if err != nil {
 		return nil, err
 	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               upstream.Subject,
+		NotBefore:             upstream.NotBefore,
+		NotAfter:              upstream.NotAfter,
+		KeyUsage:              upstream.KeyUsage,
+		ExtKeyUsage:           upstream.ExtKeyUsage,
+		UnknownExtKeyUsage:    upstream.UnknownExtKeyUsage,
+		BasicConstraintsValid: true,
+		DNSNames:              upstream.DNSNames,
+		IPAddresses:           upstream.IPAddresses,
+		EmailAddresses:        upstream.EmailAddresses,
+		URIs:                  upstream.URIs,
+		SignatureAlgorithm:    x509.ECDSAWithSHA512,
+	}
+	x, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing mirrored leaf")
+	}
+	return signedLeaf(x, key), nil
+}
+
+func signedLeaf(x []byte, key crypto.Signer) *tls.Certificate {
 	cert := new(tls.Certificate)
 	cert.Certificate = append(cert.Certificate, x)
 	cert.PrivateKey = key
 	cert.Leaf, _ = x509.ParseCertificate(x)
-	return cert, nil
+	return cert
 }
 
-func genKeyPair() (*ecdsa.PrivateKey, error) {
-	return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+func genSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+	return serialNumber, nil
 }
 
 func GenCA(name string) (certPEM, keyPEM []byte, err error) {
 	now := time.Now().UTC()
+	// a rotated CA must not reuse the issuer name and serial number of the one it replaces
+	serialNumber, err := genSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
 	tmpl := &x509.Certificate{
-		SerialNumber:          big.NewInt(1),
+		SerialNumber:          serialNumber,
 		Subject:               pkix.Name{CommonName: name},
 		NotBefore:             now,
 		NotAfter:              now.Add(caMaxAge),
@@ -82,7 +133,8 @@ func GenCA(name string) (certPEM, keyPEM []byte, err error) {
 		MaxPathLen:            2,
 		SignatureAlgorithm:    x509.ECDSAWithSHA512,
 	}
-	key, err := genKeyPair()
+	// the CA key stays P-521, the leaf key type is set by the cache
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "error ganarating CA key pair")
 	}
@@ -0,0 +1,126 @@
+package clientcert
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/pkg/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+type identity struct {
+	host string
+	name string
+	cert tls.Certificate
+}
+
+// Store holds the client certificates presented to the upstream servers that ask for one.
+// A nil *Store presents none.
+type Store struct {
+	identities []identity
+}
+
+// Choice is the client certificate picked for one upstream connection
+type Choice struct {
+	// identity of the certificate, empty when no certificate is configured for the host
+	Name string
+	// whether the upstream asked for the certificate during the handshake
+	Presented bool
+}
+
+func NewStore(confs []config.UpstreamClientCert) (*Store, error) {
+	s := &Store{}
+	for _, conf := range confs {
+		if _, err := path.Match(conf.Host, ""); err != nil {
+			return nil, errors.Wrap(err, "bad host glob "+conf.Host)
+		}
+		cert, err := load(&conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "client certificate for "+conf.Host)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, errors.Wrap(err, "parse client certificate error")
+		}
+		name := conf.Name
+		if name == "" {
+			name = cert.Leaf.Subject.String()
+		}
+		s.identities = append(s.identities, identity{host: strings.ToLower(conf.Host), name: name, cert: cert})
+	}
+	return s, nil
+}
+
+func load(conf *config.UpstreamClientCert) (tls.Certificate, error) {
+	if conf.PKCS12 == "" {
+		return tls.LoadX509KeyPair(conf.Cert, conf.Key)
+	}
+	data, err := os.ReadFile(conf.PKCS12)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "read pkcs12 file error")
+	}
+	return decodePKCS12(data, conf.Password)
+}
+
+// decodePKCS12 decodes a PKCS#12 file with a key and its certificate chain,
+// encrypted with AES (the openssl 3 default) or with the legacy 3DES and RC2.
+func decodePKCS12(data []byte, password string) (tls.Certificate, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "decode pkcs12 error")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return tls.Certificate{}, errors.New("unsupported pkcs12 key type")
+	}
+	// the bags aren't ordered, the leaf is the certificate that goes with the key
+	certs := append([]*x509.Certificate{cert}, caCerts...)
+	for i, leaf := range certs {
+		if pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(signer.Public()) {
+			continue
+		}
+		chain := [][]byte{leaf.Raw}
+		for j, c := range certs {
+			if j != i {
+				chain = append(chain, c.Raw)
+			}
+		}
+		return tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+	}
+	return tls.Certificate{}, errors.New("no certificate in the pkcs12 file matches its key")
+}
+
+// Apply makes cfg present the client certificate of the upstream host when the upstream asks for one.
+// The returned choice tells whether it was presented once cfg completes a handshake.
+func (s *Store) Apply(cfg *tls.Config, host string) *Choice {
+	choice := &Choice{}
+	if s == nil {
+		return choice
+	}
+	host = strings.ToLower(host)
+	for i := range s.identities {
+		id := &s.identities[i]
+		if matched, _ := path.Match(id.host, host); !matched {
+			continue
+		}
+		choice.Name = id.name
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			choice.Presented = true
+			return &id.cert, nil
+		}
+		break
+	}
+	return choice
+}
+
+// Identity returns the identity presented to the upstream, empty if none was
+func (c *Choice) Identity() string {
+	if !c.Presented {
+		return ""
+	}
+	return c.Name
+}
@@ -0,0 +1,186 @@
+package clientcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+type testIdentity struct {
+	key    *ecdsa.PrivateKey
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+}
+
+func newTestIdentity(t *testing.T) *testIdentity {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	return &testIdentity{key: key, leaf: leaf, issuer: ca}
+}
+
+// checkCertificate makes sure cert is the identity's leaf, its key and its issuer
+func (id *testIdentity) checkCertificate(t *testing.T, cert tls.Certificate) {
+	t.Helper()
+	if cert.Leaf == nil || !cert.Leaf.Equal(id.leaf) {
+		t.Fatal("leaf is not the certificate of the key")
+	}
+	if len(cert.Certificate) != 2 || !id.issuer.Equal(mustParse(t, cert.Certificate[1])) {
+		t.Fatalf("chain has %d certificates, want the leaf and its issuer", len(cert.Certificate))
+	}
+	if signer, ok := cert.PrivateKey.(crypto.Signer); !ok || !id.key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("private key is not the identity's")
+	}
+}
+
+func mustParse(t *testing.T, der []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestDecodePKCS12(t *testing.T) {
+	id := newTestIdentity(t)
+
+	tests := []struct {
+		name    string
+		encoder *pkcs12.Encoder
+		// the issuer is encoded first, as some tools order the bags
+		issuerFirst bool
+	}{
+		{"aes", pkcs12.Modern, false},
+		{"legacy rc2", pkcs12.LegacyRC2, false},
+		{"legacy 3des", pkcs12.LegacyDES, false},
+		{"issuer before the leaf", pkcs12.Modern, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, caCerts := id.leaf, []*x509.Certificate{id.issuer}
+			if tt.issuerFirst {
+				cert, caCerts = id.issuer, []*x509.Certificate{id.leaf}
+			}
+			data, err := tt.encoder.Encode(id.key, cert, caCerts, "secret")
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			decoded, err := decodePKCS12(data, "secret")
+			if err != nil {
+				t.Fatalf("decodePKCS12: %v", err)
+			}
+			id.checkCertificate(t, decoded)
+			if _, err = decodePKCS12(data, "wrong"); err == nil {
+				t.Error("decoded with a wrong password")
+			}
+		})
+	}
+}
+
+func TestDecodePKCS12OpenSSL(t *testing.T) {
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	id := newTestIdentity(t)
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(id.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, certFile, caFile := filepath.Join(dir, "client.key"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "ca.crt")
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	writePEM(t, certFile, "CERTIFICATE", id.leaf.Raw)
+	writePEM(t, caFile, "CERTIFICATE", id.issuer.Raw)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		// openssl 3 encrypts with AES-256-CBC and PBKDF2 by default
+		{"default", nil},
+		{"legacy", []string{"-legacy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p12File := filepath.Join(dir, tt.name+".p12")
+			args := append([]string{"pkcs12", "-export", "-inkey", keyFile, "-in", certFile, "-certfile", caFile,
+				"-out", p12File, "-passout", "pass:secret"}, tt.args...)
+			if out, err := exec.Command(opensslPath, args...).CombinedOutput(); err != nil {
+				t.Skipf("openssl pkcs12 -export %v: %v\n%s", tt.args, err, out)
+			}
+
+			store, err := NewStore([]config.UpstreamClientCert{{Host: "*.example.com", PKCS12: p12File, Password: "secret"}})
+			if err != nil {
+				t.Fatalf("NewStore: %v", err)
+			}
+			cfg := &tls.Config{}
+			choice := store.Apply(cfg, "api.example.com")
+			if choice.Name != "CN=test client" || cfg.GetClientCertificate == nil {
+				t.Fatalf("choice = %+v, want the test client", choice)
+			}
+			cert, _ := cfg.GetClientCertificate(&tls.CertificateRequestInfo{})
+			id.checkCertificate(t, *cert)
+			if choice.Identity() != "CN=test client" {
+				t.Errorf("identity = %q once presented", choice.Identity())
+			}
+		})
+	}
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
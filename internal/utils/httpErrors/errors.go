@@ -6,4 +6,23 @@ const (
 	UPSTREAM_UNAVAIBLE_ERR = "upstream service unavaible"
 	BAD_REQUEST_ID         = "request id should be positive number"
 	NO_SUCH_REQUEST        = "no such request"
+	BAD_OVERRIDES          = "bad request overrides"
+	BAD_FUZZ_CONFIG        = "bad fuzz config"
+	BAD_SORT_KEY           = "unknown sort key"
+	NO_SUCH_FUZZ_JOB       = "no such fuzz job"
+	BAD_MINE_CONFIG        = "bad param mining config"
+	NO_SUCH_MINE_JOB       = "no such param mining job"
+	NO_SUCH_SCAN_JOB       = "no such scan job"
+	NO_SUCH_RESPONSE       = "no response recorded for request"
+	NO_SUCH_WS_MESSAGE     = "no such websocket message"
+	DROPPED_BY_INTERCEPT   = "dropped by intercept"
+	NO_SUCH_HELD_ITEM      = "no such intercepted item"
+	BAD_INTERCEPT_SETTINGS = "bad intercept settings"
+	BAD_REWRITE_RULE       = "bad rewrite rule"
+	NO_SUCH_REWRITE_RULE   = "no such rewrite rule"
+	BAD_SCOPE              = "bad scope"
+	BAD_PASSTHROUGH        = "bad passthrough hosts"
+	BAD_CA_FORMAT          = "unknown CA format, expected pem, der or p12"
+	WS_HANDSHAKE_ERR       = "upstream rejected websocket handshake"
+	NO_SUCH_TLS_SESSION    = "no such tls session"
 )
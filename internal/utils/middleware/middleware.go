@@ -3,6 +3,7 @@ package middleware
 import (
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Natali-Skv/technopark_IS_http_proxy/internal/tools/logger"
@@ -28,13 +29,16 @@ func NewCommonMiddleware(logger *log.ServLogger) *CommonMiddleware {
 
 func (mw *CommonMiddleware) RequestIdMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		requestId++
-		currReqId := requestId
-		ctx.Set(RequestIdCtxKey, currReqId)
+		ctx.Set(RequestIdCtxKey, NextRequestId())
 		return next(ctx)
 	}
 }
 
+// NextRequestId returns a new request id, also for connections served outside of echo
+func NextRequestId() uint64 {
+	return atomic.AddUint64(&requestId, 1)
+}
+
 func GetRequestIdFromCtx(ctx echo.Context) uint64 {
 	reqId, ok := ctx.Get(RequestIdCtxKey).(uint64)
 	if !ok {
@@ -0,0 +1,214 @@
+package tlsverify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/cert"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
+	"github.com/pkg/errors"
+)
+
+// how the certificates of upstream servers are treated
+const (
+	// a certificate that doesn't verify fails the connection
+	ModeVerify = "verify"
+	// a certificate that doesn't verify is recorded, the connection goes on
+	ModeWarn = "warn"
+	// certificates aren't checked at all
+	ModeIgnore = "ignore"
+)
+
+type hostPolicy struct {
+	host  string
+	mode  string
+	roots *x509.CertPool
+}
+
+// Policy decides how the certificate of each upstream server is verified.
+// A nil *Policy verifies every certificate against the system roots.
+type Policy struct {
+	mode  string
+	roots *x509.CertPool
+	hosts []hostPolicy
+}
+
+// Check is the outcome of verifying one upstream connection, filled in during the handshake
+type Check struct {
+	Host       string
+	ServerName string
+	Mode       string
+	// certificates the upstream presented, leaf first
+	Chain []*x509.Certificate
+	// why the chain didn't verify, nil if it did or wasn't checked
+	Err error
+}
+
+// CertInfo describes a certificate of an upstream chain
+type CertInfo struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	DNSNames          []string  `json:"dns_names,omitempty"`
+	IPAddresses       []string  `json:"ip_addresses,omitempty"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+}
+
+func NewPolicy(conf *config.UpstreamTLSConfig) (*Policy, error) {
+	mode, err := parseMode(conf.Mode, ModeVerify)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{mode: mode}
+	if p.roots, err = loadRoots(nil, conf.Roots); err != nil {
+		return nil, err
+	}
+	for _, h := range conf.Hosts {
+		if _, err = path.Match(h.Host, ""); err != nil {
+			return nil, errors.Wrap(err, "bad host glob "+h.Host)
+		}
+		hp := hostPolicy{host: strings.ToLower(h.Host), roots: p.roots}
+		if hp.mode, err = parseMode(h.Mode, mode); err != nil {
+			return nil, err
+		}
+		if len(h.Roots) != 0 {
+			if hp.roots, err = loadRoots(p.roots, h.Roots); err != nil {
+				return nil, err
+			}
+		}
+		p.hosts = append(p.hosts, hp)
+	}
+	return p, nil
+}
+
+func parseMode(mode, fallback string) (string, error) {
+	switch mode {
+	case "":
+		return fallback, nil
+	case ModeVerify, ModeWarn, ModeIgnore:
+		return mode, nil
+	default:
+		return "", errors.New("unknown upstream tls mode " + mode)
+	}
+}
+
+// loadRoots adds the certificates of the pem files to base, the system roots when base is nil
+func loadRoots(base *x509.CertPool, files []string) (*x509.CertPool, error) {
+	if len(files) == 0 {
+		return base, nil
+	}
+	var pool *x509.CertPool
+	if base != nil {
+		pool = base.Clone()
+	} else if systemPool, err := x509.SystemCertPool(); err == nil {
+		pool = systemPool
+	} else {
+		pool = x509.NewCertPool()
+	}
+	for _, file := range files {
+		bundle, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "read root bundle error")
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("no certificates in root bundle " + file)
+		}
+	}
+	return pool, nil
+}
+
+func (p *Policy) lookup(host string) (string, *x509.CertPool) {
+	if p == nil {
+		return ModeVerify, nil
+	}
+	host = strings.ToLower(host)
+	for _, h := range p.hosts {
+		if matched, _ := path.Match(h.host, host); matched {
+			return h.mode, h.roots
+		}
+	}
+	return p.mode, p.roots
+}
+
+// Apply makes cfg verify the certificate of the upstream host by the policy.
+// The returned check is filled in once cfg completes a handshake.
+func (p *Policy) Apply(cfg *tls.Config, host string) *Check {
+	mode, roots := p.lookup(host)
+	check := &Check{Host: host, ServerName: cfg.ServerName, Mode: mode}
+	// the chain is verified by hand, so that a failure in warn mode can still be recorded
+	cfg.InsecureSkipVerify = true
+	if mode == ModeIgnore {
+		return check
+	}
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		// no server name is sent to an ip address, its certificate must still be issued for it
+		if check.ServerName = cs.ServerName; check.ServerName == "" {
+			check.ServerName = host
+		}
+		check.Chain = cs.PeerCertificates
+		check.Err = verifyChain(cs.PeerCertificates, check.ServerName, roots)
+		if check.Err != nil && mode == ModeVerify {
+			return check.Err
+		}
+		return nil
+	}
+	return check
+}
+
+func verifyChain(chain []*x509.Certificate, serverName string, roots *x509.CertPool) error {
+	if len(chain) == 0 {
+		return errors.New("upstream sent no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, intermediate := range chain[1:] {
+		opts.Intermediates.AddCert(intermediate)
+	}
+	_, err := chain[0].Verify(opts)
+	return err
+}
+
+// Failure returns the tls failure of a connection dialed with the checked config: the handshake error
+// if the handshake failed, otherwise the reason the chain didn't verify. It is nil for a sound connection
+// and for a server that couldn't be reached at all.
+func (c *Check) Failure(dialErr error) error {
+	if dialErr != nil {
+		var handshakeErr *upstream.HandshakeError
+		if errors.As(dialErr, &handshakeErr) {
+			return handshakeErr.Err
+		}
+		return nil
+	}
+	return c.Err
+}
+
+// DescribeChain describes the certificates of an upstream chain for storing
+func DescribeChain(chain []*x509.Certificate) []CertInfo {
+	res := make([]CertInfo, 0, len(chain))
+	for _, c := range chain {
+		info := CertInfo{
+			Subject:           c.Subject.String(),
+			Issuer:            c.Issuer.String(),
+			SerialNumber:      c.SerialNumber.String(),
+			NotBefore:         c.NotBefore,
+			NotAfter:          c.NotAfter,
+			DNSNames:          c.DNSNames,
+			FingerprintSHA256: cert.Fingerprint(c),
+		}
+		for _, ip := range c.IPAddresses {
+			info.IPAddresses = append(info.IPAddresses, ip.String())
+		}
+		res = append(res, info)
+	}
+	return res
+}
@@ -0,0 +1,180 @@
+package tlsverify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/Natali-Skv/technopark_IS_http_proxy/internal/utils/upstream"
+)
+
+// newSelfSignedServer starts a tls server presenting a self-signed certificate for upstream.test and 127.0.0.1,
+// it returns the server and a pem file holding its certificate
+func newSelfSignedServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "upstream.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"upstream.test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	rootFile := filepath.Join(t.TempDir(), "upstream.pem")
+	if err = os.WriteFile(rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return srv, rootFile
+}
+
+// handshake connects to srv with a config checked by p for host, sending serverName
+func handshake(t *testing.T, p *Policy, srv *httptest.Server, host, serverName string) (*Check, error) {
+	t.Helper()
+	cfg := &tls.Config{ServerName: serverName}
+	check := p.Apply(cfg, host)
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	tlsConn, err := upstream.Handshake(context.Background(), conn, cfg)
+	if err != nil {
+		conn.Close()
+		return check, err
+	}
+	tlsConn.Close()
+	return check, nil
+}
+
+func newTestPolicy(t *testing.T, conf config.UpstreamTLSConfig) *Policy {
+	t.Helper()
+	p, err := NewPolicy(&conf)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return p
+}
+
+func TestPolicyModes(t *testing.T) {
+	srv, rootFile := newSelfSignedServer(t)
+
+	verify := newTestPolicy(t, config.UpstreamTLSConfig{Mode: ModeVerify})
+	trusted := newTestPolicy(t, config.UpstreamTLSConfig{Roots: []string{rootFile}})
+	overridden := newTestPolicy(t, config.UpstreamTLSConfig{Hosts: []config.UpstreamTLSHost{
+		{Host: "*.example.com", Mode: ModeIgnore},
+		{Host: "UPSTREAM.test", Mode: ModeWarn},
+		{Host: "127.*", Roots: []string{rootFile}},
+	}})
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		// no server name is sent to an ip address
+		host     string
+		wantMode string
+		// the handshake fails
+		wantDialErr bool
+		// the check records why the chain didn't verify
+		wantCheckErr bool
+		// the check records the chain the server presented
+		wantChain bool
+	}{
+		{"verify fails a self-signed certificate", verify, "upstream.test", ModeVerify, true, true, true},
+		{"warn goes on and records the failure", newTestPolicy(t, config.UpstreamTLSConfig{Mode: ModeWarn}),
+			"upstream.test", ModeWarn, false, true, true},
+		{"ignore doesn't check", newTestPolicy(t, config.UpstreamTLSConfig{Mode: ModeIgnore}),
+			"upstream.test", ModeIgnore, false, false, false},
+		{"verify is the default", newTestPolicy(t, config.UpstreamTLSConfig{}), "upstream.test", ModeVerify, true, true, true},
+		{"nil policy verifies", nil, "upstream.test", ModeVerify, true, true, true},
+		{"verify against a trusted root", trusted, "upstream.test", ModeVerify, false, false, true},
+		{"trusted root for another name", trusted, "other.test", ModeVerify, true, true, true},
+		{"ip address verified against its certificate", trusted, "127.0.0.1", ModeVerify, false, false, true},
+		{"host override", overridden, "upstream.test", ModeWarn, false, true, true},
+		{"roots of a host", overridden, "127.0.0.1", ModeVerify, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverName := tt.host
+			if net.ParseIP(tt.host) != nil {
+				serverName = ""
+			}
+			check, dialErr := handshake(t, tt.policy, srv, tt.host, serverName)
+			if (dialErr != nil) != tt.wantDialErr {
+				t.Fatalf("handshake error = %v, want error %v", dialErr, tt.wantDialErr)
+			}
+			if check.Mode != tt.wantMode {
+				t.Errorf("mode = %q, want %q", check.Mode, tt.wantMode)
+			}
+			if (check.Err != nil) != tt.wantCheckErr {
+				t.Errorf("check error = %v, want error %v", check.Err, tt.wantCheckErr)
+			}
+			if (len(check.Chain) == 1) != tt.wantChain {
+				t.Errorf("recorded %d certificates, want the chain %v", len(check.Chain), tt.wantChain)
+			}
+			if tt.wantChain && check.ServerName != tt.host {
+				t.Errorf("checked server name = %q, want %q", check.ServerName, tt.host)
+			}
+			if failure := check.Failure(dialErr); (failure != nil) != tt.wantCheckErr {
+				t.Errorf("Failure() = %v, want error %v", failure, tt.wantCheckErr)
+			}
+		})
+	}
+}
+
+func TestFailureOfUnreachableServer(t *testing.T) {
+	check := &Check{Err: nil}
+	if failure := check.Failure(&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}); failure != nil {
+		t.Errorf("Failure() = %v for a server that couldn't be reached", failure)
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf config.UpstreamTLSConfig
+	}{
+		{"unknown mode", config.UpstreamTLSConfig{Mode: "trust"}},
+		{"unknown host mode", config.UpstreamTLSConfig{Hosts: []config.UpstreamTLSHost{{Host: "a", Mode: "trust"}}}},
+		{"bad host glob", config.UpstreamTLSConfig{Hosts: []config.UpstreamTLSHost{{Host: "[a"}}}},
+		{"missing root bundle", config.UpstreamTLSConfig{Roots: []string{filepath.Join(t.TempDir(), "missing.pem")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicy(&tt.conf); err == nil {
+				t.Error("NewPolicy accepted a broken config")
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Natali-Skv/technopark_IS_http_proxy/config"
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// rule url that sends matching hosts directly
+	Direct = "direct"
+
+	dialTimeout = 30 * time.Second
+)
+
+type rule struct {
+	host  string
+	proxy *url.URL
+}
+
+// Dialer connects to upstream servers either directly or through the configured upstream proxies.
+// A nil *Dialer connects directly.
+type Dialer struct {
+	rules []rule
+	// used when no rule matches
+	defaultProxy *url.URL
+	noProxy      []string
+	direct       *net.Dialer
+}
+
+func NewDialer(conf *config.UpstreamProxyConfig) (*Dialer, error) {
+	d := &Dialer{direct: &net.Dialer{Timeout: dialTimeout}}
+	var err error
+	if d.defaultProxy, err = parseProxyURL(conf.URL, conf); err != nil {
+		return nil, err
+	}
+	for _, r := range conf.Rules {
+		proxyURL, err := parseProxyURL(r.URL, conf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = path.Match(r.Host, ""); err != nil {
+			return nil, errors.Wrap(err, "bad host glob "+r.Host)
+		}
+		d.rules = append(d.rules, rule{host: strings.ToLower(r.Host), proxy: proxyURL})
+	}
+	for _, host := range conf.NoProxy {
+		if _, err = path.Match(host, ""); err != nil {
+			return nil, errors.Wrap(err, "bad no-proxy glob "+host)
+		}
+		d.noProxy = append(d.noProxy, strings.ToLower(host))
+	}
+	return d, nil
+}
+
+// parseProxyURL parses an upstream proxy url, nil means a direct connection.
+// The configured credentials are used for proxies without their own.
+func parseProxyURL(raw string, conf *config.UpstreamProxyConfig) (*url.URL, error) {
+	if raw == "" || raw == Direct {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad upstream proxy url")
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New("unsupported upstream proxy scheme " + proxyURL.Scheme)
+	}
+	if proxyURL.User == nil && conf.Username != "" {
+		proxyURL.User = url.UserPassword(conf.Username, conf.Password)
+	}
+	return proxyURL, nil
+}
+
+// Proxy returns the upstream proxy for host, nil when it is reached directly.
+func (d *Dialer) Proxy(host string) *url.URL {
+	if d == nil {
+		return nil
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, glob := range d.noProxy {
+		if matched, _ := path.Match(glob, host); matched {
+			return nil
+		}
+	}
+	for _, r := range d.rules {
+		if matched, _ := path.Match(r.host, host); matched || r.host == "" {
+			return r.proxy
+		}
+	}
+	return d.defaultProxy
+}
+
+// ProxyURL is an http.Transport Proxy function: plain http requests go to http proxies in absolute form.
+func (d *Dialer) ProxyURL(req *http.Request) (*url.URL, error) {
+	return d.Proxy(req.URL.Host), nil
+}
+
+// Transport returns an http.Transport forwarding through the upstream proxies.
+func (d *Dialer) Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = d.ProxyURL
+	return transport
+}
+
+// Dial connects to addr, tunnelling through the upstream proxy of its host if there is one.
+func (d *Dialer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyURL := d.Proxy(addr)
+	if proxyURL == nil {
+		return d.directDialer().DialContext(ctx, network, addr)
+	}
+	if proxyURL.Scheme == "socks5" {
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, d.directDialer())
+		if err != nil {
+			return nil, errors.Wrap(err, "socks5 dialer error")
+		}
+		return socks.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	}
+	return d.connect(ctx, proxyURL, addr)
+}
+
+// DialTLS connects to addr and completes a tls handshake with it.
+// Like tls.Dial, it takes the server name from addr when the config has none.
+func (d *Dialer) DialTLS(ctx context.Context, addr string, tlsConfig *tls.Config) (*tls.Conn, error) {
+	conn, err := d.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn, err := Handshake(ctx, conn, tlsConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// HandshakeError is a failed tls handshake with an upstream server, as opposed to a failure to reach it
+type HandshakeError struct {
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	return "tls handshake error: " + e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Handshake completes a tls handshake with the upstream server on conn
+func Handshake(ctx context.Context, conn net.Conn, tlsConfig *tls.Config) (*tls.Conn, error) {
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, &HandshakeError{Err: err}
+	}
+	return tlsConn, nil
+}
+
+// DialProxy connects to an http(s) upstream proxy itself, for requests sent to it in absolute form.
+func (d *Dialer) DialProxy(ctx context.Context, proxyURL *url.URL) (net.Conn, error) {
+	conn, err := d.directDialer().DialContext(ctx, "tcp", proxyAddr(proxyURL))
+	if err != nil {
+		return nil, errors.Wrap(err, "dial upstream proxy error")
+	}
+	if proxyURL.Scheme != "https" {
+		return conn, nil
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "upstream proxy tls handshake error")
+	}
+	return tlsConn, nil
+}
+
+// connect opens a CONNECT tunnel to addr through an http(s) proxy
+func (d *Dialer) connect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := d.DialProxy(ctx, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if auth := ProxyAuthorization(proxyURL); auth != "" {
+		req.Header.Set("Proxy-Authorization", auth)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "write connect request error")
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "read connect response error")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.New("upstream proxy refused connect: " + resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+func (d *Dialer) directDialer() *net.Dialer {
+	if d == nil || d.direct == nil {
+		return &net.Dialer{Timeout: dialTimeout}
+	}
+	return d.direct
+}
+
+// ProxyAuthorization returns the Proxy-Authorization header value for the proxy's credentials
+func ProxyAuthorization(proxyURL *url.URL) string {
+	if proxyURL.User == nil {
+		return ""
+	}
+	password, _ := proxyURL.User.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username()+":"+password))
+}
+
+func proxyAddr(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	if proxyURL.Scheme == "https" {
+		return net.JoinHostPort(proxyURL.Hostname(), "443")
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), "8080")
+}
+
+// bufferedConn keeps the bytes the proxy sent right after its CONNECT response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// AbsoluteForm rewrites the request line of a raw origin-form request to the absolute form
+// an http proxy expects and puts the proxy's credentials in place of any Proxy-Authorization it had.
+// A request line already in absolute form is kept.
+func AbsoluteForm(raw []byte, host string, proxyURL *url.URL) []byte {
+	lineEnd := strings.Index(string(raw), "\r\n")
+	if lineEnd < 0 {
+		return raw
+	}
+	requestLine := string(raw[:lineEnd])
+	if parts := strings.SplitN(requestLine, " ", 3); len(parts) == 3 && strings.HasPrefix(parts[1], "/") {
+		requestLine = parts[0] + " http://" + host + parts[1] + " " + parts[2]
+	}
+	res := make([]byte, 0, len(raw)+len(host)+64)
+	res = append(res, requestLine+"\r\n"...)
+	if auth := ProxyAuthorization(proxyURL); auth != "" {
+		res = append(res, "Proxy-Authorization: "+auth+"\r\n"...)
+	}
+	rest := raw[lineEnd+2:]
+	for len(rest) > 0 {
+		end := strings.Index(string(rest), "\r\n")
+		if end <= 0 {
+			// the empty line ending the header or a header without one
+			break
+		}
+		line := rest[:end+2]
+		rest = rest[end+2:]
+		name, _, _ := strings.Cut(string(line), ":")
+		if !strings.EqualFold(strings.TrimSpace(name), "Proxy-Authorization") {
+			res = append(res, line...)
+		}
+	}
+	return append(res, rest...)
+}
+
+// OriginForm rewrites the request line of a raw absolute-form request, as captured from proxy clients,
+// to the origin form servers expect and adds the Host header the request may lack.
+// A request line already in origin form is kept.
+func OriginForm(raw []byte, host string) []byte {
+	lineEnd := strings.Index(string(raw), "\r\n")
+	if lineEnd < 0 {
+		return raw
+	}
+	requestLine := string(raw[:lineEnd])
+	parts := strings.SplitN(requestLine, " ", 3)
+	if len(parts) != 3 {
+		return raw
+	}
+	// the target is cut by hand rather than parsed, so that the path goes out exactly as it was written
+	if schemeEnd := strings.Index(parts[1], "://"); schemeEnd > 0 && !strings.HasPrefix(parts[1], "/") {
+		authority, target := parts[1][schemeEnd+3:], "/"
+		if pathStart := strings.IndexAny(authority, "/?"); pathStart >= 0 {
+			authority, target = authority[:pathStart], authority[pathStart:]
+			if target[0] == '?' {
+				target = "/" + target
+			}
+		}
+		if at := strings.LastIndex(authority, "@"); at >= 0 {
+			authority = authority[at+1:]
+		}
+		if authority != "" {
+			host = authority
+		}
+		requestLine = parts[0] + " " + target + " " + parts[2]
+	}
+	rest := raw[lineEnd+2:]
+	hasHost := false
+	for header := rest; ; {
+		end := strings.Index(string(header), "\r\n")
+		if end <= 0 {
+			break
+		}
+		name, _, _ := strings.Cut(string(header[:end]), ":")
+		if strings.EqualFold(strings.TrimSpace(name), "Host") {
+			hasHost = true
+			break
+		}
+		header = header[end+2:]
+	}
+	res := make([]byte, 0, len(raw)+len(host)+16)
+	res = append(res, requestLine+"\r\n"...)
+	if !hasHost {
+		res = append(res, "Host: "+host+"\r\n"...)
+	}
+	return append(res, rest...)
+}
@@ -0,0 +1,222 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	OpContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xA
+
+	// frames and reassembled messages bigger than this are rejected instead of being buffered
+	MaxPayloadSize = 32 << 20
+)
+
+var ErrPayloadTooBig = errors.New("websocket payload too big")
+
+type Frame struct {
+	Fin     bool
+	Rsv     byte
+	Opcode  byte
+	Masked  bool
+	MaskKey [4]byte
+	// unmasked payload
+	Payload []byte
+}
+
+func (f *Frame) IsControl() bool {
+	return f.Opcode&0x8 != 0
+}
+
+func IsUpgradeRequest(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DisableExtensions drops extension negotiation from a handshake request,
+// so that frames (e.g. permessage-deflate ones) stay readable for the proxy.
+func DisableExtensions(r *http.Request) {
+	r.Header.Del("Sec-WebSocket-Extensions")
+}
+
+func NewKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "generating websocket key error")
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// WriteHandshakeResponse writes the status line and headers of a 101 response.
+// http.Response.Write is not used because it adds "Connection: close" to bodiless responses.
+func WriteHandshakeResponse(w io.Writer, resp *http.Response) error {
+	if _, err := fmt.Fprintf(w, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(w); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+func ReadFrame(r *bufio.Reader) (*Frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	f := &Frame{
+		Fin:    head[0]&0x80 != 0,
+		Rsv:    head[0] & 0x70,
+		Opcode: head[0] & 0x0F,
+		Masked: head[1]&0x80 != 0,
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > MaxPayloadSize {
+		return nil, ErrPayloadTooBig
+	}
+
+	if f.Masked {
+		if _, err := io.ReadFull(r, f.MaskKey[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	if f.Masked {
+		mask(f.Payload, f.MaskKey)
+	}
+	return f, nil
+}
+
+// WriteFrame serializes the frame, masking the payload with f.MaskKey when f.Masked is set.
+func WriteFrame(w io.Writer, f *Frame) error {
+	buf := make([]byte, 0, 14+len(f.Payload))
+	first := f.Rsv | f.Opcode
+	if f.Fin {
+		first |= 0x80
+	}
+	buf = append(buf, first)
+
+	var maskBit byte
+	if f.Masked {
+		maskBit = 0x80
+	}
+	length := len(f.Payload)
+	switch {
+	case length < 126:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xFFFF:
+		buf = append(buf, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(length))
+	default:
+		buf = append(buf, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(length))
+	}
+
+	if f.Masked {
+		buf = append(buf, f.MaskKey[:]...)
+		start := len(buf)
+		buf = append(buf, f.Payload...)
+		mask(buf[start:], f.MaskKey)
+	} else {
+		buf = append(buf, f.Payload...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// NewClientFrame builds a single final frame masked with a random key, as the client side must send.
+func NewClientFrame(opcode byte, payload []byte) (*Frame, error) {
+	f := &Frame{
+		Fin:     true,
+		Opcode:  opcode,
+		Masked:  true,
+		Payload: payload,
+	}
+	if _, err := rand.Read(f.MaskKey[:]); err != nil {
+		return nil, errors.Wrap(err, "generating mask key error")
+	}
+	return f, nil
+}
+
+func mask(b []byte, key [4]byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+// MessageBuilder reassembles fragmented data frames into whole messages.
+type MessageBuilder struct {
+	opcode  byte
+	payload []byte
+	started bool
+}
+
+// Add consumes a frame and returns the complete message once its final fragment has arrived.
+// Control frames are ignored.
+func (mb *MessageBuilder) Add(f *Frame) (opcode byte, payload []byte, done bool, err error) {
+	if f.IsControl() {
+		return 0, nil, false, nil
+	}
+	if f.Opcode != OpContinuation {
+		mb.opcode = f.Opcode
+		mb.payload = mb.payload[:0]
+		mb.started = true
+	} else if !mb.started {
+		return 0, nil, false, errors.New("continuation frame without message start")
+	}
+	if len(mb.payload)+len(f.Payload) > MaxPayloadSize {
+		return 0, nil, false, ErrPayloadTooBig
+	}
+	mb.payload = append(mb.payload, f.Payload...)
+	if !f.Fin {
+		return 0, nil, false, nil
+	}
+
+	mb.started = false
+	payload = make([]byte, len(mb.payload))
+	copy(payload, mb.payload)
+	return mb.opcode, payload, true, nil
+}
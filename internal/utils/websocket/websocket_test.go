@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFrameVectors(t *testing.T) {
+	// the examples of RFC 6455, section 5.7
+	maskKey := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	hello := []byte("Hello")
+	long := func(n int) []byte { return bytes.Repeat([]byte{0xab}, n) }
+
+	tests := []struct {
+		name  string
+		frame Frame
+		wire  []byte
+	}{
+		{
+			name:  "unmasked text",
+			frame: Frame{Fin: true, Opcode: OpText, Payload: hello},
+			wire:  []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f},
+		},
+		{
+			name:  "masked text",
+			frame: Frame{Fin: true, Opcode: OpText, Masked: true, MaskKey: maskKey, Payload: hello},
+			wire:  []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58},
+		},
+		{
+			name:  "first fragment",
+			frame: Frame{Opcode: OpText, Payload: []byte("Hel")},
+			wire:  []byte{0x01, 0x03, 0x48, 0x65, 0x6c},
+		},
+		{
+			name:  "last fragment",
+			frame: Frame{Fin: true, Opcode: OpContinuation, Payload: []byte("lo")},
+			wire:  []byte{0x80, 0x02, 0x6c, 0x6f},
+		},
+		{
+			name:  "unmasked ping",
+			frame: Frame{Fin: true, Opcode: OpPing, Payload: hello},
+			wire:  []byte{0x89, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f},
+		},
+		{
+			name:  "masked pong",
+			frame: Frame{Fin: true, Opcode: OpPong, Masked: true, MaskKey: maskKey, Payload: hello},
+			wire:  []byte{0x8a, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58},
+		},
+		{
+			name:  "256 bytes binary",
+			frame: Frame{Fin: true, Opcode: OpBinary, Payload: long(256)},
+			wire:  append([]byte{0x82, 0x7e, 0x01, 0x00}, long(256)...),
+		},
+		{
+			name:  "64KiB binary",
+			frame: Frame{Fin: true, Opcode: OpBinary, Payload: long(65536)},
+			wire:  append([]byte{0x82, 0x7f, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00}, long(65536)...),
+		},
+		{
+			name:  "reserved bits",
+			frame: Frame{Fin: true, Rsv: 0x40, Opcode: OpText, Payload: []byte{}},
+			wire:  []byte{0xc1, 0x00},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteFrame(&buf, &tt.frame); err != nil {
+				t.Fatalf("WriteFrame: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.wire) {
+				t.Errorf("WriteFrame() = % x, want % x", head(buf.Bytes()), head(tt.wire))
+			}
+
+			got, err := ReadFrame(bufio.NewReader(bytes.NewReader(tt.wire)))
+			if err != nil {
+				t.Fatalf("ReadFrame: %v", err)
+			}
+			if got.Fin != tt.frame.Fin || got.Rsv != tt.frame.Rsv || got.Opcode != tt.frame.Opcode ||
+				got.Masked != tt.frame.Masked || got.MaskKey != tt.frame.MaskKey || !bytes.Equal(got.Payload, tt.frame.Payload) {
+				t.Errorf("ReadFrame() = %+v, want %+v", head(got.Payload), head(tt.frame.Payload))
+			}
+		})
+	}
+}
+
+// head keeps failure messages of long frames readable
+func head(b []byte) []byte {
+	if len(b) > 16 {
+		return b[:16]
+	}
+	return b
+}
+
+func TestWriteFrameKeepsPayload(t *testing.T) {
+	payload := []byte("Hello")
+	f := &Frame{Fin: true, Opcode: OpText, Masked: true, MaskKey: [4]byte{1, 2, 3, 4}, Payload: payload}
+	if err := WriteFrame(io.Discard, f); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if string(payload) != "Hello" {
+		t.Errorf("payload masked in place: %q", payload)
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		wire []byte
+		want error
+	}{
+		{"empty", nil, io.EOF},
+		{"cut in the header", []byte{0x81}, io.ErrUnexpectedEOF},
+		{"cut in the extended length", []byte{0x82, 0x7e, 0x01}, io.ErrUnexpectedEOF},
+		{"cut in the mask key", []byte{0x81, 0x85, 0x37, 0xfa}, io.ErrUnexpectedEOF},
+		{"cut in the payload", []byte{0x81, 0x05, 0x48, 0x65}, io.ErrUnexpectedEOF},
+		{"payload too big", []byte{0x82, 0x7f, 0, 0, 0, 0, 0x02, 0, 0, 0x01}, ErrPayloadTooBig},
+		{"length with the top bit set", []byte{0x82, 0x7f, 0x80, 0, 0, 0, 0, 0, 0, 0}, ErrPayloadTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadFrame(bufio.NewReader(bytes.NewReader(tt.wire)))
+			if err != tt.want {
+				t.Errorf("ReadFrame() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageBuilder(t *testing.T) {
+	type result struct {
+		opcode  byte
+		payload string
+		done    bool
+		err     bool
+	}
+	tests := []struct {
+		name   string
+		frames []Frame
+		want   []result
+	}{
+		{
+			name:   "single frame",
+			frames: []Frame{{Fin: true, Opcode: OpText, Payload: []byte("Hello")}},
+			want:   []result{{OpText, "Hello", true, false}},
+		},
+		{
+			name: "fragments with a control frame in between",
+			frames: []Frame{
+				{Opcode: OpBinary, Payload: []byte("Hel")},
+				{Fin: true, Opcode: OpPing, Payload: []byte("ping")},
+				{Opcode: OpContinuation, Payload: []byte("l")},
+				{Fin: true, Opcode: OpContinuation, Payload: []byte("o")},
+			},
+			want: []result{{}, {}, {}, {OpBinary, "Hello", true, false}},
+		},
+		{
+			name: "messages one after another",
+			frames: []Frame{
+				{Opcode: OpText, Payload: []byte("a")},
+				{Fin: true, Opcode: OpContinuation, Payload: []byte("b")},
+				{Fin: true, Opcode: OpBinary, Payload: []byte("c")},
+			},
+			want: []result{{}, {OpText, "ab", true, false}, {OpBinary, "c", true, false}},
+		},
+		{
+			name: "continuation without a start",
+			frames: []Frame{
+				{Fin: true, Opcode: OpContinuation, Payload: []byte("lo")},
+			},
+			want: []result{{err: true}},
+		},
+		{
+			name: "continuation after a finished message",
+			frames: []Frame{
+				{Fin: true, Opcode: OpText, Payload: []byte("a")},
+				{Fin: true, Opcode: OpContinuation, Payload: []byte("b")},
+			},
+			want: []result{{OpText, "a", true, false}, {err: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := &MessageBuilder{}
+			for i := range tt.frames {
+				opcode, payload, done, err := mb.Add(&tt.frames[i])
+				got := result{opcode, string(payload), done, err != nil}
+				if got != tt.want[i] {
+					t.Errorf("frame %d: Add() = %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMessageBuilderTooBig(t *testing.T) {
+	mb := &MessageBuilder{}
+	if _, _, _, err := mb.Add(&Frame{Opcode: OpBinary, Payload: make([]byte, MaxPayloadSize)}); err != nil {
+		t.Fatalf("first fragment: %v", err)
+	}
+	if _, _, _, err := mb.Add(&Frame{Fin: true, Opcode: OpContinuation, Payload: []byte{0}}); err != ErrPayloadTooBig {
+		t.Errorf("Add() error = %v, want ErrPayloadTooBig", err)
+	}
+}
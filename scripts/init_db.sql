@@ -1,4 +1,29 @@
-drop table requests cascade;
+drop table if exists requests, responses, websocket_messages, fuzz_jobs, fuzz_attempts, scan_jobs, findings, mine_jobs, mined_params, tunnels, tls_failures, tls_sessions cascade;
+create table if not exists tls_failures(
+    id bigserial primary key,
+    host text,
+    port int,
+    server_name text,
+    mode text,
+    error text,
+    chain jsonb,
+    created timestamp default now()
+);
+create table if not exists tls_sessions(
+    id bigserial primary key,
+    sni text,
+    client_version text,
+    ja3 text,
+    ja3_hash text,
+    ja4 text,
+    cipher_suites text[],
+    alpn text[],
+    upstream_version text,
+    upstream_cipher text,
+    upstream_alpn text,
+    upstream_chain jsonb,
+    created timestamp default now()
+);
 create table if not exists requests(
     id bigserial primary key,
     method text,
@@ -7,8 +32,19 @@ create table if not exists requests(
     headers jsonb,
     cookies jsonb,
     post_params jsonb,
-    raw text,
-    is_https bool default false
+    body bytea,
+    body_truncated bool default false,
+    decoded_body bytea,
+    charset text,
+    raw bytea,
+    is_https bool default false,
+    protocol text,
+    parent_id bigint references requests(id),
+    rewrite_rules text[],
+    out_of_scope boolean default false,
+    tls_failure_id bigint references tls_failures(id),
+    client_cert text default '',
+    tls_session_id bigint references tls_sessions(id)
 );
 create table if not exists responses(
     id bigserial primary key,
@@ -16,5 +52,87 @@ create table if not exists responses(
     code int,
     message text,
     headers jsonb,
-    body text
+    body bytea,
+    body_truncated bool default false,
+    decoded_body bytea,
+    charset text,
+    raw bytea,
+    is_https bool default false,
+    protocol text
+);
+create table if not exists websocket_messages(
+    id bigserial primary key,
+    request_id bigint references requests(id),
+    from_client bool,
+    opcode int,
+    payload bytea,
+    created timestamp default now()
+);
+create table if not exists fuzz_jobs(
+    id bigserial primary key,
+    request_id bigint references requests(id),
+    mode text,
+    status text,
+    total int default 0,
+    created timestamp default now(),
+    finished timestamp
+);
+create table if not exists fuzz_attempts(
+    id bigserial primary key,
+    job_id bigint references fuzz_jobs(id),
+    payloads jsonb,
+    request bytea,
+    status int,
+    length int,
+    duration_ms bigint,
+    matches text[],
+    error text
+);
+create table if not exists scan_jobs(
+    id bigserial primary key,
+    request_id bigint references requests(id),
+    status text,
+    sent int default 0,
+    findings int default 0,
+    created timestamp default now(),
+    finished timestamp
+);
+create table if not exists findings(
+    id bigserial primary key,
+    request_id bigint references requests(id),
+    check_name text,
+    severity text,
+    description text,
+    evidence text,
+    scan_job_id bigint references scan_jobs(id),
+    created timestamp default now()
+);
+create table if not exists mine_jobs(
+    id bigserial primary key,
+    request_id bigint references requests(id),
+    status text,
+    tested int default 0,
+    found int default 0,
+    created timestamp default now(),
+    finished timestamp
+);
+create table if not exists mined_params(
+    id bigserial primary key,
+    job_id bigint references mine_jobs(id),
+    request_id bigint references requests(id),
+    name text,
+    reasons text[],
+    created timestamp default now()
+);
+create table if not exists tunnels(
+    id bigserial primary key,
+    kind text default 'passthrough',
+    host text,
+    port int,
+    sni text,
+    alpn text[],
+    bytes_up bigint,
+    bytes_down bigint,
+    duration_ms bigint,
+    created timestamp default now()
 );